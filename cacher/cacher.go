@@ -5,6 +5,7 @@ import (
 	"io"
 	neturl "net/url"
 	"os"
+	"path"
 	"strings"
 	"sync"
 	"time"
@@ -19,7 +20,8 @@ type httpCacher struct {
 
 	path string
 
-	defaultTTL time.Duration
+	defaultTTL     time.Duration
+	canonicalRules *CanonicalRules
 }
 
 // NewHTTPCacher returns a new http cacher instance
@@ -91,6 +93,22 @@ func (c *httpCacher) GetDefaultTTL() time.Duration {
 	return ttl
 }
 
+func (c *httpCacher) SetCanonicalRules(rules *CanonicalRules) {
+	c.mutex.Lock()
+	c.canonicalRules = rules
+	c.mutex.Unlock()
+
+	c.logger.WithField("rules", rules).Info("Updated cacher canonical rules")
+}
+
+func (c *httpCacher) GetCanonicalRules() *CanonicalRules {
+	c.mutex.Lock()
+	rules := c.canonicalRules
+	c.mutex.Unlock()
+
+	return rules
+}
+
 func (c *httpCacher) CheckCacheExists(url *neturl.URL) bool {
 	c.mutex.Lock()
 	fs := c.fs
@@ -256,10 +274,79 @@ func (c *httpCacher) Open(url *neturl.URL) (io.ReadCloser, error) {
 	return f, err
 }
 
+func (c *httpCacher) Remove(url *neturl.URL) error {
+	c.mutex.Lock()
+	fs := c.fs
+	c.mutex.Unlock()
+
+	cachePath := c.generateCachePath(url)
+	f, err := fs.OpenFile(cachePath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	err = fs.RemoveAll(cachePath)
+	if err == nil {
+		c.logger.WithFields(logrus.Fields{
+			"url":  url,
+			"path": cachePath,
+		}).Info("Removed cache")
+	}
+
+	return err
+}
+
+func (c *httpCacher) Walk(f func(*Entry) error) error {
+	c.mutex.Lock()
+	fs := c.fs
+	rootPath := c.path
+	c.mutex.Unlock()
+
+	return c.walkDir(fs, rootPath, f)
+}
+
+func (c *httpCacher) walkDir(fs Fs, dir string, f func(*Entry) error) error {
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		infoPath := path.Join(dir, info.Name())
+		if info.IsDir() {
+			if err := c.walkDir(fs, infoPath, f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry := readHTTPEntry(fs, infoPath)
+		if entry == nil {
+			c.logger.WithField("path", infoPath).Debug("Skipped non cache file")
+			continue
+		}
+		entry.Size = info.Size()
+
+		if err := f(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (c *httpCacher) generateCachePath(url *neturl.URL) string {
 	c.mutex.Lock()
 	path := c.path
+	canonicalRules := c.canonicalRules
 	c.mutex.Unlock()
 
+	if canonicalRules != nil && url != nil {
+		canonicalURL := *url
+		canonicalRules.Canonicalize(&canonicalURL)
+		url = &canonicalURL
+	}
+
 	return GenerateHTTPCachePath(path, url)
 }
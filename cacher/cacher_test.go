@@ -1,6 +1,7 @@
 package cacher_test
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -70,6 +71,18 @@ var _ = Describe("HttpCacher", func() {
 		Expect(c.GetDefaultTTL()).To(Equal(ttl))
 	})
 
+	It("should use canonical rules", func() {
+		url1, _ := url.Parse("http://DOMAIN.com/cacher/canonical?b=2&utm_source=x&a=1")
+		url2, _ := url.Parse("http://domain.com/cacher/canonical?a=1&b=2")
+		rules := &CanonicalRules{LowercaseHost: true, DropQueryKeys: []string{"utm_*"}}
+		c := newHttpCacherWithRootPath()
+		c.SetCanonicalRules(rules)
+		c.Write(&Input{URL: url1, StatusCode: 200})
+
+		Expect(c.GetCanonicalRules()).To(Equal(rules))
+		Expect(c.CheckCacheExists(url2)).To(BeTrue())
+	})
+
 	Describe("CheckCacheExists", func() {
 		It("should report cache exists", func() {
 			url, _ := url.Parse("http://domain.com/cacher/check/cache/exists")
@@ -296,4 +309,86 @@ var _ = Describe("HttpCacher", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+	Describe("Remove", func() {
+		It("should remove", func() {
+			url, _ := url.Parse("http://domain.com/cacher/remove/ok")
+			cachePath := GenerateHTTPCachePath(rootPath, url)
+			c := newHttpCacherWithRootPath()
+			c.Write(&Input{URL: url, StatusCode: 200})
+
+			err := c.Remove(url)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, readError := ioutil.ReadFile(cachePath)
+			Expect(readError).To(HaveOccurred())
+		})
+
+		It("should not remove (no file)", func() {
+			url, _ := url.Parse("http://domain.com/cacher/remove/error")
+
+			c := newHttpCacherWithRootPath()
+			err := c.Remove(url)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Walk", func() {
+		It("should walk", func() {
+			url1, _ := url.Parse("http://domain.com/cacher/walk/one")
+			url2, _ := url.Parse("http://domain.com/cacher/walk/two")
+			c := newHttpCacherWithRootPath()
+			c.Write(&Input{URL: url1, StatusCode: 200, Body: "foo/bar"})
+			c.WritePlaceholder(url2, time.Hour)
+
+			entries := make(map[string]*Entry)
+			err := c.Walk(func(entry *Entry) error {
+				entries[entry.URL.String()] = entry
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(entries)).To(Equal(2))
+
+			entry1 := entries[url1.String()]
+			Expect(entry1.Path).To(Equal(GenerateHTTPCachePath(rootPath, url1)))
+			Expect(entry1.StatusCode).To(Equal(200))
+			Expect(entry1.Size).To(BeNumerically(">", len("foo/bar")))
+			Expect(entry1.IsPlaceholder()).To(BeFalse())
+			Expect(entry1.IsExpired()).To(BeFalse())
+
+			entry2 := entries[url2.String()]
+			Expect(entry2.IsPlaceholder()).To(BeTrue())
+			Expect(entry2.Expires).ToNot(BeNil())
+		})
+
+		It("should skip non cache files", func() {
+			f, _ := CreateFile(fs, path.Join(rootPath, "not-cache"))
+			f.Write([]byte("foo/bar"))
+			f.Close()
+
+			count := 0
+			c := newHttpCacherWithRootPath()
+			err := c.Walk(func(*Entry) error {
+				count++
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(0))
+		})
+
+		It("should stop on error", func() {
+			for _, s := range []string{"one", "two"} {
+				url, _ := url.Parse("http://domain.com/cacher/walk/error/" + s)
+				newHttpCacherWithRootPath().Write(&Input{URL: url, StatusCode: 200})
+			}
+
+			count := 0
+			c := newHttpCacherWithRootPath()
+			err := c.Walk(func(*Entry) error {
+				count++
+				return errors.New("stop")
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(count).To(Equal(1))
+		})
+	})
 })
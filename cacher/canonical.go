@@ -0,0 +1,239 @@
+package cacher
+
+import (
+	"fmt"
+	neturl "net/url"
+	"sort"
+	"strings"
+)
+
+// CanonicalRules represents how urls are normalized so that equivalent urls
+// are crawled, cached and served only once
+type CanonicalRules struct {
+	LowercaseHost    bool
+	StripDefaultPort bool
+	DecodeUnreserved bool
+	SortQuery        bool
+	// DropQueryKeys removes query parameters, a trailing * matches any key with the prefix (e.g. utm_*)
+	DropQueryKeys []string
+	// MergeTrailingSlash removes the trailing slash of all paths except the root one
+	MergeTrailingSlash bool
+}
+
+const (
+	// CanonicalLowercaseHost option name of .LowercaseHost
+	CanonicalLowercaseHost = "lowercase-host"
+	// CanonicalStripDefaultPort option name of .StripDefaultPort
+	CanonicalStripDefaultPort = "strip-default-port"
+	// CanonicalDecodeUnreserved option name of .DecodeUnreserved
+	CanonicalDecodeUnreserved = "decode-unreserved"
+	// CanonicalSortQuery option name of .SortQuery
+	CanonicalSortQuery = "sort-query"
+	// CanonicalMergeTrailingSlash option name of .MergeTrailingSlash
+	CanonicalMergeTrailingSlash = "merge-trailing-slash"
+)
+
+var canonicalDefaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// SetOptions enables the named options and disables the others
+func (r *CanonicalRules) SetOptions(names []string) error {
+	options := CanonicalRules{DropQueryKeys: r.DropQueryKeys}
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "":
+		case CanonicalLowercaseHost:
+			options.LowercaseHost = true
+		case CanonicalStripDefaultPort:
+			options.StripDefaultPort = true
+		case CanonicalDecodeUnreserved:
+			options.DecodeUnreserved = true
+		case CanonicalSortQuery:
+			options.SortQuery = true
+		case CanonicalMergeTrailingSlash:
+			options.MergeTrailingSlash = true
+		default:
+			return fmt.Errorf("unknown option %q", name)
+		}
+	}
+
+	*r = options
+	return nil
+}
+
+// GetOptions returns the names of the enabled options
+func (r *CanonicalRules) GetOptions() []string {
+	names := make([]string, 0)
+	for _, option := range []struct {
+		enabled bool
+		name    string
+	}{
+		{r.LowercaseHost, CanonicalLowercaseHost},
+		{r.StripDefaultPort, CanonicalStripDefaultPort},
+		{r.DecodeUnreserved, CanonicalDecodeUnreserved},
+		{r.SortQuery, CanonicalSortQuery},
+		{r.MergeTrailingSlash, CanonicalMergeTrailingSlash},
+	} {
+		if option.enabled {
+			names = append(names, option.name)
+		}
+	}
+
+	return names
+}
+
+// Canonicalize normalizes the url in place, it does nothing if the rules are nil
+func (r *CanonicalRules) Canonicalize(url *neturl.URL) {
+	if r == nil || url == nil {
+		return
+	}
+
+	if r.LowercaseHost {
+		url.Host = strings.ToLower(url.Host)
+	}
+
+	if r.StripDefaultPort {
+		if port := url.Port(); len(port) > 0 && canonicalDefaultPorts[strings.ToLower(url.Scheme)] == port {
+			hostname := url.Hostname()
+			if strings.Contains(hostname, ":") {
+				hostname = "[" + hostname + "]"
+			}
+			url.Host = hostname
+		}
+	}
+
+	if r.MergeTrailingSlash && len(url.Path) > 1 && strings.HasSuffix(url.Path, "/") {
+		url.Path = strings.TrimRight(url.Path, "/")
+		if len(url.Path) == 0 {
+			url.Path = "/"
+		}
+		if len(url.RawPath) > 0 {
+			url.RawPath = strings.TrimRight(url.RawPath, "/")
+		}
+	}
+
+	if r.DecodeUnreserved && len(url.RawPath) > 0 {
+		rawPath := normalizeEscapes(url.RawPath)
+		if rawPath == (&neturl.URL{Path: url.Path}).EscapedPath() {
+			rawPath = ""
+		}
+		url.RawPath = rawPath
+	}
+
+	if len(url.RawQuery) > 0 {
+		url.RawQuery = r.canonicalizeQuery(url.RawQuery)
+	}
+}
+
+func (r *CanonicalRules) canonicalizeQuery(rawQuery string) string {
+	if !r.SortQuery && !r.DecodeUnreserved && len(r.DropQueryKeys) == 0 {
+		return rawQuery
+	}
+
+	type queryPair struct {
+		key string
+		raw string
+	}
+
+	pairs := make([]queryPair, 0)
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if len(raw) == 0 {
+			continue
+		}
+
+		rawKey := strings.SplitN(raw, "=", 2)[0]
+		key, err := neturl.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		if r.shouldDropQueryKey(key) {
+			continue
+		}
+
+		if r.DecodeUnreserved {
+			raw = normalizeEscapes(raw)
+		}
+		pairs = append(pairs, queryPair{key, raw})
+	}
+
+	if r.SortQuery {
+		sort.SliceStable(pairs, func(i, j int) bool {
+			return pairs[i].key < pairs[j].key
+		})
+	}
+
+	raws := make([]string, len(pairs))
+	for i, pair := range pairs {
+		raws[i] = pair.raw
+	}
+
+	return strings.Join(raws, "&")
+}
+
+func (r *CanonicalRules) shouldDropQueryKey(key string) bool {
+	for _, dropKey := range r.DropQueryKeys {
+		if strings.HasSuffix(dropKey, "*") {
+			if strings.HasPrefix(key, dropKey[:len(dropKey)-1]) {
+				return true
+			}
+		} else if key == dropKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeEscapes decodes percent-encoded unreserved characters (RFC 3986)
+// and uppercases the hex digits of the other escapes
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			c := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(c) {
+				b.WriteByte(c)
+			} else {
+				b.WriteString(strings.ToUpper(s[i : i+3]))
+			}
+			i += 2
+			continue
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' ||
+		'a' <= c && c <= 'f' ||
+		'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10
+	}
+
+	return 0
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' ||
+		'A' <= c && c <= 'Z' ||
+		'0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,92 @@
+package cacher_test
+
+import (
+	"net/url"
+
+	. "github.com/alphagov/spotlight-gel/cacher"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CanonicalRules", func() {
+	canonicalize := func(rules *CanonicalRules, u string) string {
+		parsedURL, err := url.Parse(u)
+		Expect(err).ToNot(HaveOccurred())
+
+		rules.Canonicalize(parsedURL)
+		return parsedURL.String()
+	}
+
+	It("should do nothing with nil rules", func() {
+		var rules *CanonicalRules
+		u := "http://DOMAIN.com:80/a/?b=2&a=1"
+
+		Expect(canonicalize(rules, u)).To(Equal(u))
+	})
+
+	It("should lowercase host", func() {
+		rules := &CanonicalRules{LowercaseHost: true}
+
+		Expect(canonicalize(rules, "http://DOMAIN.Com/Path")).To(Equal("http://domain.com/Path"))
+	})
+
+	It("should strip default port", func() {
+		rules := &CanonicalRules{StripDefaultPort: true}
+
+		Expect(canonicalize(rules, "http://domain.com:80/")).To(Equal("http://domain.com/"))
+		Expect(canonicalize(rules, "https://domain.com:443/")).To(Equal("https://domain.com/"))
+		Expect(canonicalize(rules, "https://domain.com:80/")).To(Equal("https://domain.com:80/"))
+		Expect(canonicalize(rules, "http://[::1]:80/")).To(Equal("http://[::1]/"))
+	})
+
+	It("should decode unreserved escapes", func() {
+		rules := &CanonicalRules{DecodeUnreserved: true}
+
+		Expect(canonicalize(rules, "http://domain.com/%7Efoo/%41%2fb")).To(Equal("http://domain.com/~foo/A%2Fb"))
+		Expect(canonicalize(rules, "http://domain.com/?q=%61%2f")).To(Equal("http://domain.com/?q=a%2F"))
+	})
+
+	It("should sort query", func() {
+		rules := &CanonicalRules{SortQuery: true}
+
+		Expect(canonicalize(rules, "http://domain.com/?b=2&a=1&b=1")).To(Equal("http://domain.com/?a=1&b=2&b=1"))
+	})
+
+	It("should drop query keys", func() {
+		rules := &CanonicalRules{DropQueryKeys: []string{"utm_*", "fbclid"}}
+
+		Expect(canonicalize(rules, "http://domain.com/?utm_source=x&b=2&fbclid=y&utm_medium=z&a=1")).
+			To(Equal("http://domain.com/?b=2&a=1"))
+		Expect(canonicalize(rules, "http://domain.com/?utm_source=x")).To(Equal("http://domain.com/"))
+	})
+
+	It("should merge trailing slash", func() {
+		rules := &CanonicalRules{MergeTrailingSlash: true}
+
+		Expect(canonicalize(rules, "http://domain.com/a/")).To(Equal("http://domain.com/a"))
+		Expect(canonicalize(rules, "http://domain.com/")).To(Equal("http://domain.com/"))
+	})
+
+	Describe("SetOptions", func() {
+		It("should set options", func() {
+			rules := &CanonicalRules{DropQueryKeys: []string{"utm_*"}, LowercaseHost: true}
+			err := rules.SetOptions([]string{CanonicalSortQuery, CanonicalMergeTrailingSlash})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rules).To(Equal(&CanonicalRules{
+				DropQueryKeys:      []string{"utm_*"},
+				SortQuery:          true,
+				MergeTrailingSlash: true,
+			}))
+			Expect(rules.GetOptions()).To(Equal([]string{CanonicalSortQuery, CanonicalMergeTrailingSlash}))
+		})
+
+		It("should handle unknown option", func() {
+			rules := &CanonicalRules{}
+			err := rules.SetOptions([]string{"foo"})
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -19,12 +19,16 @@ type Cacher interface {
 	GetPath() string
 	SetDefaultTTL(time.Duration)
 	GetDefaultTTL() time.Duration
+	SetCanonicalRules(*CanonicalRules)
+	GetCanonicalRules() *CanonicalRules
 
 	CheckCacheExists(*url.URL) bool
 	Write(*Input) error
 	Bump(*url.URL, time.Duration) error
 	WritePlaceholder(*url.URL, time.Duration) error
 	Open(*url.URL) (io.ReadCloser, error)
+	Remove(*url.URL) error
+	Walk(func(*Entry) error) error
 }
 
 // Input struct to be used with cacher func
@@ -37,11 +41,21 @@ type Input struct {
 	Header http.Header
 }
 
+// Entry represents a cached url found while walking the cache
+type Entry struct {
+	Path       string
+	URL        *url.URL
+	StatusCode int
+	Expires    *time.Time
+	Size       int64
+}
+
 // Fs represents file system with funcs to manipulate directories and files
 type Fs interface {
 	Getwd() (string, error)
 	MkdirAll(string, os.FileMode) error
 	OpenFile(string, int, os.FileMode) (File, error)
+	ReadDir(string) ([]os.FileInfo, error)
 	RemoveAll(string) error
 }
 
@@ -74,8 +88,12 @@ const (
 )
 
 const (
+	// HeaderAcceptEncoding http accept encoding header key
+	HeaderAcceptEncoding = "Accept-Encoding"
 	// HeaderCacheControl http cache control header key
 	HeaderCacheControl = "Cache-Control"
+	// HeaderContentEncoding http content encoding header key
+	HeaderContentEncoding = "Content-Encoding"
 	// HeaderContentLength http content length header key
 	HeaderContentLength = "Content-Length"
 	// HeaderContentType http content type header key
@@ -86,6 +104,8 @@ const (
 	HeaderLastModified = "Last-Modified"
 	// HeaderLocation http location header key
 	HeaderLocation = "Location"
+	// HeaderVary http vary header key
+	HeaderVary = "Vary"
 )
 
 const (
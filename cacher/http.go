@@ -6,8 +6,10 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -118,3 +120,61 @@ func writeHTTPPlaceholder(w io.Writer, url *url.URL, expires time.Time) error {
 
 	return writeError
 }
+
+func readHTTPEntry(fs Fs, cachePath string) *Entry {
+	f, err := fs.OpenFile(cachePath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	firstLine, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(firstLine, "HTTP ") {
+		return nil
+	}
+
+	statusCode, err := strconv.Atoi(strings.TrimSpace(firstLine[len("HTTP "):]))
+	if err != nil {
+		return nil
+	}
+
+	entry := &Entry{
+		Path:       cachePath,
+		StatusCode: statusCode,
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil || line == "\n" {
+			break
+		}
+
+		parts := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case CustomHeaderURL:
+			entry.URL, _ = url.Parse(parts[1])
+		case CustomHeaderExpires:
+			if expires, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+				t := time.Unix(0, expires)
+				entry.Expires = &t
+			}
+		}
+	}
+
+	return entry
+}
+
+// IsPlaceholder returns true if the entry has been written as a placeholder
+func (e *Entry) IsPlaceholder() bool {
+	return e.StatusCode == http.StatusNoContent
+}
+
+// IsExpired returns true if the entry has expired
+func (e *Entry) IsExpired() bool {
+	return e.Expires != nil && e.Expires.Before(time.Now())
+}
@@ -1,6 +1,9 @@
 package cacher
 
-import "os"
+import (
+	"io/ioutil"
+	"os"
+)
 
 type realFs struct{}
 
@@ -21,6 +24,10 @@ func (fs *realFs) OpenFile(name string, flag int, perm os.FileMode) (File, error
 	return os.OpenFile(name, flag, perm)
 }
 
+func (fs *realFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dirname)
+}
+
 func (fs *realFs) RemoveAll(path string) error {
 	return os.RemoveAll(path)
 }
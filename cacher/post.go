@@ -0,0 +1,67 @@
+package cacher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"mime"
+	neturl "net/url"
+)
+
+const (
+	// QueryKeyPost query key of the urls used to cache POST responses, its value is the hash of the request body
+	QueryKeyPost = "x-mirror-post"
+	// PostHashLength length of the request body hash
+	PostHashLength = 16
+)
+
+// BuildPostURL returns the url used to cache the response of a POST request,
+// requests with equivalent bodies share the same url
+func BuildPostURL(url *neturl.URL, contentType string, body []byte) *neturl.URL {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	sum := sha256.Sum256(append([]byte(mediaType+"\n"), NormalizePostBody(mediaType, body)...))
+
+	postURL := *url
+	query := postURL.Query()
+	query.Set(QueryKeyPost, fmt.Sprintf("%x", sum[:PostHashLength/2]))
+	postURL.RawQuery = query.Encode()
+	postURL.Fragment = ""
+
+	return &postURL
+}
+
+// IsPostURL returns true if the url has been built by BuildPostURL
+func IsPostURL(url *neturl.URL) bool {
+	_, ok := url.Query()[QueryKeyPost]
+	return ok
+}
+
+// RemovePostQuery removes the query key of BuildPostURL, so other requests cannot reach the cached POST responses
+func RemovePostQuery(url *neturl.URL) {
+	query := url.Query()
+	if _, ok := query[QueryKeyPost]; !ok {
+		return
+	}
+
+	query.Del(QueryKeyPost)
+	url.RawQuery = query.Encode()
+}
+
+// NormalizePostBody returns the body with url-encoded keys sorted and JSON compacted,
+// other bodies are returned as is
+func NormalizePostBody(mediaType string, body []byte) []byte {
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if values, err := neturl.ParseQuery(string(body)); err == nil {
+			return []byte(values.Encode())
+		}
+	case "application/json":
+		var buffer bytes.Buffer
+		if err := json.Compact(&buffer, body); err == nil {
+			return buffer.Bytes()
+		}
+	}
+
+	return body
+}
@@ -0,0 +1,61 @@
+package cacher_test
+
+import (
+	"net/url"
+
+	. "github.com/alphagov/spotlight-gel/cacher"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BuildPostURL", func() {
+	const formType = "application/x-www-form-urlencoded"
+
+	build := func(contentType string, body string) *url.URL {
+		parsedURL, _ := url.Parse("http://domain.com/search?page=2#top")
+
+		return BuildPostURL(parsedURL, contentType, []byte(body))
+	}
+
+	It("should add body hash to query", func() {
+		postURL := build(formType, "q=a")
+
+		Expect(postURL.Path).To(Equal("/search"))
+		Expect(postURL.Query().Get("page")).To(Equal("2"))
+		Expect(len(postURL.Query().Get(QueryKeyPost))).To(Equal(PostHashLength))
+		Expect(postURL.Fragment).To(BeEmpty())
+		Expect(IsPostURL(postURL)).To(BeTrue())
+	})
+
+	It("should normalize form body", func() {
+		Expect(build(formType+"; charset=utf-8", "b=2&a=1")).To(Equal(build(formType, "a=1&b=2")))
+		Expect(build(formType, "q=a")).ToNot(Equal(build(formType, "q=b")))
+	})
+
+	It("should normalize json body", func() {
+		Expect(build("application/json", `{"q": "a"}`)).To(Equal(build("application/json", `{"q":"a"}`)))
+	})
+
+	It("should hash content type", func() {
+		Expect(build("text/plain", "q=a")).ToNot(Equal(build(formType, "q=a")))
+	})
+
+	It("should keep other body", func() {
+		Expect(string(NormalizePostBody("text/plain", []byte("b=2&a=1")))).To(Equal("b=2&a=1"))
+	})
+
+	It("should remove body hash from query", func() {
+		postURL := build(formType, "q=a")
+		RemovePostQuery(postURL)
+
+		Expect(postURL.String()).To(Equal("http://domain.com/search?page=2"))
+		Expect(IsPostURL(postURL)).To(BeFalse())
+	})
+
+	It("should not be post url", func() {
+		parsedURL, _ := url.Parse("http://domain.com/search?q=a")
+
+		Expect(IsPostURL(parsedURL)).To(BeFalse())
+	})
+})
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/alphagov/spotlight-gel/cacher"
+	"github.com/alphagov/spotlight-gel/engine"
+)
+
+type reloadable struct {
+	engine engine.Engine
+	parse  func() (*engine.Config, error)
+}
+
+type cacheStats struct {
+	entries      int
+	placeholders int
+	expired      int
+	bytes        int64
+}
+
+func port() int64 {
+	p := os.Getenv("PORT")
+	if p == "0" {
+		p = "8080"
+	}
+	n, _ := strconv.Atoi(p)
+	return int64(n)
+}
+
+func parseMirrorConfig(arg0 string, args []string) (*engine.Config, error) {
+	config, err := engine.ParseConfig(arg0, args, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := os.LookupEnv("PORT"); ok {
+		config.Port = port()
+	}
+
+	return config, nil
+}
+
+func parseServeConfig(arg0 string, args []string) (*engine.Config, error) {
+	config, err := parseMirrorConfig(arg0, args)
+	if err != nil {
+		return nil, err
+	}
+	config.Role = engine.RoleServeOnly
+
+	return config, nil
+}
+
+func runMirror(arg0 string, args []string) int {
+	parse := func() (*engine.Config, error) { return parseMirrorConfig(arg0, args) }
+	config, err := parse()
+	if err != nil {
+		return 1
+	}
+	e := engine.FromConfig(cacher.NewFs(), config)
+
+	waitForSignals(reloadable{engine: e, parse: parse})
+
+	return 0
+}
+
+func runServe(arg0 string, args []string) int {
+	parse := func() (*engine.Config, error) { return parseServeConfig(arg0, args) }
+	config, err := parse()
+	if err != nil {
+		return 1
+	}
+	server := engine.FromConfig(cacher.NewFs(), config)
+
+	waitForSignals(reloadable{engine: server, parse: parse})
+
+	return 0
+}
+
+func runCrawl(arg0 string, args []string) int {
+	fs, config := engine.NewConfigFlagSet(arg0, os.Stderr)
+	maxTime := fs.Duration("max-time", 0, "Maximum crawl duration, default=no limit")
+	if err := engine.ParseConfigFlagSet(fs, config, args, os.Stderr); err != nil {
+		return 1
+	}
+
+	// one-shot crawl: never refresh
+	config.Role = engine.RoleCrawlOnly
+	config.AutoEnqueueInterval = time.Duration(0)
+
+	start := time.Now()
+	downloader := engine.FromConfig(cacher.NewFs(), config)
+
+	done := make(chan interface{})
+	go func() {
+		downloader.Stop()
+		close(done)
+	}()
+
+	var timeout <-chan time.Time
+	if *maxTime > 0 {
+		timeout = time.After(*maxTime)
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+
+	exitCode := 0
+	select {
+	case <-done:
+	case <-timeout:
+		fmt.Fprintf(os.Stderr, "Crawl did not finish within %s\n", *maxTime)
+		exitCode = 1
+	case <-c:
+		exitCode = 1
+	}
+
+	crawler := downloader.GetCrawler()
+	fmt.Printf("Crawled %d urls (%d links found) in %s\n",
+		crawler.GetDownloadedCount(), crawler.GetLinkFoundCount(), time.Since(start))
+	printCrawlBudgets(downloader, config)
+
+	return exitCode
+}
+
+// printCrawlBudgets prints the crawl budget counters of each mirror
+func printCrawlBudgets(downloader engine.Engine, config *engine.Config) {
+	mirrorURLs := append([]*url.URL(nil), config.MirrorURLs...)
+	for _, mirror := range config.Mirrors {
+		mirrorURLs = append(mirrorURLs, mirror.URL)
+	}
+
+	for _, mirrorURL := range mirrorURLs {
+		status, err := downloader.GetMirrorBudget(mirrorURL)
+		if err != nil {
+			continue
+		}
+
+		line := fmt.Sprintf("  %s: %d urls, %d bytes", mirrorURL, status.URLs, status.Bytes)
+		if status.Skipped > 0 {
+			line += fmt.Sprintf(", %d skipped over max-object-bytes", status.Skipped)
+		}
+		if len(status.Exceeded) > 0 {
+			line += fmt.Sprintf(", stopped by %s", status.Exceeded)
+		}
+		fmt.Println(line)
+	}
+}
+
+func runStats(arg0 string, args []string) int {
+	fs, config := engine.NewConfigFlagSet(arg0, os.Stderr)
+	host := fs.String("host", "", "Only include urls of this host")
+	if err := engine.ParseConfigFlagSet(fs, config, args, os.Stderr); err != nil {
+		return 1
+	}
+
+	stats := make(map[string]*cacheStats)
+	total := &cacheStats{}
+	err := newCacher(config).Walk(func(entry *cacher.Entry) error {
+		entryHost := ""
+		if entry.URL != nil {
+			entryHost = entry.URL.Host
+		}
+		if len(*host) > 0 && entryHost != *host {
+			return nil
+		}
+
+		hostStats, ok := stats[entryHost]
+		if !ok {
+			hostStats = &cacheStats{}
+			stats[entryHost] = hostStats
+		}
+
+		for _, s := range []*cacheStats{hostStats, total} {
+			s.entries++
+			s.bytes += entry.Size
+			if entry.IsPlaceholder() {
+				s.placeholders++
+			} else if entry.IsExpired() {
+				s.expired++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read cache: %v\n", err)
+		return 1
+	}
+
+	hosts := make([]string, 0, len(stats))
+	for h := range stats {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "HOST\tENTRIES\tPLACEHOLDERS\tEXPIRED\tBYTES\t")
+	for _, h := range hosts {
+		s := stats[h]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t\n", h, s.entries, s.placeholders, s.expired, s.bytes)
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t\n", "TOTAL", total.entries, total.placeholders, total.expired, total.bytes)
+	w.Flush()
+
+	return 0
+}
+
+func runPurge(arg0 string, args []string) int {
+	fs, config := engine.NewConfigFlagSet(arg0, os.Stderr)
+	prefix := fs.Bool("prefix", false, "Remove all urls starting with the specified url")
+	dryRun := fs.Bool("dry-run", false, "Print matching urls without removing them")
+	if err := engine.ParseConfigFlagSet(fs, config, args, os.Stderr); err != nil {
+		return 1
+	}
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Exactly one url or prefix must be specified")
+		return 2
+	}
+	target := fs.Arg(0)
+
+	c := newCacher(config)
+	urls := make([]*url.URL, 0)
+	if *prefix {
+		canonicalRules := c.GetCanonicalRules()
+		if parsedURL, err := url.Parse(target); err == nil && parsedURL.IsAbs() {
+			canonicalRules.Canonicalize(parsedURL)
+			target = parsedURL.String()
+		}
+
+		err := c.Walk(func(entry *cacher.Entry) error {
+			if entry.URL == nil {
+				return nil
+			}
+
+			// entries keep the requested url, their path is built from the canonical one
+			canonicalURL := *entry.URL
+			canonicalRules.Canonicalize(&canonicalURL)
+			if strings.HasPrefix(canonicalURL.String(), target) {
+				urls = append(urls, entry.URL)
+			}
+
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read cache: %v\n", err)
+			return 1
+		}
+	} else {
+		parsedURL, err := url.Parse(target)
+		if err != nil || !parsedURL.IsAbs() {
+			fmt.Fprintf(os.Stderr, "Invalid url %q\n", target)
+			return 2
+		}
+		urls = append(urls, parsedURL)
+	}
+
+	removed := 0
+	for _, u := range urls {
+		if *dryRun {
+			fmt.Println(u)
+			continue
+		}
+
+		if err := c.Remove(u); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot remove %s: %v\n", u, err)
+			continue
+		}
+		removed++
+	}
+
+	if !*dryRun {
+		fmt.Printf("Removed %d urls\n", removed)
+	}
+
+	return 0
+}
+
+func runTestRules(arg0 string, args []string) int {
+	fs, config := engine.NewConfigFlagSet(arg0, os.Stderr)
+	contentType := fs.String("content-type", "", "Content type of the sample body (default guessed from the url and body)")
+	if err := engine.ParseConfigFlagSet(fs, config, args, os.Stderr); err != nil {
+		return 1
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintln(os.Stderr, "An url and optionally a file must be specified, the body is read from stdin without file")
+		return 2
+	}
+
+	parsedURL, err := url.Parse(fs.Arg(0))
+	if err != nil || !parsedURL.IsAbs() {
+		fmt.Fprintf(os.Stderr, "Invalid url %q\n", fs.Arg(0))
+		return 2
+	}
+
+	var body []byte
+	if fs.NArg() > 1 {
+		body, err = ioutil.ReadFile(fs.Arg(1))
+	} else {
+		body, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read body: %v\n", err)
+		return 1
+	}
+
+	if len(*contentType) == 0 {
+		*contentType = mime.TypeByExtension(path.Ext(parsedURL.Path))
+	}
+	if len(*contentType) == 0 {
+		*contentType = http.DetectContentType(body)
+	}
+
+	bodyRules := []engine.RewriteRule(config.RewriteRules)
+	headerRules := []engine.HeaderRule(config.HeaderRules)
+	for _, mirror := range config.Mirrors {
+		if mirror.URL.Scheme == parsedURL.Scheme && mirror.URL.Host == parsedURL.Host {
+			// mirror rules are applied first, like the engine does
+			bodyRules = append(append([]engine.RewriteRule(nil), mirror.RewriteRules...), bodyRules...)
+			headerRules = append(append([]engine.HeaderRule(nil), mirror.HeaderRules...), headerRules...)
+			break
+		}
+	}
+
+	for _, rule := range bodyRules {
+		if rule.Match(parsedURL, *contentType) {
+			fmt.Fprintf(os.Stderr, "Matched body rule: %s\n", rule)
+		}
+	}
+	for _, rule := range headerRules {
+		if rule.Match(parsedURL, *contentType) {
+			fmt.Fprintf(os.Stderr, "Matched header rule: %s\n", rule)
+		}
+	}
+
+	header := http.Header{"Content-Type": []string{*contentType}}
+	rewritten := engine.ApplyRewriteRules(parsedURL, header, string(body), bodyRules, headerRules)
+
+	headerKeys := make([]string, 0, len(header))
+	for headerKey := range header {
+		headerKeys = append(headerKeys, headerKey)
+	}
+	sort.Strings(headerKeys)
+	for _, headerKey := range headerKeys {
+		for _, headerValue := range header[headerKey] {
+			fmt.Printf("%s: %s\n", headerKey, headerValue)
+		}
+	}
+	fmt.Printf("\n%s", rewritten)
+
+	return 0
+}
+
+func newCacher(config *engine.Config) cacher.Cacher {
+	logger := logrus.New()
+	logger.Level = logrus.Level(config.LoggerLevel)
+
+	c := cacher.NewHTTPCacher(cacher.NewFs(), logger)
+	if len(config.Cacher.Path) > 0 {
+		c.SetPath(config.Cacher.Path)
+	}
+	// entries are stored under the canonical urls
+	c.SetCanonicalRules(&config.Canonical)
+
+	return c
+}
+
+func waitForSignals(reloadables ...reloadable) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGHUP)
+	for sig := range c {
+		switch sig {
+		case syscall.SIGHUP:
+			for _, r := range reloadables {
+				// keep running with the current config if the new one cannot be parsed
+				if config, err := r.parse(); err == nil {
+					r.engine.Reload(config)
+				}
+			}
+		case os.Interrupt:
+			for _, r := range reloadables {
+				go r.engine.Stop() // FIXME: this effectively skips drain, done because it waits for enqueue to finish
+			}
+			return
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/alphagov/spotlight-gel/cacher"
 	nbc "github.com/hectane/go-nonblockingchan"
 	"github.com/tevino/abool"
 )
@@ -21,17 +22,30 @@ type crawler struct {
 	logger *logrus.Logger
 	mutex  sync.Mutex
 
-	autoDownloadDepth uint64
-	noCrossHost       *abool.AtomicBool
-	noProxy           *abool.AtomicBool
-	requestHeader     http.Header
-	workerCount       uint64
-
-	urlRewriter         *func(*neturl.URL)
-	onURLShouldQueue    *func(*neturl.URL) bool
-	onURLShouldDownload *func(*neturl.URL) bool
-	onDownload          *func(*neturl.URL)
-	onDownloaded        *func(*Downloaded)
+	autoDownloadDepth     uint64
+	rootAutoDownloadDepth map[string]uint64
+	noCrossHost           *abool.AtomicBool
+	keepCharset           *abool.AtomicBool
+	keepEncoding          *abool.AtomicBool
+	formLimit             uint64
+	requestHeader         http.Header
+	workerCount           uint64
+
+	canonicalRules       *cacher.CanonicalRules
+	sanitizeRules        *SanitizeRules
+	injectRules          *InjectRules
+	domRules             []DOMRule
+	jsonPaths            []JSONPath
+	upstreams            *Upstreams
+	parsers              Parsers
+	urlRewriter          *func(*neturl.URL)
+	inputRewriter        *func(*Input)
+	onURLShouldQueue     *func(*neturl.URL) bool
+	onItemShouldQueue    *func(QueueItem) bool
+	onURLShouldDownload  *func(*neturl.URL) bool
+	onItemShouldDownload *func(QueueItem) bool
+	onDownload           *func(*neturl.URL)
+	onDownloaded         *func(*Downloaded)
 
 	output           chan *Downloaded
 	queue            *nbc.NonBlockingChan
@@ -65,10 +79,13 @@ func (c *crawler) init(client *http.Client, logger *logrus.Logger) {
 	c.logger = logger
 
 	c.autoDownloadDepth = 1
+	c.rootAutoDownloadDepth = make(map[string]uint64)
 	c.noCrossHost = abool.New()
-	c.noProxy = abool.New()
+	c.keepCharset = abool.New()
+	c.keepEncoding = abool.New()
 	c.requestHeader = make(http.Header)
 	c.workerCount = 4
+	c.parsers = DefaultParsers()
 
 	userAgent := fmt.Sprintf("spotlight-gel/%s (Googlebot wannabe)", version)
 	c.requestHeader.Add("User-Agent", userAgent)
@@ -99,6 +116,37 @@ func (c *crawler) GetAutoDownloadDepth() uint64 {
 	return atomic.LoadUint64(&c.autoDownloadDepth)
 }
 
+func (c *crawler) SetRootAutoDownloadDepth(root *neturl.URL, depth uint64) {
+	c.mutex.Lock()
+	c.rootAutoDownloadDepth[root.String()] = depth
+	c.mutex.Unlock()
+
+	c.logger.WithFields(logrus.Fields{
+		"root":  root,
+		"depth": depth,
+	}).Info("Updated crawler root auto download depth")
+}
+
+func (c *crawler) GetRootAutoDownloadDepth(root *neturl.URL) uint64 {
+	if root != nil {
+		c.mutex.Lock()
+		depth, ok := c.rootAutoDownloadDepth[root.String()]
+		c.mutex.Unlock()
+
+		if ok {
+			return depth
+		}
+	}
+
+	return c.GetAutoDownloadDepth()
+}
+
+func (c *crawler) RemoveRootAutoDownloadDepth(root *neturl.URL) {
+	c.mutex.Lock()
+	delete(c.rootAutoDownloadDepth, root.String())
+	c.mutex.Unlock()
+}
+
 func (c *crawler) SetNoCrossHost(value bool) {
 	old := c.noCrossHost.IsSet()
 	c.noCrossHost.SetTo(value)
@@ -113,18 +161,46 @@ func (c *crawler) GetNoCrossHost() bool {
 	return c.noCrossHost.IsSet()
 }
 
-func (c *crawler) SetNoProxy(value bool) {
-	old := c.noProxy.IsSet()
-	c.noProxy.SetTo(value)
+func (c *crawler) SetKeepCharset(value bool) {
+	old := c.keepCharset.IsSet()
+	c.keepCharset.SetTo(value)
 
 	c.logger.WithFields(logrus.Fields{
 		"old": old,
 		"new": value,
-	}).Info("Updated crawler no only serve from cache")
+	}).Info("Updated crawler keep charset")
+}
+
+func (c *crawler) GetKeepCharset() bool {
+	return c.keepCharset.IsSet()
+}
+
+func (c *crawler) SetKeepEncoding(value bool) {
+	old := c.keepEncoding.IsSet()
+	c.keepEncoding.SetTo(value)
+
+	c.logger.WithFields(logrus.Fields{
+		"old": old,
+		"new": value,
+	}).Info("Updated crawler keep encoding")
+}
+
+func (c *crawler) GetKeepEncoding() bool {
+	return c.keepEncoding.IsSet()
+}
+
+func (c *crawler) SetFormLimit(limit uint64) {
+	old := atomic.LoadUint64(&c.formLimit)
+	atomic.StoreUint64(&c.formLimit, limit)
+
+	c.logger.WithFields(logrus.Fields{
+		"old": old,
+		"new": limit,
+	}).Info("Updated crawler form limit")
 }
 
-func (c *crawler) GetNoProxy() bool {
-	return c.noProxy.IsSet()
+func (c *crawler) GetFormLimit() uint64 {
+	return atomic.LoadUint64(&c.formLimit)
 }
 
 func (c *crawler) AddRequestHeader(key string, value string) {
@@ -186,24 +262,170 @@ func (c *crawler) GetWorkerCount() uint64 {
 	return atomic.LoadUint64(&c.workerCount)
 }
 
+func (c *crawler) SetCanonicalRules(rules *cacher.CanonicalRules) {
+	c.mutex.Lock()
+	c.canonicalRules = rules
+	c.mutex.Unlock()
+
+	c.logger.WithField("rules", rules).Info("Updated crawler canonical rules")
+}
+
+func (c *crawler) GetCanonicalRules() *cacher.CanonicalRules {
+	c.mutex.Lock()
+	rules := c.canonicalRules
+	c.mutex.Unlock()
+
+	return rules
+}
+
+func (c *crawler) SetSanitizeRules(rules *SanitizeRules) {
+	c.mutex.Lock()
+	c.sanitizeRules = rules
+	c.mutex.Unlock()
+
+	c.logger.WithField("rules", rules).Info("Updated crawler sanitize rules")
+}
+
+func (c *crawler) GetSanitizeRules() *SanitizeRules {
+	c.mutex.Lock()
+	rules := c.sanitizeRules
+	c.mutex.Unlock()
+
+	return rules
+}
+
+func (c *crawler) SetInjectRules(rules *InjectRules) {
+	c.mutex.Lock()
+	c.injectRules = rules
+	c.mutex.Unlock()
+
+	c.logger.WithField("rules", rules).Info("Updated crawler inject rules")
+}
+
+func (c *crawler) GetInjectRules() *InjectRules {
+	c.mutex.Lock()
+	rules := c.injectRules
+	c.mutex.Unlock()
+
+	return rules
+}
+
+func (c *crawler) SetDOMRules(rules []DOMRule) {
+	c.mutex.Lock()
+	c.domRules = rules
+	c.mutex.Unlock()
+
+	c.logger.WithField("rules", rules).Info("Updated crawler DOM rules")
+}
+
+func (c *crawler) GetDOMRules() []DOMRule {
+	c.mutex.Lock()
+	rules := c.domRules
+	c.mutex.Unlock()
+
+	return rules
+}
+
+func (c *crawler) SetJSONPaths(paths []JSONPath) {
+	c.mutex.Lock()
+	c.jsonPaths = paths
+	c.mutex.Unlock()
+
+	c.logger.WithField("paths", paths).Info("Updated crawler JSON paths")
+}
+
+func (c *crawler) GetJSONPaths() []JSONPath {
+	c.mutex.Lock()
+	paths := c.jsonPaths
+	c.mutex.Unlock()
+
+	return paths
+}
+
+func (c *crawler) SetUpstreams(upstreams *Upstreams) {
+	c.mutex.Lock()
+	c.upstreams = upstreams
+	c.mutex.Unlock()
+
+	c.logger.WithFields(logrus.Fields{
+		"hosts": upstreams.Hosts(),
+		"proxy": upstreams.ProxyHost(),
+	}).Info("Updated crawler upstreams")
+}
+
+func (c *crawler) GetUpstreams() *Upstreams {
+	c.mutex.Lock()
+	upstreams := c.upstreams
+	c.mutex.Unlock()
+
+	return upstreams
+}
+
+func (c *crawler) SetParser(mediaType string, parser Parser) {
+	c.mutex.Lock()
+	// copy on write as the current parsers may be in use by downloads
+	parsers := c.parsers.copy()
+	parsers[mediaType] = parser
+	c.parsers = parsers
+	c.mutex.Unlock()
+
+	c.logger.WithField("mediaType", mediaType).Info("Updated crawler parser")
+}
+
+func (c *crawler) RemoveParser(mediaType string) {
+	c.mutex.Lock()
+	parsers := c.parsers.copy()
+	delete(parsers, mediaType)
+	c.parsers = parsers
+	c.mutex.Unlock()
+
+	c.logger.WithField("mediaType", mediaType).Info("Removed crawler parser")
+}
+
+func (c *crawler) GetParser(mediaType string) Parser {
+	c.mutex.Lock()
+	parsers := c.parsers
+	c.mutex.Unlock()
+
+	return parsers.Find(mediaType)
+}
+
 func (c *crawler) SetURLRewriter(f func(*neturl.URL)) {
 	c.mutex.Lock()
 	c.urlRewriter = &f
 	c.mutex.Unlock()
 }
 
+func (c *crawler) SetInputRewriter(f func(*Input)) {
+	c.mutex.Lock()
+	c.inputRewriter = &f
+	c.mutex.Unlock()
+}
+
 func (c *crawler) SetOnURLShouldQueue(f func(*neturl.URL) bool) {
 	c.mutex.Lock()
 	c.onURLShouldQueue = &f
 	c.mutex.Unlock()
 }
 
+func (c *crawler) SetOnItemShouldQueue(f func(QueueItem) bool) {
+	c.mutex.Lock()
+	c.onItemShouldQueue = &f
+	c.mutex.Unlock()
+}
+
 func (c *crawler) SetOnURLShouldDownload(f func(*neturl.URL) bool) {
 	c.mutex.Lock()
 	c.onURLShouldDownload = &f
 	c.mutex.Unlock()
 }
 
+func (c *crawler) SetOnItemShouldDownload(f func(QueueItem) bool) {
+	c.mutex.Lock()
+	c.onItemShouldDownload = &f
+	c.mutex.Unlock()
+}
+
 func (c *crawler) SetOnDownload(f func(*neturl.URL)) {
 	c.mutex.Lock()
 	c.onDownload = &f
@@ -386,9 +608,18 @@ func (c *crawler) doDownload(workerID uint64, item QueueItem) *Downloaded {
 	c.mutex.Lock()
 	client := c.client
 	requestHeader := c.requestHeader
+	canonicalRules := c.canonicalRules
+	sanitizeRules := c.sanitizeRules
+	injectRules := c.injectRules
+	domRules := c.domRules
+	jsonPaths := c.jsonPaths
+	upstreams := c.upstreams
+	parsers := c.parsers
 	urlRewriter := c.urlRewriter
+	inputRewriter := c.inputRewriter
 	onDownload := c.onDownload
 	onURLShouldDownload := c.onURLShouldDownload
+	onItemShouldDownload := c.onItemShouldDownload
 	onDownloaded := c.onDownloaded
 	c.mutex.Unlock()
 
@@ -401,22 +632,48 @@ func (c *crawler) doDownload(workerID uint64, item QueueItem) *Downloaded {
 
 	if item.ForceDownload {
 		// do not trigger onURLShouldDownload
-	} else if onURLShouldDownload != nil {
-		shouldDownload = (*onURLShouldDownload)(item.URL)
-		if !shouldDownload {
-			loggerContext.Debug("Skipped as instructed by onURLShouldDownload")
+	} else {
+		if onURLShouldDownload != nil && !item.Refresh {
+			shouldDownload = (*onURLShouldDownload)(item.URL)
+			if !shouldDownload {
+				loggerContext.Debug("Skipped as instructed by onURLShouldDownload")
+			}
+		}
+		if shouldDownload && onItemShouldDownload != nil {
+			shouldDownload = (*onItemShouldDownload)(item)
+			if !shouldDownload {
+				loggerContext.Debug("Skipped as instructed by onItemShouldDownload")
+			}
 		}
 	}
 
 	if shouldDownload {
 		loggerContext.Debug("Downloading")
-		downloaded = Download(&Input{
-			Client:      client,
-			Header:      requestHeader,
-			NoCrossHost: c.noCrossHost.IsSet(),
-			Rewriter:    urlRewriter,
-			URL:         item.URL,
-		})
+		input := &Input{
+			CanonicalRules: canonicalRules,
+			Client:         client,
+			DOMRules:       domRules,
+			FormLimit:      atomic.LoadUint64(&c.formLimit),
+			Header:         requestHeader,
+			InjectRules:    injectRules,
+			JSONPaths:      jsonPaths,
+			KeepCharset:    c.keepCharset.IsSet(),
+			KeepEncoding:   c.keepEncoding.IsSet(),
+			NoCrossHost:    c.noCrossHost.IsSet(),
+			OnDemand:       item.OnDemand,
+			Parsers:        parsers,
+			Post:           item.Post,
+			Rewriter:       urlRewriter,
+			Root:           item.Root,
+			SanitizeRules:  sanitizeRules,
+			URL:            item.URL,
+			Upstreams:      upstreams,
+		}
+		if inputRewriter != nil {
+			(*inputRewriter)(input)
+		}
+
+		downloaded = Download(input)
 		atomic.AddUint64(&c.downloadedCount, 1)
 	}
 
@@ -452,14 +709,14 @@ func (c *crawler) doAutoQueue(workerID uint64, item QueueItem, downloaded *Downl
 	}
 
 	// use the same depth for asset links as they are required for proper rendering
-	c.doAutoQueueURLs(workerID, downloaded.GetAssetURLs(), downloaded.Input.URL, item.Depth)
+	c.doAutoQueueURLs(workerID, downloaded.GetAssetURLs(), downloaded.Input.URL, item, item.Depth)
 
 	// increase depth for other discovered links
 	// they will need to satisfy depth limit before crawling
-	c.doAutoQueueURLs(workerID, downloaded.GetDiscoveredURLs(), downloaded.Input.URL, item.Depth+1)
+	c.doAutoQueueURLs(workerID, downloaded.GetDiscoveredURLs(), downloaded.Input.URL, item, item.Depth+1)
 }
 
-func (c *crawler) doAutoQueueURLs(workerID uint64, urls []*neturl.URL, source *neturl.URL, nextDepth uint64) {
+func (c *crawler) doAutoQueueURLs(workerID uint64, urls []*neturl.URL, source *neturl.URL, parent QueueItem, nextDepth uint64) {
 	var (
 		count         = len(urls)
 		loggerContext = c.logger.WithFields(logrus.Fields{
@@ -476,9 +733,10 @@ func (c *crawler) doAutoQueueURLs(workerID uint64, urls []*neturl.URL, source *n
 	atomic.AddUint64(&c.linkFoundCount, uint64(count))
 	c.mutex.Lock()
 	onURLShouldQueue := c.onURLShouldQueue
+	onItemShouldQueue := c.onItemShouldQueue
 	c.mutex.Unlock()
 
-	if nextDepth > c.autoDownloadDepth {
+	if nextDepth > c.GetRootAutoDownloadDepth(parent.Root) {
 		loggerContext.WithField("links", count).Debug("Skipped because it is too deep")
 		return
 	}
@@ -492,10 +750,22 @@ func (c *crawler) doAutoQueueURLs(workerID uint64, urls []*neturl.URL, source *n
 			}
 		}
 
-		c.doEnqueue(QueueItem{
-			URL:   url,
-			Depth: nextDepth,
-		})
+		item := QueueItem{
+			URL:      url,
+			Depth:    nextDepth,
+			Root:     parent.Root,
+			Refresh:  parent.Refresh,
+			OnDemand: parent.OnDemand,
+		}
+		if onItemShouldQueue != nil {
+			shouldQueue := (*onItemShouldQueue)(item)
+			if !shouldQueue {
+				loggerContext.WithField("url", url).Debug("Skipped as instructed by onItemShouldQueue")
+				continue
+			}
+		}
+
+		c.doEnqueue(item)
 
 		loggerContext.WithField("url", url).Debug("Auto-enqueued")
 	}
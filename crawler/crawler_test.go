@@ -2,8 +2,11 @@ package crawler_test
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	neturl "net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -63,6 +66,35 @@ var _ = Describe("Crawler", func() {
 		Expect(c.GetNoCrossHost()).To(BeTrue())
 	})
 
+	It("should set keep charset", func() {
+		c := newCrawler()
+		c.SetKeepCharset(true)
+
+		Expect(c.GetKeepCharset()).To(BeTrue())
+	})
+
+	It("should set keep encoding", func() {
+		c := newCrawler()
+		c.SetKeepEncoding(true)
+
+		Expect(c.GetKeepEncoding()).To(BeTrue())
+	})
+
+	It("should set form limit", func() {
+		c := newCrawler()
+		c.SetFormLimit(10)
+
+		Expect(c.GetFormLimit()).To(Equal(uint64(10)))
+	})
+
+	It("should set upstreams", func() {
+		upstreams, _ := NewUpstreams([]Upstream{{Host: "domain.com", BearerToken: "token"}}, Proxy{})
+		c := newCrawler()
+		c.SetUpstreams(upstreams)
+
+		Expect(c.GetUpstreams().List()).To(Equal([]Upstream{{Host: "domain.com", BearerToken: "token"}}))
+	})
+
 	Describe("RequestHeader", func() {
 		var (
 			requestHeaderKey  string
@@ -162,6 +194,51 @@ var _ = Describe("Crawler", func() {
 		})
 	})
 
+	Describe("SetParser", func() {
+		It("should use registered parser", func() {
+			url := "http://domain.com/SetParser/text"
+			urlTarget := "http://domain.com/SetParser/target"
+			httpmock.RegisterResponder("GET", url, t.NewXMLResponder("text/x-custom; charset=utf-8", urlTarget))
+
+			c := newCrawler()
+			c.SetAutoDownloadDepth(uint64(0))
+			c.SetParser("text/*", func(body io.Reader, result *Downloaded) (string, []Link, error) {
+				data, err := ioutil.ReadAll(body)
+				parsedURL, _ := neturl.Parse(string(data))
+				links := []Link{{Context: HTMLTagA, URL: parsedURL}}
+
+				return strings.ToUpper(string(data)), links, err
+			})
+
+			enqueueURL(c, url)
+			defer c.Stop()
+
+			downloaded, _ := c.Downloaded()
+			Expect(downloaded.Body).To(Equal(strings.ToUpper(urlTarget)))
+			discoveredURLs := downloaded.GetDiscoveredURLs()
+			Expect(len(discoveredURLs)).To(Equal(1))
+			Expect(discoveredURLs[0].String()).To(Equal(urlTarget))
+		})
+
+		It("should keep default parsers", func() {
+			c := newCrawler()
+			c.SetParser("text/*", func(io.Reader, *Downloaded) (string, []Link, error) {
+				return "", nil, nil
+			})
+
+			Expect(c.GetParser("text/html")).ToNot(BeNil())
+			Expect(c.GetParser("text/plain")).ToNot(BeNil())
+		})
+
+		It("should remove parser", func() {
+			c := newCrawler()
+			c.RemoveParser("*/*")
+
+			Expect(c.GetParser("text/css")).ToNot(BeNil())
+			Expect(c.GetParser("image/png")).To(BeNil())
+		})
+	})
+
 	Describe("SetURLRewriter", func() {
 		It("should rewrite url", func() {
 			url := "http://domain.com/SetURLRewriter/rewrite"
@@ -223,6 +300,89 @@ var _ = Describe("Crawler", func() {
 		})
 	})
 
+	Describe("SetRootAutoDownloadDepth", func() {
+		It("should fallback to auto download depth", func() {
+			parsedURL, _ := neturl.Parse("http://domain.com/SetRootAutoDownloadDepth/fallback")
+
+			c := newCrawler()
+			c.SetAutoDownloadDepth(uint64Two)
+
+			Expect(c.GetRootAutoDownloadDepth(nil)).To(Equal(uint64Two))
+			Expect(c.GetRootAutoDownloadDepth(parsedURL)).To(Equal(uint64Two))
+		})
+
+		It("should limit depth for root", func() {
+			url := "http://domain.com/SetRootAutoDownloadDepth/limit"
+			parsedURL, _ := neturl.Parse(url)
+			urlTarget := "http://domain.com/SetRootAutoDownloadDepth/target"
+			html := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>", urlTarget))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+			httpmock.RegisterResponder("GET", urlTarget, httpmock.NewStringResponder(200, ""))
+
+			c := newCrawler()
+			c.SetRootAutoDownloadDepth(parsedURL, uint64Zero)
+
+			c.Enqueue(QueueItem{URL: parsedURL, Root: parsedURL})
+			defer c.Stop()
+
+			c.Downloaded()
+			time.Sleep(sleepTime)
+			Expect(c.GetEnqueuedCount()).To(Equal(uint64One))
+		})
+	})
+
+	Describe("SetInputRewriter", func() {
+		It("should rewrite input", func() {
+			url := "http://domain.com/SetInputRewriter/rewrite"
+			parsedURL, _ := neturl.Parse(url)
+			headerKey := "Key"
+			headerValue := "Value"
+			httpmock.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+				return httpmock.NewStringResponse(200, req.Header.Get(headerKey)), nil
+			})
+
+			c := newCrawler()
+			c.SetInputRewriter(func(input *Input) {
+				Expect(input.Root).To(Equal(parsedURL))
+
+				input.Header = make(http.Header)
+				input.Header.Set(headerKey, headerValue)
+			})
+
+			downloaded := c.Download(QueueItem{URL: parsedURL, Root: parsedURL})
+			Expect(downloaded.Body).To(Equal(headerValue))
+		})
+	})
+
+	Describe("SetOnItemShouldQueue", func() {
+		It("should pass root and depth", func() {
+			url := "http://domain.com/SetOnItemShouldQueue/root"
+			parsedURL, _ := neturl.Parse(url)
+			urlTarget := "http://domain.com/SetOnItemShouldQueue/target"
+			html := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>", urlTarget))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			c := newCrawler()
+			items := make(chan QueueItem, 1)
+			c.SetOnItemShouldQueue(func(item QueueItem) bool {
+				items <- item
+				return false
+			})
+
+			c.Enqueue(QueueItem{URL: parsedURL, Root: parsedURL})
+			defer c.Stop()
+
+			c.Downloaded()
+			item := <-items
+			Expect(item.URL.String()).To(Equal(urlTarget))
+			Expect(item.Root).To(Equal(parsedURL))
+			Expect(item.Depth).To(Equal(uint64One))
+
+			time.Sleep(sleepTime)
+			Expect(c.GetEnqueuedCount()).To(Equal(uint64One))
+		})
+	})
+
 	Describe("SetOnURLShouldDownload", func() {
 		It("should download link except one", func() {
 			url := "http://domain.com/SetOnURLShouldDownload/download/except/one"
@@ -260,6 +420,82 @@ var _ = Describe("Crawler", func() {
 		})
 	})
 
+	Describe("SetOnItemShouldDownload", func() {
+		It("should pass refresh to discovered links", func() {
+			url := "http://domain.com/SetOnItemShouldDownload/refresh"
+			parsedURL, _ := neturl.Parse(url)
+			urlTarget := "http://domain.com/SetOnItemShouldDownload/refresh/target"
+			html := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>", urlTarget))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			c := newCrawler()
+			items := make(chan QueueItem, 1)
+			c.SetOnItemShouldDownload(func(item QueueItem) bool {
+				items <- item
+				return false
+			})
+
+			c.Enqueue(QueueItem{URL: parsedURL, Root: parsedURL, ForceDownload: true, Refresh: true})
+			defer c.Stop()
+
+			c.Downloaded()
+			item := <-items
+			Expect(item.URL.String()).To(Equal(urlTarget))
+			Expect(item.Root).To(Equal(parsedURL))
+			Expect(item.Refresh).To(BeTrue())
+
+			time.Sleep(sleepTime)
+			Expect(c.GetDownloadedCount()).To(Equal(uint64One))
+		})
+
+		It("should skip onURLShouldDownload for refresh", func() {
+			url := "http://domain.com/SetOnItemShouldDownload/refresh/url"
+			parsedURL, _ := neturl.Parse(url)
+			httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, ""))
+
+			c := newCrawler()
+			c.SetOnURLShouldDownload(func(_ *neturl.URL) bool {
+				return false
+			})
+			c.SetOnItemShouldDownload(func(_ QueueItem) bool {
+				return true
+			})
+
+			c.Enqueue(QueueItem{URL: parsedURL, Root: parsedURL})
+			c.Enqueue(QueueItem{URL: parsedURL, Root: parsedURL, Refresh: true})
+			defer c.Stop()
+
+			downloaded, _ := c.Downloaded()
+			Expect(downloaded.Input.URL).To(Equal(parsedURL))
+			time.Sleep(sleepTime)
+			Expect(c.GetDownloadedCount()).To(Equal(uint64One))
+		})
+
+		It("should pass on demand to discovered links", func() {
+			url := "http://domain.com/SetOnItemShouldDownload/on-demand"
+			parsedURL, _ := neturl.Parse(url)
+			urlTarget := "http://domain.com/SetOnItemShouldDownload/on-demand/target"
+			html := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>", urlTarget))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			c := newCrawler()
+			items := make(chan QueueItem, 1)
+			c.SetOnItemShouldDownload(func(item QueueItem) bool {
+				items <- item
+				return false
+			})
+
+			c.Enqueue(QueueItem{URL: parsedURL, Root: parsedURL, ForceDownload: true, OnDemand: true})
+			defer c.Stop()
+
+			downloaded, _ := c.Downloaded()
+			Expect(downloaded.Input.OnDemand).To(BeTrue())
+			item := <-items
+			Expect(item.URL.String()).To(Equal(urlTarget))
+			Expect(item.OnDemand).To(BeTrue())
+		})
+	})
+
 	Describe("SetOnDownload", func() {
 		It("should trigger func", func() {
 			url := "http://domain.com/crawl/SetOnDownload"
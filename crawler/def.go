@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/alphagov/spotlight-gel/cacher"
 )
 
 // Crawler represents an object that can process download requests
@@ -17,19 +18,44 @@ type Crawler interface {
 	GetClientTimeout() time.Duration
 	SetAutoDownloadDepth(uint64)
 	GetAutoDownloadDepth() uint64
+	SetRootAutoDownloadDepth(*url.URL, uint64)
+	GetRootAutoDownloadDepth(*url.URL) uint64
+	RemoveRootAutoDownloadDepth(*url.URL)
 	SetNoCrossHost(bool)
 	GetNoCrossHost() bool
-	SetNoProxy(bool)
-	GetNoProxy() bool
+	SetKeepCharset(bool)
+	GetKeepCharset() bool
+	SetKeepEncoding(bool)
+	GetKeepEncoding() bool
+	SetFormLimit(uint64)
+	GetFormLimit() uint64
 	AddRequestHeader(string, string)
 	SetRequestHeader(string, string)
 	GetRequestHeaderValues(string) []string
 	SetWorkerCount(uint64) error
 	GetWorkerCount() uint64
 
+	SetCanonicalRules(*cacher.CanonicalRules)
+	GetCanonicalRules() *cacher.CanonicalRules
+	SetSanitizeRules(*SanitizeRules)
+	GetSanitizeRules() *SanitizeRules
+	SetInjectRules(*InjectRules)
+	GetInjectRules() *InjectRules
+	SetDOMRules([]DOMRule)
+	GetDOMRules() []DOMRule
+	SetJSONPaths([]JSONPath)
+	GetJSONPaths() []JSONPath
+	SetUpstreams(*Upstreams)
+	GetUpstreams() *Upstreams
+	SetParser(string, Parser)
+	RemoveParser(string)
+	GetParser(string) Parser
 	SetURLRewriter(func(*url.URL))
+	SetInputRewriter(func(*Input))
 	SetOnURLShouldQueue(func(*url.URL) bool)
+	SetOnItemShouldQueue(func(QueueItem) bool)
 	SetOnURLShouldDownload(func(*url.URL) bool)
+	SetOnItemShouldDownload(func(QueueItem) bool)
 	SetOnDownload(func(*url.URL))
 	SetOnDownloaded(func(*Downloaded))
 
@@ -54,15 +80,57 @@ type QueueItem struct {
 	URL           *url.URL
 	Depth         uint64
 	ForceDownload bool
+	Root          *url.URL
+
+	// Refresh is passed on to the discovered urls,
+	// it marks items that belong to a scheduled refresh of the root.
+	// They skip onURLShouldDownload, only onItemShouldDownload decides whether to download them again
+	Refresh bool
+	// OnDemand is passed on to the discovered urls,
+	// it marks items downloaded for requests to the mirror rather than by its crawl
+	OnDemand bool
+	// Post downloads the url with a POST request instead of GET, it is not passed on
+	Post *PostData
+}
+
+// PostData represents the body of a POST request
+type PostData struct {
+	ContentType string
+	Body        []byte
 }
 
 // Input represents a download request ready to be processed
 type Input struct {
-	Client      *http.Client
-	Header      http.Header
-	NoCrossHost bool
-	Rewriter    *func(*url.URL)
-	URL         *url.URL
+	CanonicalRules *cacher.CanonicalRules
+	Client         *http.Client
+	// DOMRules edit html documents before urls are extracted
+	DOMRules []DOMRule
+	// FormLimit is the maximum number of urls built from the fields of each GET form, 0 builds none
+	FormLimit uint64
+	Header    http.Header
+	// InjectRules adds markup to html documents, nil adds nothing
+	InjectRules *InjectRules
+	JSONPaths   []JSONPath
+	// KeepCharset re-encodes parsed documents to their original charset instead of UTF-8
+	KeepCharset bool
+	// KeepEncoding keeps gzip, br or zstd bodies as received when parsing leaves them unchanged,
+	// e.g. the content types without parser
+	KeepEncoding bool
+	// MaxBodyBytes fails the download of bodies larger than the value, 0 means no limit
+	MaxBodyBytes uint64
+	NoCrossHost  bool
+	// OnDemand is copied from QueueItem.OnDemand
+	OnDemand bool
+	Parsers  Parsers
+	// Post sends a POST request instead of GET
+	Post     *PostData
+	Rewriter *func(*url.URL)
+	Root     *url.URL
+	// SanitizeRules removes elements and attributes from html documents, nil keeps them
+	SanitizeRules *SanitizeRules
+	URL           *url.URL
+	// Upstreams selects the credentials and transport of each host, nil uses Client as is
+	Upstreams *Upstreams
 }
 
 // Downloaded represents processed data after downloading
@@ -79,6 +147,9 @@ type Downloaded struct {
 	buffer                  *bytes.Buffer
 	header                  http.Header
 	addedHeaderCrossHostRef bool
+	injectedHead            bool
+	injectedBanner          bool
+	form                    *htmlForm
 }
 
 // Link represents an extracted link from download result
@@ -90,6 +161,10 @@ type Link struct {
 const (
 	// CSSUri url from url()
 	CSSUri urlContext = 1 + iota
+	// CSSImport url from @import ""
+	CSSImport
+	// CSSImageSet url from image-set("" 1x)
+	CSSImageSet
 	// HTMLTagA url from <a href=""></a>
 	HTMLTagA
 	// HTMLTagForm url from <form action="" />
@@ -100,8 +175,54 @@ const (
 	HTMLTagLinkStylesheet
 	// HTMLTagScript url from <script src="" />
 	HTMLTagScript
+	// HTMLTagImgSrcset url from <img srcset="" />
+	HTMLTagImgSrcset
+	// HTMLTagSource url from <source src="" srcset="" /> inside <picture>, <video> or <audio>
+	HTMLTagSource
+	// HTMLTagVideo url from <video src="" poster=""></video>
+	HTMLTagVideo
+	// HTMLTagAudio url from <audio src=""></audio>
+	HTMLTagAudio
+	// HTMLTagTrack url from <track src="" />
+	HTMLTagTrack
+	// HTMLTagLinkIcon url from <link rel="icon" href="" />, including apple-touch-icon and mask-icon
+	HTMLTagLinkIcon
+	// HTMLTagLinkPreload url from <link rel="preload" href="" />, including modulepreload and prefetch
+	HTMLTagLinkPreload
+	// HTMLTagLinkManifest url from <link rel="manifest" href="" />
+	HTMLTagLinkManifest
+	// HTMLTagLinkAlternate url from <link rel="alternate" href="" /> (feeds, translations, etc.)
+	HTMLTagLinkAlternate
+	// HTMLTagLinkCanonical url from <link rel="canonical" href="" />
+	HTMLTagLinkCanonical
+	// HTMLTagIframe url from <iframe src=""></iframe>
+	HTMLTagIframe
+	// HTMLTagObject url from <object data=""></object>
+	HTMLTagObject
+	// HTMLTagEmbed url from <embed src="" />
+	HTMLTagEmbed
+	// HTMLTagMetaRefresh url from <meta http-equiv="refresh" content="0; url=" />
+	HTMLTagMetaRefresh
+	// SVGHref url from SVG <image href="" />, <use xlink:href="" />, etc.
+	SVGHref
+	// SVGTagA url from SVG <a xlink:href=""></a>
+	SVGTagA
+	// XMLStylesheet url from <?xml-stylesheet href="" ?>
+	XMLStylesheet
+	// FeedLink url from RSS <link></link> or Atom <link href="" />
+	FeedLink
+	// FeedEnclosure url from RSS <enclosure url="" /> or Atom <link rel="enclosure" href="" />
+	FeedEnclosure
+	// SitemapLoc url from sitemap <loc></loc>
+	SitemapLoc
+	// SitemapImage url from image sitemap <image:loc></image:loc>
+	SitemapImage
+	// JSONValue url from a JSON string value
+	JSONValue
 	// HTTP3xxLocation url from HTTP response code 3xx
 	HTTP3xxLocation
+	// HTMLTagFormQuery url built from the fields of <form method="get" action="">
+	HTMLTagFormQuery
 )
 
 type urlContext int
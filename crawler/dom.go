@@ -0,0 +1,229 @@
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	htmlAtom "golang.org/x/net/html/atom"
+)
+
+// DOMRule represents a structural edit of the html documents, applied to the elements matching a selector
+// before urls are extracted
+type DOMRule struct {
+	Action   string
+	Selector Selector
+	// HTML is the markup of DOMReplace and the wrapper of DOMWrap
+	HTML string
+	// Attr and Value are the attribute set by DOMSetAttr
+	Attr  string
+	Value string
+}
+
+const (
+	// DOMRemove removes the elements
+	DOMRemove = "remove"
+	// DOMReplace replaces the elements with html
+	DOMReplace = "replace"
+	// DOMSetAttr sets an attribute of the elements
+	DOMSetAttr = "set-attr"
+	// DOMWrap moves the elements into a copy of a html wrapper
+	DOMWrap = "wrap"
+)
+
+const domRuleSeparator = "=>"
+
+// Selector represents a CSS selector matching html elements, see github.com/andybalholm/cascadia for the syntax
+type Selector struct {
+	value string
+	group cascadia.SelectorGroup
+}
+
+// ParseSelector returns the Selector represented by a string such as 'div.banner > a[href^="/login"]'
+func ParseSelector(value string) (Selector, error) {
+	group, err := cascadia.ParseGroup(value)
+	if err != nil {
+		return Selector{}, fmt.Errorf("selector %q: %v", value, err)
+	}
+
+	return Selector{value: value, group: group}, nil
+}
+
+// MatchAll returns the matching elements of a tree in document order
+func (s Selector) MatchAll(root *html.Node) []*html.Node {
+	return cascadia.QueryAll(root, s.group)
+}
+
+func (s Selector) String() string {
+	return s.value
+}
+
+// ParseDOMRule returns the DOMRule represented by a string such as
+// 'remove #cookie-banner', 'replace form.search => <p>Search is disabled</p>',
+// 'set-attr a[href^="http"] => rel=nofollow' or 'wrap table => <div class="scroll"></div>'
+func ParseDOMRule(value string) (DOMRule, error) {
+	rule := DOMRule{}
+
+	parts := strings.SplitN(strings.TrimSpace(value), " ", 2)
+	if len(parts) < 2 {
+		return rule, fmt.Errorf("rule %q must be 'action selector'", value)
+	}
+	rule.Action = parts[0]
+
+	selectorValue, arg := parts[1], ""
+	hasArg := false
+	if i := strings.Index(parts[1], domRuleSeparator); i > -1 {
+		selectorValue, arg = parts[1][:i], strings.TrimSpace(parts[1][i+len(domRuleSeparator):])
+		hasArg = true
+	}
+
+	selector, err := ParseSelector(strings.TrimSpace(selectorValue))
+	if err != nil {
+		return rule, err
+	}
+	rule.Selector = selector
+
+	switch rule.Action {
+	case DOMRemove:
+		if hasArg {
+			return rule, fmt.Errorf("rule %q: %s takes no argument", value, rule.Action)
+		}
+	case DOMReplace:
+		rule.HTML = arg
+	case DOMWrap:
+		if len(arg) == 0 {
+			return rule, fmt.Errorf("rule %q: %s requires a wrapper", value, rule.Action)
+		}
+		rule.HTML = arg
+		if parseDOMWrapper(arg, nil) == nil {
+			return rule, fmt.Errorf("rule %q: wrapper must be an element", value)
+		}
+	case DOMSetAttr:
+		attrParts := strings.SplitN(arg, "=", 2)
+		if len(attrParts[0]) == 0 {
+			return rule, fmt.Errorf("rule %q: %s requires 'attr=value'", value, rule.Action)
+		}
+		rule.Attr = strings.ToLower(strings.TrimSpace(attrParts[0]))
+		if len(attrParts) > 1 {
+			rule.Value = attrParts[1]
+		}
+	default:
+		return rule, fmt.Errorf("rule %q has unknown action %q", value, rule.Action)
+	}
+
+	return rule, nil
+}
+
+func (r DOMRule) String() string {
+	switch r.Action {
+	case DOMReplace, DOMWrap:
+		return fmt.Sprintf("%s %s %s %s", r.Action, r.Selector, domRuleSeparator, r.HTML)
+	case DOMSetAttr:
+		return fmt.Sprintf("%s %s %s %s=%s", r.Action, r.Selector, domRuleSeparator, r.Attr, r.Value)
+	}
+
+	return fmt.Sprintf("%s %s", r.Action, r.Selector)
+}
+
+// Apply edits the elements of a tree matching the rule selector
+func (r DOMRule) Apply(root *html.Node) {
+	for _, n := range r.Selector.MatchAll(root) {
+		if n.Parent == nil {
+			// already removed by a previous match
+			continue
+		}
+
+		switch r.Action {
+		case DOMRemove:
+			n.Parent.RemoveChild(n)
+		case DOMReplace:
+			for _, replacement := range parseDOMFragment(r.HTML, n.Parent) {
+				n.Parent.InsertBefore(replacement, n)
+			}
+			n.Parent.RemoveChild(n)
+		case DOMSetAttr:
+			setNodeAttr(n, r.Attr, r.Value)
+		case DOMWrap:
+			wrapper := parseDOMWrapper(r.HTML, n.Parent)
+			if wrapper == nil {
+				continue
+			}
+
+			n.Parent.InsertBefore(wrapper, n)
+			n.Parent.RemoveChild(n)
+
+			inner := wrapper
+			for child := firstElementChild(inner); child != nil; child = firstElementChild(inner) {
+				inner = child
+			}
+			inner.AppendChild(n)
+		}
+	}
+}
+
+// applyDOMRules parses a html document, applies the rules and returns the rendered document
+func applyDOMRules(r io.Reader, rules []DOMRule) (io.Reader, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		rule.Apply(doc)
+	}
+
+	var buffer bytes.Buffer
+	if err := html.Render(&buffer, doc); err != nil {
+		return nil, err
+	}
+
+	return &buffer, nil
+}
+
+func parseDOMFragment(markup string, context *html.Node) []*html.Node {
+	if context == nil || context.Type != html.ElementNode {
+		context = &html.Node{Type: html.ElementNode, Data: "div", DataAtom: htmlAtom.Div}
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(markup), context)
+	if err != nil {
+		return nil
+	}
+
+	return nodes
+}
+
+// parseDOMWrapper returns the first element of the markup
+func parseDOMWrapper(markup string, context *html.Node) *html.Node {
+	for _, n := range parseDOMFragment(markup, context) {
+		if n.Type == html.ElementNode {
+			return n
+		}
+	}
+
+	return nil
+}
+
+func setNodeAttr(n *html.Node, key string, value string) {
+	for i, attr := range n.Attr {
+		if len(attr.Namespace) == 0 && attr.Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+func firstElementChild(n *html.Node) *html.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode {
+			return child
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package crawler_test
+
+import (
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"gopkg.in/jarcoal/httpmock.v1"
+
+	. "github.com/alphagov/spotlight-gel/crawler"
+	t "github.com/alphagov/spotlight-gel/testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DOMRule", func() {
+	apply := func(markup string, values ...string) string {
+		doc, err := html.Parse(strings.NewReader(markup))
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, value := range values {
+			rule, err := ParseDOMRule(value)
+			Expect(err).ToNot(HaveOccurred())
+			rule.Apply(doc)
+		}
+
+		var rendered strings.Builder
+		Expect(html.Render(&rendered, doc)).To(Succeed())
+
+		return rendered.String()
+	}
+
+	wrapBody := func(body string) string {
+		return "<html><head></head><body>" + body + "</body></html>"
+	}
+
+	Describe("Apply", func() {
+		It("should remove", func() {
+			Expect(apply(wrapBody(`<div class="cookie"><p>Cookies</p></div><p>Content</p><div class="cookie"></div>`),
+				"remove .cookie")).To(Equal(wrapBody(`<p>Content</p>`)))
+		})
+
+		It("should remove nested matches", func() {
+			Expect(apply(wrapBody(`<div><div><p>Widget</p></div></div><p>Content</p>`),
+				"remove div")).To(Equal(wrapBody(`<p>Content</p>`)))
+		})
+
+		It("should replace with html", func() {
+			Expect(apply(wrapBody(`<form class="search"><input name="q"></form>`),
+				"replace form.search => <p>Search is not available</p> in the archive")).
+				To(Equal(wrapBody(`<p>Search is not available</p> in the archive`)))
+		})
+
+		It("should replace table rows", func() {
+			Expect(apply(wrapBody(`<table><tbody><tr class="ad"><td>Ad</td></tr></tbody></table>`),
+				"replace tr.ad => <tr><td>Removed</td></tr>")).
+				To(Equal(wrapBody(`<table><tbody><tr><td>Removed</td></tr></tbody></table>`)))
+		})
+
+		It("should replace with nothing", func() {
+			Expect(apply(wrapBody(`<p>One</p><p class="x">Two</p>`), "replace .x =>")).To(Equal(wrapBody(`<p>One</p>`)))
+		})
+
+		It("should set attribute", func() {
+			Expect(apply(wrapBody(`<a href="/a">A</a><a href="http://other.com" rel="me">B</a>`),
+				`set-attr a[href^="http"] => rel=nofollow noopener`)).
+				To(Equal(wrapBody(`<a href="/a">A</a><a href="http://other.com" rel="nofollow noopener">B</a>`)))
+		})
+
+		It("should wrap", func() {
+			Expect(apply(wrapBody(`<table><tbody><tr><td>1</td></tr></tbody></table>`),
+				`wrap table => <div class="scroll"><div class="inner"></div></div>`)).
+				To(Equal(wrapBody(`<div class="scroll"><div class="inner"><table><tbody><tr><td>1</td></tr></tbody></table></div></div>`)))
+		})
+
+		It("should apply rules in order", func() {
+			Expect(apply(wrapBody(`<p class="a">A</p>`),
+				"set-attr .a => class=b",
+				"remove .b")).To(Equal(wrapBody(``)))
+		})
+	})
+
+	Describe("ParseDOMRule", func() {
+		It("should parse", func() {
+			rule, err := ParseDOMRule(`set-attr  a.external => target=_blank`)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rule.Action).To(Equal(DOMSetAttr))
+			Expect(rule.Selector.String()).To(Equal("a.external"))
+			Expect(rule.Attr).To(Equal("target"))
+			Expect(rule.Value).To(Equal("_blank"))
+			Expect(rule.String()).To(Equal("set-attr a.external => target=_blank"))
+		})
+
+		It("should not parse invalid rule", func() {
+			for _, value := range []string{
+				"remove",
+				"hide .cookie",
+				"remove .cookie => <p></p>",
+				"remove a[",
+				"remove a:hover",
+				"remove div >",
+				"wrap table",
+				"wrap table => text",
+				"set-attr a",
+				"set-attr a => =value",
+			} {
+				_, err := ParseDOMRule(value)
+				Expect(err).To(HaveOccurred(), value)
+			}
+		})
+	})
+
+	Describe("Download", func() {
+		BeforeEach(func() {
+			httpmock.Activate()
+		})
+
+		AfterEach(func() {
+			httpmock.DeactivateAndReset()
+		})
+
+		It("should apply rules before extracting urls", func() {
+			url := "http://domain.com/dom/download"
+			markup := `<div id="login"><a href="http://domain.com/login">Login</a><img src="http://domain.com/avatar.png"></div>` +
+				`<a href="http://domain.com/dom/page">Page</a>`
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(t.NewHTMLMarkup(markup)))
+			parsedURL, _ := neturl.Parse(url)
+			rule, _ := ParseDOMRule("replace #login => <p>Login is disabled</p>")
+
+			downloaded := Download(&Input{
+				Client:   http.DefaultClient,
+				DOMRules: []DOMRule{rule},
+				URL:      parsedURL,
+			})
+
+			Expect(downloaded.Body).To(Equal(`<html><head><title>Title</title></head><body>` +
+				`<p>Login is disabled</p><a href="./page">Page</a></body></html>`))
+			Expect(len(downloaded.LinksDiscovered)).To(Equal(1))
+			Expect(downloaded.LinksAssets).To(BeEmpty())
+		})
+	})
+})
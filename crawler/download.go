@@ -2,8 +2,12 @@ package crawler
 
 import (
 	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	neturl "net/url"
 	"regexp"
@@ -13,20 +17,58 @@ import (
 	cssScanner "github.com/gorilla/css/scanner"
 	"golang.org/x/net/html"
 	htmlAtom "golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
 )
 
 var (
-	cssURIRegexp = regexp.MustCompile(`^(url\(['"]?)([^'"]+)(['"]?\))$`)
+	// ErrBodyTooLarge is the error of downloads with a body larger than Input.MaxBodyBytes
+	ErrBodyTooLarge = errors.New("body is larger than the maximum size")
+
+	cssURIRegexp          = regexp.MustCompile(`^(url\(['"]?)([^'"]+)(['"]?\))$`)
+	xmlStylesheetRegexp   = regexp.MustCompile(`(\bhref\s*=\s*["'])([^"']+)(["'])`)
+	htmlMetaRefreshRegexp = regexp.MustCompile(`(?i)^(\s*[\d.]*\s*[;,]\s*(?:url\s*=\s*)?['"]?)([^'"\s]+)(['"]?\s*)$`)
+
+	// htmlLinkRelContexts maps <link rel="" /> tokens to contexts, the first matching token wins
+	htmlLinkRelContexts = []struct {
+		rel     string
+		context urlContext
+	}{
+		{htmlAttrRelStylesheet, HTMLTagLinkStylesheet},
+		{"icon", HTMLTagLinkIcon},
+		{"apple-touch-icon", HTMLTagLinkIcon},
+		{"apple-touch-icon-precomposed", HTMLTagLinkIcon},
+		{"mask-icon", HTMLTagLinkIcon},
+		{"preload", HTMLTagLinkPreload},
+		{"modulepreload", HTMLTagLinkPreload},
+		{"prefetch", HTMLTagLinkPreload},
+		{"manifest", HTMLTagLinkManifest},
+		{"alternate", HTMLTagLinkAlternate},
+		{"canonical", HTMLTagLinkCanonical},
+	}
 )
 
 const (
 	htmlAttrAction        = "action"
+	htmlAttrContent       = "content"
+	htmlAttrData          = "data"
 	htmlAttrHref          = "href"
+	htmlAttrHTTPEquiv     = "http-equiv"
+	htmlAttrPoster        = "poster"
 	htmlAttrRel           = "rel"
 	htmlAttrRelStylesheet = "stylesheet"
 	htmlAttrSrc           = "src"
+	htmlAttrSrcset        = "srcset"
+	svgAttrXlinkHref      = "xlink:href"
 )
 
+// svgHrefElements are the inline svg elements without html atom that reference urls
+var svgHrefElements = map[string]bool{
+	"use":     true,
+	"feimage": true,
+}
+
 //noinspection GoUnusedParameter
 func checkRedirect(*http.Request, []*http.Request) error {
 	// do not follow redirects
@@ -73,7 +115,12 @@ func Download(input *Input) *Downloaded {
 		url.Path = "/"
 	}
 
-	req, err := http.NewRequest("GET", url.String(), nil)
+	method, body := "GET", io.Reader(nil)
+	if input.Post != nil {
+		method, body = "POST", bytes.NewReader(input.Post.Body)
+	}
+
+	req, err := http.NewRequest(method, url.String(), body)
 	if err != nil {
 		result.Error = err
 		return result
@@ -87,6 +134,17 @@ func Download(input *Input) *Downloaded {
 		}
 	}
 
+	if input.Post != nil && len(input.Post.ContentType) > 0 {
+		req.Header.Set("Content-Type", input.Post.ContentType)
+	}
+
+	// a custom Accept-Encoding would stop the transport from decoding the body, see parseBody
+	req.Header.Set(cacher.HeaderAcceptEncoding, AcceptEncoding)
+
+	if transport := input.Upstreams.Apply(req); transport != nil {
+		httpClient.Transport = transport
+	}
+
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		result.Error = err
@@ -115,26 +173,216 @@ func parseBody(resp *http.Response, result *Downloaded) error {
 		result.AddHeader(cacher.HeaderExpires, respHeaderExpires)
 	}
 
+	maxBodyBytes := result.Input.MaxBodyBytes
+	if maxBodyBytes > 0 && resp.ContentLength > 0 && uint64(resp.ContentLength) > maxBodyBytes {
+		return ErrBodyTooLarge
+	}
+
+	var (
+		respBody        io.Reader = resp.Body
+		encoded         []byte
+		decoded         []byte
+		contentEncoding = resp.Header.Get(cacher.HeaderContentEncoding)
+		limiters        []*bodyLimiter
+	)
+	limit := func(r io.Reader) io.Reader {
+		if maxBodyBytes == 0 {
+			return r
+		}
+		limiter := &bodyLimiter{r: r, limit: maxBodyBytes}
+		limiters = append(limiters, limiter)
+		return limiter
+	}
+	if len(contentEncoding) > 0 && result.Input.KeepEncoding {
+		var err error
+		if encoded, decoded, err = readEncodedBody(contentEncoding, limit(resp.Body), limit); err != nil {
+			return err
+		}
+		respBody = bytes.NewReader(decoded)
+	} else if len(contentEncoding) > 0 {
+		decoder, err := NewDecoder(contentEncoding, limit(resp.Body))
+		if err != nil {
+			return err
+		}
+		defer decoder.Close()
+		respBody = limit(decoder)
+	} else {
+		respBody = limit(respBody)
+	}
+
+	peeked, reader := peekBody(respBody, 1024)
+
 	respHeaderContentType := resp.Header.Get(cacher.HeaderContentType)
-	if len(respHeaderContentType) > 0 {
-		result.AddHeader(cacher.HeaderContentType, respHeaderContentType)
+	contentType := respHeaderContentType
+	mediaType := ParseMediaType(contentType)
+	if genericMediaTypes[mediaType] && len(peeked) > 0 {
+		mediaType = ParseMediaType(DetectContentType(peeked))
+		if mediaType != "application/octet-stream" {
+			// the sniffed charset is only a guess, keep it out of the header
+			contentType = mediaType
+		}
+	}
+	if len(contentType) > 0 {
+		result.AddHeader(cacher.HeaderContentType, contentType)
+	}
 
-		parts := strings.Split(respHeaderContentType, ";")
-		contentType := parts[0]
+	var (
+		body      io.Reader = reader
+		encoder   encoding.Encoding
+		transcode bool
+	)
+	if isTextMediaType(mediaType) {
+		var name string
+		encoder, name = determineCharset(peeked, respHeaderContentType, mediaType)
+		if encoder != nil && name != "utf-8" {
+			body = transform.NewReader(reader, encoder.NewDecoder())
+			transcode = true
+		}
+	}
+
+	parsers := result.Input.Parsers
+	if parsers == nil {
+		parsers = defaultParsers
+	}
+
+	parser := parsers.Find(mediaType)
+	if parser == nil {
+		parser = parseBodyRaw
+	}
+
+	parsed, links, err := parser(body, result)
+	for _, limiter := range limiters {
+		if limiter.exceeded() {
+			// the parsers may stop at the read error, do not keep a partial body
+			return ErrBodyTooLarge
+		}
+	}
+	result.Body = parsed
+	for _, link := range links {
+		result.AddLink(link)
+	}
 
-		switch contentType {
-		case "text/css":
-			return parseBodyCSS(resp, result)
-		case "text/html":
-			return parseBodyHTML(resp, result)
+	if transcode {
+		if result.Input.KeepCharset {
+			result.Body, _ = encoding.ReplaceUnsupported(encoder.NewEncoder()).String(parsed)
+		} else {
+			result.SetHeader(cacher.HeaderContentType, mediaType+"; charset=utf-8")
 		}
 	}
 
-	return parseBodyRaw(resp, result)
+	if encoded != nil && err == nil && result.Body == string(decoded) {
+		// the body is left unchanged by the parser, keep it as received
+		result.Body = string(encoded)
+		result.AddHeader(cacher.HeaderContentEncoding, contentEncoding)
+		result.AddHeader(cacher.HeaderVary, cacher.HeaderAcceptEncoding)
+	}
+
+	return err
+}
+
+// peekBody returns the first bytes of the body and a reader of the whole body,
+// read errors are returned by the reader
+func peekBody(r io.Reader, size int) ([]byte, io.Reader) {
+	peeked := make([]byte, size)
+	n, err := io.ReadFull(r, peeked)
+	peeked = peeked[:n]
+
+	switch err {
+	case nil:
+		return peeked, io.MultiReader(bytes.NewReader(peeked), r)
+	case io.EOF, io.ErrUnexpectedEOF:
+		return peeked, bytes.NewReader(peeked)
+	}
+
+	return peeked, io.MultiReader(bytes.NewReader(peeked), &errorReader{err})
 }
 
-func parseBodyCSS(resp *http.Response, result *Downloaded) error {
-	body, _ := ioutil.ReadAll(resp.Body)
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// bodyLimiter reads up to one byte over the limit to tell whether the body is larger
+type bodyLimiter struct {
+	r     io.Reader
+	limit uint64
+	read  uint64
+}
+
+func (l *bodyLimiter) Read(p []byte) (int, error) {
+	if l.exceeded() {
+		return 0, ErrBodyTooLarge
+	}
+
+	if remaining := l.limit + 1 - l.read; uint64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.r.Read(p)
+	l.read += uint64(n)
+	if l.exceeded() {
+		return n, ErrBodyTooLarge
+	}
+
+	return n, err
+}
+
+func (l *bodyLimiter) exceeded() bool {
+	return l.read > l.limit
+}
+
+// determineCharset returns the encoding declared by a byte order mark, the Content-Type header
+// or a html meta tag, unlabelled bodies are left alone (nil)
+func determineCharset(peeked []byte, contentType string, mediaType string) (encoding.Encoding, string) {
+	if e, name, certain := charset.DetermineEncoding(peeked, contentType); certain {
+		return e, name
+	}
+
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ""
+	}
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(peeked))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return nil, ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.DataAtom != htmlAtom.Meta {
+				continue
+			}
+
+			var label, httpEquiv, content string
+			for _, attr := range token.Attr {
+				switch strings.ToLower(attr.Key) {
+				case "charset":
+					label = attr.Val
+				case htmlAttrHTTPEquiv:
+					httpEquiv = strings.ToLower(attr.Val)
+				case htmlAttrContent:
+					content = attr.Val
+				}
+			}
+			if len(label) == 0 && httpEquiv == "content-type" {
+				if _, params, err := mime.ParseMediaType(content); err == nil {
+					label = params["charset"]
+				}
+			}
+
+			if len(label) > 0 {
+				if e, name := charset.Lookup(label); e != nil {
+					return e, name
+				}
+			}
+		}
+	}
+}
+
+func parseBodyCSS(r io.Reader, result *Downloaded) (string, []Link, error) {
+	body, _ := ioutil.ReadAll(r)
 
 	var buffer bytes.Buffer
 	defer buffer.Reset()
@@ -142,13 +390,18 @@ func parseBodyCSS(resp *http.Response, result *Downloaded) error {
 
 	err := parseBodyCSSString(string(body), result)
 
-	result.Body = buffer.String()
 	result.buffer = nil
 
-	return err
+	return buffer.String(), nil, err
 }
 
 func parseBodyCSSString(css string, result *Downloaded) error {
+	var (
+		inImport      bool
+		imageSetDepth int
+		functionDepth int
+	)
+
 	scanner := cssScanner.New(css)
 	for {
 		token := scanner.Next()
@@ -156,10 +409,62 @@ func parseBodyCSSString(css string, result *Downloaded) error {
 			break
 		}
 
+		var stringContext urlContext
+		uriContext := CSSUri
+		switch token.Type {
+		case cssScanner.TokenAtKeyword:
+			inImport = strings.EqualFold(token.Value, "@import")
+		case cssScanner.TokenS, cssScanner.TokenComment:
+		case cssScanner.TokenString:
+			if inImport {
+				stringContext = CSSImport
+			} else if imageSetDepth > 0 && functionDepth == imageSetDepth {
+				stringContext = CSSImageSet
+			}
+			inImport = false
+		case cssScanner.TokenFunction:
+			functionDepth++
+			if name := strings.ToLower(token.Value); name == "image-set(" || name == "-webkit-image-set(" {
+				imageSetDepth = functionDepth
+			}
+			inImport = false
+		case cssScanner.TokenChar:
+			if token.Value == "(" {
+				functionDepth++
+			} else if token.Value == ")" && functionDepth > 0 {
+				if functionDepth == imageSetDepth {
+					imageSetDepth = 0
+				}
+				functionDepth--
+			}
+			inImport = false
+		case cssScanner.TokenURI:
+			if inImport {
+				uriContext = CSSImport
+			}
+			inImport = false
+		default:
+			inImport = false
+		}
+
+		if stringContext > 0 && len(token.Value) > 1 {
+			// string token value includes the quotes
+			quote, url := token.Value[:1], token.Value[1:len(token.Value)-1]
+			if !strings.Contains(url, "\\") {
+				processedURL, err := result.ProcessURL(stringContext, url)
+				if err == nil && processedURL != url {
+					result.buffer.WriteString(quote)
+					result.buffer.WriteString(processedURL)
+					result.buffer.WriteString(quote)
+					continue
+				}
+			}
+		}
+
 		if token.Type == cssScanner.TokenURI {
 			if m := cssURIRegexp.FindStringSubmatch(token.Value); m != nil {
 				before, url, after := m[1], m[2], m[3]
-				processedURL, err := result.ProcessURL(CSSUri, url)
+				processedURL, err := result.ProcessURL(uriContext, url)
 				if err == nil && processedURL != url {
 					result.buffer.WriteString(before)
 					result.buffer.WriteString(processedURL)
@@ -175,22 +480,30 @@ func parseBodyCSSString(css string, result *Downloaded) error {
 	return nil
 }
 
-func parseBodyHTML(resp *http.Response, result *Downloaded) error {
+func parseBodyHTML(r io.Reader, result *Downloaded) (string, []Link, error) {
+	if len(result.Input.DOMRules) > 0 {
+		edited, err := applyDOMRules(r, result.Input.DOMRules)
+		if err != nil {
+			return "", nil, err
+		}
+		r = edited
+	}
+
 	var buffer bytes.Buffer
 	defer buffer.Reset()
 	result.buffer = &buffer
 
-	tokenizer := html.NewTokenizer(resp.Body)
+	tokenizer := html.NewTokenizer(r)
 	for {
 		if parseBodyHTMLToken(tokenizer, result) {
 			break
 		}
 	}
+	injectHTMLEnd(result)
 
-	result.Body = buffer.String()
 	result.buffer = nil
 
-	return nil
+	return buffer.String(), nil, nil
 }
 
 func parseBodyHTMLToken(tokenizer *html.Tokenizer, result *Downloaded) bool {
@@ -203,6 +516,10 @@ func parseBodyHTMLToken(tokenizer *html.Tokenizer, result *Downloaded) bool {
 	switch tokenType {
 	case html.StartTagToken:
 		token := tokenizer.Token()
+		if sanitizeHTMLToken(tokenizer, &token, result) || injectHTMLToken(&token, result) {
+			return false
+		}
+		trackHTMLFormToken(&token, result)
 
 		switch token.DataAtom {
 		case htmlAtom.A:
@@ -217,6 +534,28 @@ func parseBodyHTMLToken(tokenizer *html.Tokenizer, result *Downloaded) bool {
 			done = parseBodyHTMLTagScript(tokenizer, &token, result)
 		case htmlAtom.Style:
 			done = parseBodyHTMLTagStyle(tokenizer, result)
+		case htmlAtom.Source:
+			done = parseBodyHTMLTagMedia(HTMLTagSource, &token, result)
+		case htmlAtom.Video:
+			done = parseBodyHTMLTagMedia(HTMLTagVideo, &token, result)
+		case htmlAtom.Audio:
+			done = parseBodyHTMLTagMedia(HTMLTagAudio, &token, result)
+		case htmlAtom.Track:
+			done = parseBodyHTMLTagMedia(HTMLTagTrack, &token, result)
+		case htmlAtom.Iframe:
+			done = parseBodyHTMLTagAttr(HTMLTagIframe, htmlAttrSrc, &token, result)
+		case htmlAtom.Object:
+			done = parseBodyHTMLTagAttr(HTMLTagObject, htmlAttrData, &token, result)
+		case htmlAtom.Embed:
+			done = parseBodyHTMLTagAttr(HTMLTagEmbed, htmlAttrSrc, &token, result)
+		case htmlAtom.Meta:
+			done = parseBodyHTMLTagMeta(&token, result)
+		case htmlAtom.Image:
+			done = parseBodyHTMLTagSVG(&token, result)
+		default:
+			if svgHrefElements[token.Data] {
+				done = parseBodyHTMLTagSVG(&token, result)
+			}
 		}
 
 		if !done {
@@ -224,6 +563,10 @@ func parseBodyHTMLToken(tokenizer *html.Tokenizer, result *Downloaded) bool {
 		}
 	case html.SelfClosingTagToken:
 		token := tokenizer.Token()
+		if sanitizeHTMLToken(tokenizer, &token, result) || injectHTMLToken(&token, result) {
+			return false
+		}
+		trackHTMLFormToken(&token, result)
 
 		switch token.DataAtom {
 		case htmlAtom.Base:
@@ -232,11 +575,32 @@ func parseBodyHTMLToken(tokenizer *html.Tokenizer, result *Downloaded) bool {
 			done = parseBodyHTMLTagImg(&token, result)
 		case htmlAtom.Link:
 			done = parseBodyHTMLTagLink(&token, result)
+		case htmlAtom.Source:
+			done = parseBodyHTMLTagMedia(HTMLTagSource, &token, result)
+		case htmlAtom.Track:
+			done = parseBodyHTMLTagMedia(HTMLTagTrack, &token, result)
+		case htmlAtom.Embed:
+			done = parseBodyHTMLTagAttr(HTMLTagEmbed, htmlAttrSrc, &token, result)
+		case htmlAtom.Meta:
+			done = parseBodyHTMLTagMeta(&token, result)
+		case htmlAtom.Image:
+			done = parseBodyHTMLTagSVG(&token, result)
+		default:
+			if svgHrefElements[token.Data] {
+				done = parseBodyHTMLTagSVG(&token, result)
+			}
 		}
 
 		if !done {
 			done = rewriteTokenAttr(&token, result)
 		}
+	case html.EndTagToken:
+		name, _ := tokenizer.TagName()
+		tag := htmlAtom.Lookup(name)
+		injectHTMLEndTag(tag, result)
+		trackHTMLFormEndTag(tag, result)
+	case html.TextToken:
+		trackHTMLFormText(tokenizer, result)
 	}
 
 	if !done {
@@ -291,6 +655,15 @@ func parseBodyHTMLTagImg(token *html.Token, result *Downloaded) bool {
 	needRewrite := false
 
 	for i, attr := range token.Attr {
+		if attr.Key == htmlAttrSrcset {
+			processedSrcset := parseBodySrcset(HTMLTagImgSrcset, attr.Val, result)
+			if processedSrcset != attr.Val {
+				token.Attr[i].Val = processedSrcset
+				needRewrite = true
+			}
+			continue
+		}
+
 		if attr.Key != htmlAttrSrc && !strings.HasPrefix(attr.Key, "data-") {
 			// process src attribute and any data-* attribute that contains url
 			// some website uses those for lazy loading / high resolution quality / etc.
@@ -338,9 +711,8 @@ func parseBodyHTMLTagLink(token *html.Token, result *Downloaded) bool {
 	}
 
 	if len(linkHref) > 0 {
-		switch linkRel {
-		case htmlAttrRelStylesheet:
-			processedURL, err := result.ProcessURL(HTMLTagLinkStylesheet, linkHref)
+		if context, ok := getLinkRelContext(linkRel); ok {
+			processedURL, err := result.ProcessURL(context, linkHref)
 			if err == nil && processedURL != linkHref {
 				token.Attr[linkHrefAttrIndex].Val = processedURL
 				return rewriteTokenAttr(token, result)
@@ -351,6 +723,122 @@ func parseBodyHTMLTagLink(token *html.Token, result *Downloaded) bool {
 	return false
 }
 
+func getLinkRelContext(rel string) (urlContext, bool) {
+	// rel is a set of space separated tokens, e.g. "stylesheet preload"
+	tokens := strings.Fields(strings.ToLower(rel))
+
+	for _, relContext := range htmlLinkRelContexts {
+		for _, token := range tokens {
+			if token == relContext.rel {
+				return relContext.context, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func parseBodyHTMLTagMeta(token *html.Token, result *Downloaded) bool {
+	contentAttrIndex := -1
+	isRefresh := false
+
+	for i, attr := range token.Attr {
+		switch attr.Key {
+		case htmlAttrContent:
+			contentAttrIndex = i
+		case htmlAttrHTTPEquiv:
+			isRefresh = strings.EqualFold(strings.TrimSpace(attr.Val), "refresh")
+		}
+	}
+
+	if !isRefresh || contentAttrIndex < 0 {
+		return false
+	}
+
+	content := token.Attr[contentAttrIndex].Val
+	m := htmlMetaRefreshRegexp.FindStringSubmatch(content)
+	if m == nil {
+		return false
+	}
+
+	before, url, after := m[1], m[2], m[3]
+	processedURL, err := result.ProcessURL(HTMLTagMetaRefresh, url)
+	if err == nil && processedURL != url {
+		token.Attr[contentAttrIndex].Val = before + processedURL + after
+		return rewriteTokenAttr(token, result)
+	}
+
+	return false
+}
+
+// parseBodyHTMLTagSVG handles inline <svg /> elements such as <use xlink:href="" />
+func parseBodyHTMLTagSVG(token *html.Token, result *Downloaded) bool {
+	needRewrite := false
+
+	for i, attr := range token.Attr {
+		if attr.Key != htmlAttrHref && attr.Key != svgAttrXlinkHref {
+			continue
+		}
+		if strings.HasPrefix(attr.Val, "#") {
+			// keep references to elements of the same document
+			continue
+		}
+
+		processedURL, err := result.ProcessURL(SVGHref, attr.Val)
+		if err == nil && processedURL != attr.Val {
+			token.Attr[i].Val = processedURL
+			needRewrite = true
+		}
+	}
+
+	if needRewrite {
+		return rewriteTokenAttr(token, result)
+	}
+
+	return false
+}
+
+func parseBodyHTMLTagAttr(context urlContext, key string, token *html.Token, result *Downloaded) bool {
+	for i, attr := range token.Attr {
+		if attr.Key == key {
+			processedURL, err := result.ProcessURL(context, attr.Val)
+			if err == nil && processedURL != attr.Val {
+				token.Attr[i].Val = processedURL
+				return rewriteTokenAttr(token, result)
+			}
+		}
+	}
+
+	return false
+}
+
+func parseBodyHTMLTagMedia(context urlContext, token *html.Token, result *Downloaded) bool {
+	needRewrite := false
+
+	for i, attr := range token.Attr {
+		switch attr.Key {
+		case htmlAttrSrc, htmlAttrPoster:
+			processedURL, err := result.ProcessURL(context, attr.Val)
+			if err == nil && processedURL != attr.Val {
+				token.Attr[i].Val = processedURL
+				needRewrite = true
+			}
+		case htmlAttrSrcset:
+			processedSrcset := parseBodySrcset(context, attr.Val, result)
+			if processedSrcset != attr.Val {
+				token.Attr[i].Val = processedSrcset
+				needRewrite = true
+			}
+		}
+	}
+
+	if needRewrite {
+		return rewriteTokenAttr(token, result)
+	}
+
+	return false
+}
+
 func parseBodyHTMLTagScript(tokenizer *html.Tokenizer, token *html.Token, result *Downloaded) bool {
 	for i, attr := range token.Attr {
 		if attr.Key == htmlAttrSrc {
@@ -395,6 +883,30 @@ func parseBodyHTMLTagStyle(tokenizer *html.Tokenizer, result *Downloaded) bool {
 	}
 }
 
+func parseBodySrcset(context urlContext, srcset string, result *Downloaded) string {
+	candidates := ParseSrcset(srcset)
+	needRewrite := false
+
+	for i, candidate := range candidates {
+		processedURL, err := result.ProcessURL(context, candidate.URL)
+		if err == nil && processedURL != candidate.URL {
+			candidates[i].URL = processedURL
+			needRewrite = true
+		}
+	}
+
+	if !needRewrite {
+		return srcset
+	}
+
+	parts := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		parts[i] = candidate.String()
+	}
+
+	return strings.Join(parts, ", ")
+}
+
 func parseBodyJsString(js string, result *Downloaded) {
 	if strings.Index(js, "getElementsByTagName('base')") > -1 {
 		// skip inline js that deals with <base />
@@ -431,10 +943,464 @@ func rewriteTokenAttr(token *html.Token, result *Downloaded) bool {
 	return true
 }
 
-func parseBodyRaw(resp *http.Response, result *Downloaded) error {
-	body, err := ioutil.ReadAll(resp.Body)
-	result.Body = string(body)
-	return err
+// xmlParser represents the handlers of an xml document type,
+// each handler receives the stack of open elements and returns true if it has written the token
+type xmlParser struct {
+	startElement func([]xml.Name, xml.StartElement, []byte, *Downloaded) bool
+	charData     func([]xml.Name, xml.CharData, []byte, *Downloaded) bool
+}
+
+var (
+	svgXMLParser = xmlParser{
+		startElement: parseBodySVGStartElement,
+		charData:     parseBodySVGCharData,
+	}
+	feedXMLParser = xmlParser{
+		startElement: parseBodyFeedStartElement,
+		charData:     parseBodyFeedCharData,
+	}
+
+	// feedXMLRoots are the root elements of RSS, Atom and sitemap documents
+	feedXMLRoots = map[string]bool{
+		"rss":          true,
+		"RDF":          true,
+		"feed":         true,
+		"urlset":       true,
+		"sitemapindex": true,
+	}
+)
+
+func parseBodySVG(r io.Reader, result *Downloaded) (string, []Link, error) {
+	return parseBodyXML(r, result, svgXMLParser)
+}
+
+func parseBodyFeed(r io.Reader, result *Downloaded) (string, []Link, error) {
+	return parseBodyXML(r, result, feedXMLParser)
+}
+
+func parseBodyXML(r io.Reader, result *Downloaded, parser xmlParser) (string, []Link, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return string(body), nil, err
+	}
+
+	var buffer bytes.Buffer
+	defer buffer.Reset()
+	result.buffer = &buffer
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+
+	var offset int64
+	stack := make([]xml.Name, 0)
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			// keep the remaining (possibly invalid) data intact
+			buffer.Write(body[offset:])
+			break
+		}
+
+		next := decoder.InputOffset()
+		raw := body[offset:next]
+		offset = next
+
+		done := false
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name)
+			done = parser.startElement(stack, t, raw, result)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				done = parser.charData(stack, t, raw, result)
+			}
+		case xml.ProcInst:
+			if t.Target == "xml-stylesheet" {
+				done = parseBodyXMLStylesheet(t, result)
+			}
+		}
+
+		if !done {
+			buffer.Write(raw)
+		}
+	}
+
+	result.buffer = nil
+
+	return buffer.String(), nil, nil
+}
+
+func parseBodySVGStartElement(_ []xml.Name, element xml.StartElement, raw []byte, result *Downloaded) bool {
+	needRewrite := false
+
+	for i, attr := range element.Attr {
+		switch {
+		case attr.Name.Local == htmlAttrHref && (attr.Name.Space == "" || attr.Name.Space == "xlink"):
+			if strings.HasPrefix(attr.Value, "#") {
+				continue
+			}
+
+			context := SVGHref
+			if element.Name.Local == "a" {
+				context = SVGTagA
+			}
+
+			processedURL, err := result.ProcessURL(context, attr.Value)
+			if err == nil && processedURL != attr.Value {
+				element.Attr[i].Value = processedURL
+				needRewrite = true
+			}
+		case attr.Name.Local == "style" && attr.Name.Space == "":
+			processedStyle := parseBodyCSSInline(attr.Value, result)
+			if processedStyle != attr.Value {
+				element.Attr[i].Value = processedStyle
+				needRewrite = true
+			}
+		}
+	}
+
+	if needRewrite {
+		return rewriteXMLStartElement(element, raw, result)
+	}
+
+	return false
+}
+
+func parseBodySVGCharData(stack []xml.Name, _ xml.CharData, raw []byte, result *Downloaded) bool {
+	if stack[len(stack)-1].Local != "style" {
+		return false
+	}
+
+	const cdataStart, cdataEnd = "<![CDATA[", "]]>"
+
+	css := string(raw)
+	if strings.HasPrefix(css, cdataStart) && strings.HasSuffix(css, cdataEnd) {
+		result.buffer.WriteString(cdataStart)
+		parseBodyCSSString(css[len(cdataStart):len(css)-len(cdataEnd)], result)
+		result.buffer.WriteString(cdataEnd)
+		return true
+	}
+
+	parseBodyCSSString(css, result)
+	return true
+}
+
+func parseBodyFeedStartElement(stack []xml.Name, element xml.StartElement, raw []byte, result *Downloaded) bool {
+	if !feedXMLRoots[stack[0].Local] {
+		return false
+	}
+
+	var (
+		attrName string
+		context  urlContext
+	)
+	switch element.Name.Local {
+	case "link":
+		// Atom <link href="" />, also <atom:link /> in RSS and <xhtml:link /> in sitemaps
+		attrName = htmlAttrHref
+		context = FeedLink
+		for _, attr := range element.Attr {
+			if attr.Name.Local == htmlAttrRel && attr.Value == "enclosure" {
+				context = FeedEnclosure
+			}
+		}
+	case "enclosure", "content", "thumbnail":
+		// RSS <enclosure url="" /> and Media RSS <media:content url="" />
+		attrName = "url"
+		context = FeedEnclosure
+	default:
+		return false
+	}
+
+	for i, attr := range element.Attr {
+		if attr.Name.Local != attrName || attr.Name.Space != "" {
+			continue
+		}
+
+		processedURL, err := result.ProcessURL(context, attr.Value)
+		if err == nil && processedURL != attr.Value {
+			element.Attr[i].Value = processedURL
+			return rewriteXMLStartElement(element, raw, result)
+		}
+	}
+
+	return false
+}
+
+func parseBodyFeedCharData(stack []xml.Name, text xml.CharData, _ []byte, result *Downloaded) bool {
+	if !feedXMLRoots[stack[0].Local] {
+		return false
+	}
+
+	var context urlContext
+	switch name := stack[len(stack)-1]; {
+	case name.Local == "link" && name.Space == "":
+		// RSS <link></link>
+		context = FeedLink
+	case name.Local == "loc" && name.Space == "image":
+		context = SitemapImage
+	case name.Local == "loc":
+		context = SitemapLoc
+	default:
+		return false
+	}
+
+	value := string(text)
+	url := strings.TrimSpace(value)
+	processedURL, err := result.ProcessURL(context, url)
+	if err != nil || processedURL == url {
+		return false
+	}
+
+	start := strings.Index(value, url)
+	result.buffer.WriteString(value[:start])
+	xml.EscapeText(result.buffer, []byte(processedURL))
+	result.buffer.WriteString(value[start+len(url):])
+
+	return true
+}
+
+func rewriteXMLStartElement(element xml.StartElement, raw []byte, result *Downloaded) bool {
+	result.buffer.WriteString("<")
+	result.buffer.WriteString(xmlName(element.Name))
+	for _, attr := range element.Attr {
+		result.buffer.WriteString(" ")
+		result.buffer.WriteString(xmlName(attr.Name))
+		result.buffer.WriteString("=\"")
+		xml.EscapeText(result.buffer, []byte(attr.Value))
+		result.buffer.WriteString("\"")
+	}
+
+	if bytes.HasSuffix(bytes.TrimSpace(raw), []byte("/>")) {
+		result.buffer.WriteString(" />")
+	} else {
+		result.buffer.WriteString(">")
+	}
+
+	return true
+}
+
+func parseBodyXMLStylesheet(inst xml.ProcInst, result *Downloaded) bool {
+	m := xmlStylesheetRegexp.FindSubmatchIndex(inst.Inst)
+	if m == nil {
+		return false
+	}
+
+	url := string(inst.Inst[m[4]:m[5]])
+	processedURL, err := result.ProcessURL(XMLStylesheet, url)
+	if err != nil || processedURL == url {
+		return false
+	}
+
+	result.buffer.WriteString("<?")
+	result.buffer.WriteString(inst.Target)
+	result.buffer.WriteString(" ")
+	result.buffer.Write(inst.Inst[:m[4]])
+	result.buffer.WriteString(processedURL)
+	result.buffer.Write(inst.Inst[m[5]:])
+	result.buffer.WriteString("?>")
+
+	return true
+}
+
+// parseBodyCSSInline returns the rewritten css instead of writing it to the buffer
+func parseBodyCSSInline(css string, result *Downloaded) string {
+	buffer := result.buffer
+	defer func() {
+		result.buffer = buffer
+	}()
+
+	var inline bytes.Buffer
+	result.buffer = &inline
+	parseBodyCSSString(css, result)
+
+	return inline.String()
+}
+
+func xmlName(name xml.Name) string {
+	if len(name.Space) > 0 {
+		return name.Space + ":" + name.Local
+	}
+
+	return name.Local
+}
+
+type jsonFrame struct {
+	array bool
+	count int
+	key   string
+	isKey bool
+}
+
+// parseBodyJSON rewrites string values selected by .Input.JSONPaths,
+// or string values that look like same host urls if no paths are configured.
+// The body is kept verbatim if nothing has been rewritten or if it is not valid JSON.
+func parseBodyJSON(r io.Reader, result *Downloaded) (string, []Link, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return string(body), nil, err
+	}
+
+	var buffer bytes.Buffer
+	defer buffer.Reset()
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	stack := make([]*jsonFrame, 0)
+	rewritten := false
+
+	beginValue := func() {
+		if len(stack) == 0 {
+			if buffer.Len() > 0 {
+				buffer.WriteString("\n")
+			}
+			return
+		}
+
+		if top := stack[len(stack)-1]; top.array {
+			if top.count > 0 {
+				buffer.WriteString(",")
+			}
+			top.count++
+		}
+	}
+	endValue := func() {
+		if len(stack) > 0 {
+			if top := stack[len(stack)-1]; !top.array {
+				top.isKey = true
+			}
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return string(body), nil, nil
+		}
+
+		switch t := token.(type) {
+		case json.Delim:
+			switch t {
+			case '{', '[':
+				beginValue()
+				stack = append(stack, &jsonFrame{array: t == '[', isKey: t == '{'})
+			case '}', ']':
+				stack = stack[:len(stack)-1]
+			}
+			buffer.WriteString(t.String())
+			if t == '}' || t == ']' {
+				endValue()
+			}
+		case string:
+			if len(stack) > 0 {
+				if top := stack[len(stack)-1]; top.isKey {
+					if top.count > 0 {
+						buffer.WriteString(",")
+					}
+					top.count++
+					top.key = t
+					top.isKey = false
+
+					writeJSONValue(&buffer, t)
+					buffer.WriteString(":")
+					continue
+				}
+			}
+
+			beginValue()
+			value := t
+			if shouldProcessJSONValue(stack, t, result) {
+				if processed, ok := processJSONValue(t, result); ok {
+					value = processed
+					rewritten = true
+				}
+			}
+			writeJSONValue(&buffer, value)
+			endValue()
+		default:
+			beginValue()
+			writeJSONValue(&buffer, t)
+			endValue()
+		}
+	}
+
+	if !rewritten {
+		return string(body), nil, nil
+	}
+
+	return buffer.String(), nil, nil
+}
+
+func shouldProcessJSONValue(stack []*jsonFrame, value string, result *Downloaded) bool {
+	if len(value) == 0 {
+		return false
+	}
+
+	paths := result.Input.JSONPaths
+	if len(paths) == 0 {
+		// heuristic: absolute url with the same host
+		if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+			return false
+		}
+		url, err := neturl.Parse(value)
+		return err == nil && strings.EqualFold(url.Host, result.Input.URL.Host)
+	}
+
+	location := make([]interface{}, len(stack))
+	for i, frame := range stack {
+		if frame.array {
+			location[i] = frame.count - 1
+		} else {
+			location[i] = frame.key
+		}
+	}
+
+	for _, path := range paths {
+		if path.Match(location) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// processJSONValue records the url and returns the rewritten value,
+// only absolute urls are rewritten as JSON values are not resolved relative to the document
+func processJSONValue(value string, result *Downloaded) (string, bool) {
+	fullURL, err := result.resolveURL(JSONValue, value)
+	if err != nil || fullURL == nil {
+		return value, false
+	}
+
+	parsedURL, _ := neturl.Parse(value)
+	if !parsedURL.IsAbs() {
+		return value, false
+	}
+
+	processed := fullURL.String()
+	return processed, processed != value
+}
+
+func writeJSONValue(buffer *bytes.Buffer, value interface{}) {
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+
+	// json.Encoder terminates each value with a newline
+	buffer.Truncate(buffer.Len() - 1)
+}
+
+func parseBodyRaw(r io.Reader, _ *Downloaded) (string, []Link, error) {
+	body, err := ioutil.ReadAll(r)
+	return string(body), nil, err
 }
 
 func parseRedirect(resp *http.Response, result *Downloaded) error {
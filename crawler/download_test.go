@@ -2,6 +2,7 @@ package crawler_test
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	neturl "net/url"
 	"time"
@@ -72,6 +73,27 @@ var _ = Describe("Download", func() {
 		Expect(downloaded.Body).To(Equal(headerValue))
 	})
 
+	It("should send post request", func() {
+		url := "http://domain.com/request/post"
+		httpmock.RegisterResponder("POST", url, func(req *http.Request) (*http.Response, error) {
+			body, _ := ioutil.ReadAll(req.Body)
+			resp := httpmock.NewStringResponse(200, req.Header.Get("Content-Type")+" "+string(body))
+			return resp, nil
+		})
+		parsedURL, _ := neturl.Parse(url)
+
+		downloaded := Download(&Input{
+			Client: http.DefaultClient,
+			Post: &PostData{
+				ContentType: "application/x-www-form-urlencoded",
+				Body:        []byte("q=a"),
+			},
+			URL: parsedURL,
+		})
+
+		Expect(downloaded.Body).To(Equal("application/x-www-form-urlencoded q=a"))
+	})
+
 	It("should not work with relative url", func() {
 		url := "relative/url/"
 		downloaded := downloadWithDefaultClient(url)
@@ -205,6 +227,53 @@ var _ = Describe("Download", func() {
 		})
 	})
 
+	Describe("MaxBodyBytes", func() {
+		downloadWithMaxBodyBytes := func(url string, maxBodyBytes uint64) *Downloaded {
+			parsedURL, _ := neturl.Parse(url)
+
+			return Download(&Input{
+				Client:       http.DefaultClient,
+				MaxBodyBytes: maxBodyBytes,
+				URL:          parsedURL,
+			})
+		}
+
+		It("should download body within limit", func() {
+			url := "http://domain.com/download/max/within"
+			httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, "12345"))
+
+			downloaded := downloadWithMaxBodyBytes(url, 5)
+
+			Expect(downloaded.Error).ToNot(HaveOccurred())
+			Expect(downloaded.Body).To(Equal("12345"))
+		})
+
+		It("should not download larger body", func() {
+			url := "http://domain.com/download/max/larger"
+			html := t.NewHTMLMarkup("<a href=\"/download/max/link\">link</a>")
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			downloaded := downloadWithMaxBodyBytes(url, 10)
+
+			Expect(downloaded.Error).To(Equal(ErrBodyTooLarge))
+			Expect(downloaded.Body).To(BeEmpty())
+			Expect(downloaded.LinksDiscovered).To(BeEmpty())
+		})
+
+		It("should check content length", func() {
+			url := "http://domain.com/download/max/content/length"
+			httpmock.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+				resp := httpmock.NewStringResponse(200, "")
+				resp.ContentLength = 1024
+				return resp, nil
+			})
+
+			downloaded := downloadWithMaxBodyBytes(url, 10)
+
+			Expect(downloaded.Error).To(Equal(ErrBodyTooLarge))
+		})
+	})
+
 	Describe("Header", func() {
 		Context(cacher.HeaderContentType, func() {
 			It("should pick up header value", func() {
@@ -337,6 +406,57 @@ var _ = Describe("Download", func() {
 			}
 		})
 
+		It("should pick up css @import string and url()", func() {
+			url := "http://domain.com/download/urls/css/import"
+			targetUrl0 := "http://domain.com/download/urls/css/import/string.css"
+			targetUrl1 := "http://domain.com/download/urls/css/import/url.css"
+			cssTemplate := `@import "%s" screen;@IMPORT url('%s');body{content:"http://domain.com/not/url"}`
+			css := fmt.Sprintf(cssTemplate, targetUrl0, targetUrl1)
+			httpmock.RegisterResponder("GET", url, t.NewCSSResponder(css))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(fmt.Sprintf(cssTemplate, "./import/string.css", "./import/url.css")))
+			Expect(len(downloaded.LinksAssets)).To(Equal(2))
+			Expect(downloaded.LinksAssets[targetUrl0].Context).To(Equal(CSSImport))
+			Expect(downloaded.LinksAssets[targetUrl1].Context).To(Equal(CSSImport))
+		})
+
+		It("should pick up css image-set() values", func() {
+			url := "http://domain.com/download/urls/css/image-set"
+			targetUrl0 := "http://domain.com/download/urls/css/image-set/1x.png"
+			targetUrl1 := "http://domain.com/download/urls/css/image-set/2x.png"
+			targetUrl2 := "http://domain.com/download/urls/css/image-set/3x.png"
+			cssTemplate := `div{background-image:-webkit-image-set("%s" 1x,url(%s) 2x,"%s" type("image/png") 3x)}`
+			css := fmt.Sprintf(cssTemplate, targetUrl0, targetUrl1, targetUrl2)
+			httpmock.RegisterResponder("GET", url, t.NewCSSResponder(css))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(fmt.Sprintf(cssTemplate,
+				"./image-set/1x.png", "./image-set/2x.png", "./image-set/3x.png")))
+			Expect(len(downloaded.LinksAssets)).To(Equal(3))
+			Expect(downloaded.LinksAssets[targetUrl0].Context).To(Equal(CSSImageSet))
+			Expect(downloaded.LinksAssets[targetUrl1].Context).To(Equal(CSSUri))
+			Expect(downloaded.LinksAssets[targetUrl2].Context).To(Equal(CSSImageSet))
+		})
+
+		It("should pick up css @font-face src list", func() {
+			url := "http://domain.com/download/urls/css/font-face"
+			targetUrl0 := "http://domain.com/download/urls/css/font.woff2"
+			targetUrl1 := "http://domain.com/download/urls/css/font.woff"
+			cssTemplate := `@font-face{font-family:"Font";src:local("Font"),url("%s") format("woff2"),url(%s) format("woff")}`
+			css := fmt.Sprintf(cssTemplate, targetUrl0, targetUrl1)
+			httpmock.RegisterResponder("GET", url, t.NewCSSResponder(css))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(fmt.Sprintf(cssTemplate, "./font.woff2", "./font.woff")))
+			Expect(len(downloaded.LinksAssets)).To(Equal(2))
+			Expect(downloaded.LinksAssets[targetUrl0].Context).To(Equal(CSSUri))
+			Expect(downloaded.LinksAssets[targetUrl1].Context).To(Equal(CSSUri))
+		})
+
 		It("should pick up a href", func() {
 			url := "http://domain.com/download/urls/a"
 			targetUrl := "http://domain.com/download/urls/target"
@@ -409,6 +529,78 @@ var _ = Describe("Download", func() {
 			}
 		})
 
+		It("should pick up link rel from token set", func() {
+			url := "http://domain.com/download/urls/link/rel"
+			html := t.NewHTMLMarkup(`<link rel="Stylesheet preload" href="http://domain.com/download/urls/link/style.css" />` +
+				`<link rel="shortcut icon" href="http://domain.com/favicon.ico" />` +
+				`<link rel="apple-touch-icon" href="http://domain.com/touch.png" />` +
+				`<link rel="prefetch" href="http://domain.com/download/urls/link/next.js" />` +
+				`<link rel="manifest" href="http://domain.com/manifest.json" />` +
+				`<link rel="alternate" type="application/rss+xml" href="http://domain.com/feed" />` +
+				`<link rel="canonical" href="http://domain.com/download/urls/link/canonical" />` +
+				`<link rel="dns-prefetch" href="http://other.com" />`)
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(t.NewHTMLMarkup(`<link rel="Stylesheet preload" href="./style.css" />` +
+				`<link rel="shortcut icon" href="../../../favicon.ico" />` +
+				`<link rel="apple-touch-icon" href="../../../touch.png" />` +
+				`<link rel="prefetch" href="./next.js" />` +
+				`<link rel="manifest" href="../../../manifest.json" />` +
+				`<link rel="alternate" type="application/rss+xml" href="../../../feed" />` +
+				`<link rel="canonical" href="./canonical" />` +
+				`<link rel="dns-prefetch" href="http://other.com" />`)))
+
+			Expect(len(downloaded.LinksAssets)).To(Equal(5))
+			Expect(downloaded.LinksAssets["http://domain.com/download/urls/link/style.css"].Context).To(Equal(HTMLTagLinkStylesheet))
+			Expect(downloaded.LinksAssets["http://domain.com/favicon.ico"].Context).To(Equal(HTMLTagLinkIcon))
+			Expect(downloaded.LinksAssets["http://domain.com/touch.png"].Context).To(Equal(HTMLTagLinkIcon))
+			Expect(downloaded.LinksAssets["http://domain.com/download/urls/link/next.js"].Context).To(Equal(HTMLTagLinkPreload))
+			Expect(downloaded.LinksAssets["http://domain.com/manifest.json"].Context).To(Equal(HTMLTagLinkManifest))
+
+			Expect(len(downloaded.LinksDiscovered)).To(Equal(2))
+			Expect(downloaded.LinksDiscovered["http://domain.com/feed"].Context).To(Equal(HTMLTagLinkAlternate))
+			Expect(downloaded.LinksDiscovered["http://domain.com/download/urls/link/canonical"].Context).To(Equal(HTMLTagLinkCanonical))
+		})
+
+		It("should pick up iframe src, object data and embed src", func() {
+			url := "http://domain.com/download/urls/embed"
+			targetUrl0 := "http://domain.com/download/urls/embed/frame"
+			targetUrl1 := "http://domain.com/download/urls/embed/object.svg"
+			targetUrl2 := "http://domain.com/download/urls/embed/embed.swf"
+			htmlTemplate := `<iframe src="%s"></iframe><object data="%s"></object><embed src="%s" />`
+			html := t.NewHTMLMarkup(fmt.Sprintf(htmlTemplate, targetUrl0, targetUrl1, targetUrl2))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(t.NewHTMLMarkup(fmt.Sprintf(htmlTemplate,
+				"./embed/frame", "./embed/object.svg", "./embed/embed.swf"))))
+
+			Expect(len(downloaded.LinksDiscovered)).To(Equal(1))
+			Expect(downloaded.LinksDiscovered[targetUrl0].Context).To(Equal(HTMLTagIframe))
+
+			Expect(len(downloaded.LinksAssets)).To(Equal(2))
+			Expect(downloaded.LinksAssets[targetUrl1].Context).To(Equal(HTMLTagObject))
+			Expect(downloaded.LinksAssets[targetUrl2].Context).To(Equal(HTMLTagEmbed))
+		})
+
+		It("should pick up meta refresh url", func() {
+			url := "http://domain.com/download/urls/meta/refresh"
+			targetUrl := "http://domain.com/download/urls/meta/target"
+			htmlTemplate := `<meta http-equiv="Refresh" content="5; URL='%s'" /><meta name="description" content="5; url=foo" />`
+			html := t.NewHTMLMarkup(fmt.Sprintf(htmlTemplate, targetUrl))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(t.NewHTMLMarkup(
+				`<meta http-equiv="Refresh" content="5; URL=&#39;./target&#39;" /><meta name="description" content="5; url=foo" />`)))
+			Expect(len(downloaded.LinksDiscovered)).To(Equal(1))
+			Expect(downloaded.LinksDiscovered[targetUrl].Context).To(Equal(HTMLTagMetaRefresh))
+		})
+
 		It("should pick up script src", func() {
 			url := "http://domain.com/download/urls/script"
 			targetUrl := "http://domain.com/download/urls/target"
@@ -525,6 +717,76 @@ var _ = Describe("Download", func() {
 			}
 		})
 
+		It("should pick up img srcset", func() {
+			url := "http://domain.com/download/urls/img/srcset"
+			targetUrl0 := "http://domain.com/download/urls/img/target/w_100,h_100.jpg"
+			targetUrl1 := "http://domain.com/download/urls/img/target/large.jpg"
+			html := t.NewHTMLMarkup(fmt.Sprintf(`<img srcset="%s 100w,%s 2x" />`, targetUrl0, targetUrl1))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(t.NewHTMLMarkup(
+				`<img srcset="./target/w_100,h_100.jpg 100w, ./target/large.jpg 2x" />`)))
+			Expect(len(downloaded.LinksAssets)).To(Equal(2))
+			Expect(downloaded.LinksAssets[targetUrl0].Context).To(Equal(HTMLTagImgSrcset))
+			Expect(downloaded.LinksAssets[targetUrl1].Context).To(Equal(HTMLTagImgSrcset))
+		})
+
+		It("should pick up picture source srcset", func() {
+			url := "http://domain.com/download/urls/picture"
+			targetUrl0 := "http://domain.com/download/urls/picture/target.webp"
+			targetUrl1 := "http://domain.com/download/urls/picture/target.jpg"
+			htmlTemplate := `<picture><source srcset="%s" type="image/webp"><img src="%s" /></picture>`
+			html := t.NewHTMLMarkup(fmt.Sprintf(htmlTemplate, targetUrl0, targetUrl1))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(t.NewHTMLMarkup(
+				fmt.Sprintf(htmlTemplate, "./picture/target.webp", "./picture/target.jpg"))))
+			Expect(len(downloaded.LinksAssets)).To(Equal(2))
+			Expect(downloaded.LinksAssets[targetUrl0].Context).To(Equal(HTMLTagSource))
+			Expect(downloaded.LinksAssets[targetUrl1].Context).To(Equal(HTMLTagImg))
+		})
+
+		It("should pick up video src, poster and track", func() {
+			url := "http://domain.com/download/urls/video"
+			targetUrl0 := "http://domain.com/download/urls/video/target.mp4"
+			targetUrl1 := "http://domain.com/download/urls/video/poster.jpg"
+			targetUrl2 := "http://domain.com/download/urls/video/captions.vtt"
+			htmlTemplate := `<video src="%s" poster="%s"><track kind="captions" src="%s" /></video>`
+			html := t.NewHTMLMarkup(fmt.Sprintf(htmlTemplate, targetUrl0, targetUrl1, targetUrl2))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(t.NewHTMLMarkup(fmt.Sprintf(htmlTemplate,
+				"./video/target.mp4", "./video/poster.jpg", "./video/captions.vtt"))))
+			Expect(len(downloaded.LinksAssets)).To(Equal(3))
+			Expect(downloaded.LinksAssets[targetUrl0].Context).To(Equal(HTMLTagVideo))
+			Expect(downloaded.LinksAssets[targetUrl1].Context).To(Equal(HTMLTagVideo))
+			Expect(downloaded.LinksAssets[targetUrl2].Context).To(Equal(HTMLTagTrack))
+		})
+
+		It("should pick up audio src and source src", func() {
+			url := "http://domain.com/download/urls/audio"
+			targetUrl0 := "http://domain.com/download/urls/audio/target.mp3"
+			targetUrl1 := "http://domain.com/download/urls/audio/target.ogg"
+			htmlTemplate := `<audio src="%s"><source src="%s" type="audio/ogg" /></audio>`
+			html := t.NewHTMLMarkup(fmt.Sprintf(htmlTemplate, targetUrl0, targetUrl1))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(t.NewHTMLMarkup(
+				fmt.Sprintf(htmlTemplate, "./audio/target.mp3", "./audio/target.ogg"))))
+			Expect(len(downloaded.LinksAssets)).To(Equal(2))
+			Expect(downloaded.LinksAssets[targetUrl0].Context).To(Equal(HTMLTagAudio))
+			Expect(downloaded.LinksAssets[targetUrl1].Context).To(Equal(HTMLTagSource))
+			Expect(len(downloaded.LinksDiscovered)).To(Equal(0))
+		})
+
 		It("should pick up link[rel=stylesheet] href", func() {
 			url := "http://domain.com/download/urls/link/stylesheet"
 			targetUrl := "http://domain.com/download/urls/link/target"
@@ -565,6 +827,246 @@ var _ = Describe("Download", func() {
 			}
 		})
 
+		It("should pick up svg urls", func() {
+			url := "http://domain.com/download/urls/svg/image.svg"
+			svgTemplate := `<?xml version="1.0"?>
+<?xml-stylesheet type="text/css" href="%s"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 10 10">
+<style><![CDATA[@font-face{src:url(%s)}]]></style>
+<image href="%s" width="10"/>
+<use xlink:href="#local"/>
+<rect style="fill:url('%s')"></rect>
+<a xlink:href="%s"><text>Link</text></a>
+</svg>`
+			targetUrl0 := "http://domain.com/download/urls/svg/style.css"
+			targetUrl1 := "http://domain.com/download/urls/svg/font.woff"
+			targetUrl2 := "http://domain.com/download/urls/svg/image.png"
+			targetUrl3 := "http://domain.com/download/urls/svg/pattern.svg"
+			targetUrl4 := "http://domain.com/download/urls/svg/page"
+			svg := fmt.Sprintf(svgTemplate, targetUrl0, targetUrl1, targetUrl2, targetUrl3, targetUrl4)
+			httpmock.RegisterResponder("GET", url, t.NewSVGResponder(svg))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(`<?xml version="1.0"?>
+<?xml-stylesheet type="text/css" href="./style.css"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 10 10">
+<style><![CDATA[@font-face{src:url(./font.woff)}]]></style>
+<image href="./image.png" width="10" />
+<use xlink:href="#local"/>
+<rect style="fill:url(&#39;./pattern.svg&#39;)"></rect>
+<a xlink:href="./page"><text>Link</text></a>
+</svg>`))
+
+			Expect(len(downloaded.LinksAssets)).To(Equal(4))
+			Expect(downloaded.LinksAssets[targetUrl0].Context).To(Equal(XMLStylesheet))
+			Expect(downloaded.LinksAssets[targetUrl1].Context).To(Equal(CSSUri))
+			Expect(downloaded.LinksAssets[targetUrl2].Context).To(Equal(SVGHref))
+			Expect(downloaded.LinksAssets[targetUrl3].Context).To(Equal(CSSUri))
+
+			Expect(len(downloaded.LinksDiscovered)).To(Equal(1))
+			Expect(downloaded.LinksDiscovered[targetUrl4].Context).To(Equal(SVGTagA))
+		})
+
+		It("should keep invalid svg intact", func() {
+			url := "http://domain.com/download/urls/svg/invalid.svg"
+			svg := `<svg><image href="http://domain.com/download/urls/svg/a.png"/><</svg>`
+			httpmock.RegisterResponder("GET", url, t.NewSVGResponder(svg))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(`<svg><image href="./a.png" /><</svg>`))
+		})
+
+		It("should pick up inline svg use", func() {
+			url := "http://domain.com/download/urls/svg/inline"
+			htmlTemplate := `<svg><use xlink:href="%s"></use><use href="#local"></use><image href="%s" /></svg>`
+			targetUrl0 := "http://domain.com/download/urls/svg/sprite.svg#icon"
+			targetUrl1 := "http://domain.com/download/urls/svg/image.png"
+			html := t.NewHTMLMarkup(fmt.Sprintf(htmlTemplate, targetUrl0, targetUrl1))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(t.NewHTMLMarkup(
+				fmt.Sprintf(htmlTemplate, "./sprite.svg#icon", "./image.png"))))
+			Expect(len(downloaded.LinksAssets)).To(Equal(2))
+			Expect(downloaded.LinksAssets["http://domain.com/download/urls/svg/sprite.svg"].Context).To(Equal(SVGHref))
+			Expect(downloaded.LinksAssets[targetUrl1].Context).To(Equal(SVGHref))
+		})
+
+		Describe("JSON", func() {
+			It("should pick up same host urls", func() {
+				url := "http://domain.com/download/urls/json"
+				json := `{"self":"http://domain.com/api/items?page=1","items":[{"url":"http://domain.com/items/1",` +
+					`"html":"<b>http://domain.com/items/1</b>","other":"http://other.com/x","n":1.50,"ok":true}],"next":null}`
+				httpmock.RegisterResponder("GET", url, t.NewJSONResponder(json))
+
+				downloaded := downloadWithDefaultClient(url)
+
+				Expect(downloaded.Body).To(Equal(json))
+				Expect(len(downloaded.LinksAssets)).To(Equal(0))
+				Expect(len(downloaded.LinksDiscovered)).To(Equal(2))
+				Expect(downloaded.LinksDiscovered["http://domain.com/api/items?page=1"].Context).To(Equal(JSONValue))
+				Expect(downloaded.LinksDiscovered["http://domain.com/items/1"].Context).To(Equal(JSONValue))
+			})
+
+			It("should pick up urls with paths", func() {
+				url := "http://domain.com/download/urls/json/paths"
+				json := `{"links":{"self":"/api/self","next":"http://other.com/api/next"},"items":[{"href":"a"}],"href":"/b"}`
+				httpmock.RegisterResponder("GET", url, t.NewJSONResponder(json))
+
+				path0, _ := ParseJSONPath("$.links.*")
+				path1, _ := ParseJSONPath("$.items[*].href")
+				parsedURL, _ := neturl.Parse(url)
+				downloaded := Download(&Input{
+					Client:    http.DefaultClient,
+					JSONPaths: []JSONPath{path0, path1},
+					URL:       parsedURL,
+				})
+
+				Expect(downloaded.Body).To(Equal(json))
+				Expect(len(downloaded.LinksDiscovered)).To(Equal(3))
+				Expect(downloaded.LinksDiscovered["http://domain.com/api/self"].Context).To(Equal(JSONValue))
+				Expect(downloaded.LinksDiscovered["http://other.com/api/next"].Context).To(Equal(JSONValue))
+				Expect(downloaded.LinksDiscovered["http://domain.com/download/urls/json/a"].Context).To(Equal(JSONValue))
+			})
+
+			It("should rewrite urls", func() {
+				url := "http://domain.com/download/urls/json/rewrite"
+				json := `[{"url": "http://domain.com/items/1", "title": "One"}, "/items/2"]`
+				httpmock.RegisterResponder("GET", url, t.NewJSONResponder(json))
+
+				parsedURL, _ := neturl.Parse(url)
+				rewriter := func(u *neturl.URL) {
+					if u.Host == "domain.com" {
+						u.Host = "mirror.com"
+					}
+				}
+				downloaded := Download(&Input{
+					Client:   http.DefaultClient,
+					Rewriter: &rewriter,
+					URL:      parsedURL,
+				})
+
+				Expect(downloaded.Body).To(Equal(`[{"url":"http://mirror.com/items/1","title":"One"},"/items/2"]`))
+				Expect(len(downloaded.LinksDiscovered)).To(Equal(1))
+				Expect(downloaded.LinksDiscovered["http://mirror.com/items/1"].Context).To(Equal(JSONValue))
+			})
+
+			It("should keep invalid json intact", func() {
+				url := "http://domain.com/download/urls/json/invalid"
+				json := `{"url":"http://domain.com/items/1",`
+				httpmock.RegisterResponder("GET", url, t.NewJSONResponder(json))
+
+				downloaded := downloadWithDefaultClient(url)
+
+				Expect(downloaded.Error).ToNot(HaveOccurred())
+				Expect(downloaded.Body).To(Equal(json))
+			})
+		})
+
+		Describe("Feed", func() {
+			It("should pick up rss links", func() {
+				url := "http://domain.com/download/urls/feed/rss"
+				rssTemplate := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/">
+<channel>
+<link>%s</link>
+<atom:link href="%s" rel="self" type="application/rss+xml"/>
+<item><title>A &amp; B</title><link><![CDATA[%s]]></link><enclosure url="%s" type="audio/mpeg"/>` +
+					`<media:thumbnail url="%s"/><guid>http://domain.com/guid</guid></item>
+</channel>
+</rss>`
+				targetUrl0 := "http://domain.com/download/urls/feed/"
+				targetUrl1 := "http://domain.com/download/urls/feed/rss"
+				targetUrl2 := "http://domain.com/download/urls/feed/item?a=1&b=2"
+				targetUrl3 := "http://domain.com/download/urls/feed/item.mp3"
+				targetUrl4 := "http://domain.com/download/urls/feed/item.jpg"
+				rss := fmt.Sprintf(rssTemplate, targetUrl0, "./rss", targetUrl2, targetUrl3, targetUrl4)
+				httpmock.RegisterResponder("GET", url, t.NewXMLResponder("application/rss+xml; charset=utf-8", rss))
+
+				downloaded := downloadWithDefaultClient(url)
+
+				Expect(downloaded.Body).To(Equal(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/">
+<channel>
+<link>./</link>
+<atom:link href="./rss" rel="self" type="application/rss+xml"/>
+<item><title>A &amp; B</title><link>./item?a=1&amp;b=2</link><enclosure url="./item.mp3" type="audio/mpeg" />` +
+					`<media:thumbnail url="./item.jpg" /><guid>http://domain.com/guid</guid></item>
+</channel>
+</rss>`))
+
+				Expect(len(downloaded.LinksDiscovered)).To(Equal(2))
+				Expect(downloaded.LinksDiscovered[targetUrl0].Context).To(Equal(FeedLink))
+				Expect(downloaded.LinksDiscovered[targetUrl2].Context).To(Equal(FeedLink))
+
+				Expect(len(downloaded.LinksAssets)).To(Equal(2))
+				Expect(downloaded.LinksAssets[targetUrl3].Context).To(Equal(FeedEnclosure))
+				Expect(downloaded.LinksAssets[targetUrl4].Context).To(Equal(FeedEnclosure))
+				_, selfFound := downloaded.LinksDiscovered[targetUrl1]
+				Expect(selfFound).To(BeFalse())
+			})
+
+			It("should pick up atom links", func() {
+				url := "http://domain.com/download/urls/feed/atom"
+				atomTemplate := `<feed xmlns="http://www.w3.org/2005/Atom"><link href="%s"/>` +
+					`<entry><link rel="alternate" href="%s"/><link rel="enclosure" href="%s"/></entry></feed>`
+				targetUrl0 := "http://domain.com/"
+				targetUrl1 := "http://domain.com/download/urls/feed/entry"
+				targetUrl2 := "http://domain.com/download/urls/feed/entry.mp4"
+				atom := fmt.Sprintf(atomTemplate, targetUrl0, targetUrl1, targetUrl2)
+				httpmock.RegisterResponder("GET", url, t.NewXMLResponder("application/atom+xml", atom))
+
+				downloaded := downloadWithDefaultClient(url)
+
+				Expect(downloaded.Body).To(Equal(`<feed xmlns="http://www.w3.org/2005/Atom"><link href="../../../" />` +
+					`<entry><link rel="alternate" href="./entry" /><link rel="enclosure" href="./entry.mp4" /></entry></feed>`))
+				Expect(len(downloaded.LinksDiscovered)).To(Equal(2))
+				Expect(downloaded.LinksDiscovered[targetUrl0].Context).To(Equal(FeedLink))
+				Expect(downloaded.LinksDiscovered[targetUrl1].Context).To(Equal(FeedLink))
+				Expect(len(downloaded.LinksAssets)).To(Equal(1))
+				Expect(downloaded.LinksAssets[targetUrl2].Context).To(Equal(FeedEnclosure))
+			})
+
+			It("should pick up sitemap locations", func() {
+				url := "http://domain.com/sitemap.xml"
+				sitemapTemplate := `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" ` +
+					`xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">
+  <url>
+    <loc>
+      %s
+    </loc>
+    <image:image><image:loc>%s</image:loc></image:image>
+  </url>
+</urlset>`
+				targetUrl0 := "http://domain.com/page"
+				targetUrl1 := "http://domain.com/image.png"
+				sitemap := fmt.Sprintf(sitemapTemplate, targetUrl0, targetUrl1)
+				httpmock.RegisterResponder("GET", url, t.NewXMLResponder("text/xml", sitemap))
+
+				downloaded := downloadWithDefaultClient(url)
+
+				Expect(downloaded.Body).To(Equal(fmt.Sprintf(sitemapTemplate, "./page", "./image.png")))
+				Expect(len(downloaded.LinksDiscovered)).To(Equal(1))
+				Expect(downloaded.LinksDiscovered[targetUrl0].Context).To(Equal(SitemapLoc))
+				Expect(len(downloaded.LinksAssets)).To(Equal(1))
+				Expect(downloaded.LinksAssets[targetUrl1].Context).To(Equal(SitemapImage))
+			})
+
+			It("should not pick up other xml documents", func() {
+				url := "http://domain.com/download/urls/feed/other.xml"
+				xml := `<doc><link>http://domain.com/page</link><loc>http://domain.com/page</loc></doc>`
+				httpmock.RegisterResponder("GET", url, t.NewXMLResponder("application/xml", xml))
+
+				downloaded := downloadWithDefaultClient(url)
+
+				Expect(downloaded.Body).To(Equal(xml))
+				Expect(len(downloaded.LinksDiscovered)).To(Equal(0))
+			})
+		})
+
 		It("should pick up 3xx response Location header", func() {
 			url := "http://domain.com/download/urls/3xx"
 			targetUrl := "http://domain.com/download/target/url"
@@ -712,6 +1214,111 @@ var _ = Describe("Download", func() {
 		})
 	})
 
+	Describe("ContentType", func() {
+		newResponder := func(contentType string, body string) httpmock.Responder {
+			return func(req *http.Request) (*http.Response, error) {
+				resp := httpmock.NewStringResponse(http.StatusOK, body)
+				if len(contentType) > 0 {
+					resp.Header.Add(cacher.HeaderContentType, contentType)
+				}
+				return resp, nil
+			}
+		}
+
+		It("should sniff missing content type", func() {
+			url := "http://domain.com/download/content/type/missing"
+			html := t.NewHTMLMarkup(`<a href="http://domain.com/download/content/target">Link</a>`)
+			httpmock.RegisterResponder("GET", url, newResponder("", html))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.GetHeaderValues(cacher.HeaderContentType)).To(Equal([]string{"text/html"}))
+			Expect(downloaded.Body).To(Equal(t.NewHTMLMarkup(`<a href="../target">Link</a>`)))
+			Expect(len(downloaded.LinksDiscovered)).To(Equal(1))
+		})
+
+		It("should sniff octet-stream", func() {
+			url := "http://domain.com/download/content/type/octet-stream.json"
+			json := `{"url":"http://domain.com/download/content/target"}`
+			httpmock.RegisterResponder("GET", url, newResponder("application/octet-stream", json))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.GetHeaderValues(cacher.HeaderContentType)).To(Equal([]string{"application/json"}))
+			Expect(len(downloaded.LinksDiscovered)).To(Equal(1))
+		})
+
+		It("should keep binary content type", func() {
+			url := "http://domain.com/download/content/type/binary"
+			body := "\x00\x01\x02"
+			httpmock.RegisterResponder("GET", url, newResponder("application/octet-stream", body))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.GetHeaderValues(cacher.HeaderContentType)).To(Equal([]string{"application/octet-stream"}))
+			Expect(downloaded.Body).To(Equal(body))
+		})
+
+		It("should parse xhtml", func() {
+			url := "http://domain.com/download/content/type/xhtml"
+			html := t.NewHTMLMarkup(`<a href="http://domain.com/download/content/target">Link</a>`)
+			httpmock.RegisterResponder("GET", url, newResponder("application/xhtml+xml", html))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.Body).To(Equal(t.NewHTMLMarkup(`<a href="../target">Link</a>`)))
+			Expect(len(downloaded.LinksDiscovered)).To(Equal(1))
+		})
+
+		It("should transcode header charset", func() {
+			url := "http://domain.com/download/content/type/charset/header"
+			httpmock.RegisterResponder("GET", url, newResponder("text/html; charset=ISO-8859-1", "<p>caf\xe9</p>"))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.GetHeaderValues(cacher.HeaderContentType)).To(Equal([]string{"text/html; charset=utf-8"}))
+			Expect(downloaded.Body).To(Equal("<p>café</p>"))
+		})
+
+		It("should transcode meta charset", func() {
+			url := "http://domain.com/download/content/type/charset/meta"
+			httpmock.RegisterResponder("GET", url, newResponder("text/html",
+				`<html><head><meta http-equiv="Content-Type" content="text/html; charset=windows-1252"></head>`+
+					"<body>\x93quoted\x94</body></html>"))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.GetHeaderValues(cacher.HeaderContentType)).To(Equal([]string{"text/html; charset=utf-8"}))
+			Expect(downloaded.Body).To(ContainSubstring("<body>\u201cquoted\u201d</body>"))
+		})
+
+		It("should not transcode unlabelled body", func() {
+			url := "http://domain.com/download/content/type/charset/none"
+			httpmock.RegisterResponder("GET", url, newResponder("text/css", "body{content:'é'}"))
+
+			downloaded := downloadWithDefaultClient(url)
+
+			Expect(downloaded.GetHeaderValues(cacher.HeaderContentType)).To(Equal([]string{"text/css"}))
+			Expect(downloaded.Body).To(Equal("body{content:'é'}"))
+		})
+
+		It("should keep charset", func() {
+			url := "http://domain.com/download/content/type/charset/keep"
+			html := "<p><a href=\"http://domain.com/download/content/target\">caf\xe9</a></p>"
+			httpmock.RegisterResponder("GET", url, newResponder("text/html; charset=iso-8859-1", html))
+			parsedURL, _ := neturl.Parse(url)
+
+			downloaded := Download(&Input{
+				Client:      http.DefaultClient,
+				KeepCharset: true,
+				URL:         parsedURL,
+			})
+
+			Expect(downloaded.GetHeaderValues(cacher.HeaderContentType)).To(Equal([]string{"text/html; charset=iso-8859-1"}))
+			Expect(downloaded.Body).To(Equal("<p><a href=\"../../target\">caf\xe9</a></p>"))
+		})
+	})
+
 	Describe("StatusCode", func() {
 		It("should match response status code", func() {
 			url := "http://domain.com/download/status/code"
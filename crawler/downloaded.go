@@ -25,6 +25,24 @@ func (d *Downloaded) AddHeader(key string, value string) {
 	d.header.Add(key, value)
 }
 
+// SetHeader replaces the values of a header
+func (d *Downloaded) SetHeader(key string, value string) {
+	if d.header == nil {
+		d.header = make(http.Header)
+	}
+
+	d.header.Set(key, value)
+}
+
+// DelHeader removes a header
+func (d *Downloaded) DelHeader(key string) {
+	if d.header == nil {
+		return
+	}
+
+	d.header.Del(key)
+}
+
 // GetHeaderKeys returns all header keys
 func (d *Downloaded) GetHeaderKeys() []string {
 	if d.header == nil {
@@ -56,21 +74,33 @@ func (d *Downloaded) GetHeaderValues(key string) []string {
 
 // ProcessURL validates url and returns rewritten string representation
 func (d *Downloaded) ProcessURL(context urlContext, url string) (string, error) {
+	fullURL, err := d.resolveURL(context, url)
+	if err != nil || fullURL == nil {
+		return url, err
+	}
+
+	reduced := d.Reduce(fullURL)
+	return reduced, nil
+}
+
+// resolveURL records the link and returns its full url after rewrite,
+// it returns nil for non http/https url
+func (d *Downloaded) resolveURL(context urlContext, url string) (*neturl.URL, error) {
 	if len(url) == 0 {
-		return url, errorEmptyURL
+		return nil, errorEmptyURL
 	}
 
 	if d.Input == nil {
-		return url, errorEmptyInput
+		return nil, errorEmptyInput
 	}
 
 	if d.Input.URL == nil {
-		return url, errorEmptyInputURL
+		return nil, errorEmptyInputURL
 	}
 
 	parsedURL, err := neturl.Parse(url)
 	if err != nil {
-		return url, err
+		return nil, err
 	}
 
 	if d.Input.Rewriter != nil {
@@ -79,33 +109,43 @@ func (d *Downloaded) ProcessURL(context urlContext, url string) (string, error)
 
 	fullURL := d.BaseURL.ResolveReference(parsedURL)
 	if !strings.HasPrefix(fullURL.Scheme, cacher.SchemeDefault) {
-		return url, nil
+		return nil, nil
 	}
+	d.Input.CanonicalRules.Canonicalize(fullURL)
 
 	filteredURL, _ := neturl.Parse(fullURL.String())
 	filteredURL.Fragment = ""
 	if filteredURL.String() != d.Input.URL.String() {
-		link := Link{
+		d.AddLink(Link{
 			Context: context,
 			URL:     filteredURL,
-		}
-
-		mapKey := filteredURL.String()
-
-		switch context {
-		case HTMLTagA:
-			d.LinksDiscovered[mapKey] = link
-		case HTMLTagForm:
-			d.LinksDiscovered[mapKey] = link
-		case HTTP3xxLocation:
-			d.LinksDiscovered[mapKey] = link
-		default:
-			d.LinksAssets[mapKey] = link
-		}
+		})
 	}
 
-	reduced := d.Reduce(fullURL)
-	return reduced, nil
+	return fullURL, nil
+}
+
+// AddLink records a link as an asset or a discovered link depending on its context
+func (d *Downloaded) AddLink(link Link) {
+	mapKey := link.URL.String()
+
+	switch link.Context {
+	case HTMLTagA,
+		HTMLTagForm,
+		HTMLTagFormQuery,
+		HTMLTagLinkAlternate,
+		HTMLTagLinkCanonical,
+		HTMLTagIframe,
+		HTMLTagMetaRefresh,
+		SVGTagA,
+		JSONValue,
+		FeedLink,
+		SitemapLoc,
+		HTTP3xxLocation:
+		d.LinksDiscovered[mapKey] = link
+	default:
+		d.LinksAssets[mapKey] = link
+	}
 }
 
 // Reduce returns relative version of url from .Input.URL
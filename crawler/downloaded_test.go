@@ -72,6 +72,21 @@ var _ = Describe("Downloaded", func() {
 			}))
 		})
 
+		It("should delete header", func() {
+			headerKey2 := headerKey + "2"
+			downloaded.AddHeader(headerKey, headerVal1)
+			downloaded.AddHeader(headerKey2, headerVal2)
+			downloaded.DelHeader(headerKey)
+
+			Expect(downloaded.GetHeaderKeys()).To(Equal([]string{headerKey2}))
+		})
+
+		It("should delete without header", func() {
+			downloaded.DelHeader(headerKey)
+
+			Expect(downloaded.GetHeaderKeys()).To(BeNil())
+		})
+
 		It("should return no keys", func() {
 			Expect(downloaded.GetHeaderKeys()).To(BeNil())
 		})
@@ -127,6 +142,25 @@ var _ = Describe("Downloaded", func() {
 			Expect(urls[0].String()).To(Equal(url))
 		})
 
+		It("should canonicalize url", func() {
+			url := "http://DOMAIN.com:80/ProcessURL/canonical?b=2&utm_source=x&a=1"
+			canonicalURL := "http://domain.com/ProcessURL/canonical?a=1&b=2"
+			downloaded.Input.CanonicalRules = &cacher.CanonicalRules{
+				LowercaseHost:    true,
+				StripDefaultPort: true,
+				SortQuery:        true,
+				DropQueryKeys:    []string{"utm_*"},
+			}
+			processedURL, err := downloaded.ProcessURL(HTMLTagA, url)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(processedURL).To(Equal("./canonical?a=1&b=2"))
+
+			urls := downloaded.GetDiscoveredURLs()
+			Expect(len(urls)).To(Equal(1))
+			Expect(urls[0].String()).To(Equal(canonicalURL))
+		})
+
 		It("should not process empty url", func() {
 			_, err := downloaded.ProcessURL(HTMLTagA, "")
 
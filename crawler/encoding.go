@@ -0,0 +1,113 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// AcceptEncoding is the Accept-Encoding header of upstream requests,
+	// it replaces any custom value as the crawler decodes the body itself
+	AcceptEncoding = "gzip, br, zstd"
+)
+
+type decoder struct {
+	io.Reader
+	closers []func()
+}
+
+// NewDecoder returns a reader of the body decoded as in the Content-Encoding header,
+// which lists the encodings in the order they were applied
+func NewDecoder(contentEncoding string, r io.Reader) (io.ReadCloser, error) {
+	d := &decoder{Reader: r}
+
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+
+		switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			var gzipReader *gzip.Reader
+			if gzipReader, err = gzip.NewReader(d.Reader); err == nil {
+				d.Reader = gzipReader
+				d.closers = append(d.closers, func() { gzipReader.Close() })
+			}
+		case "deflate":
+			var zlibReader io.ReadCloser
+			if zlibReader, err = zlib.NewReader(d.Reader); err == nil {
+				d.Reader = zlibReader
+				d.closers = append(d.closers, func() { zlibReader.Close() })
+			}
+		case "br":
+			d.Reader = brotli.NewReader(d.Reader)
+		case "zstd":
+			var zstdDecoder *zstd.Decoder
+			// the decoder runs goroutines until it is closed
+			if zstdDecoder, err = zstd.NewReader(d.Reader); err == nil {
+				d.Reader = zstdDecoder
+				d.closers = append(d.closers, zstdDecoder.Close)
+			}
+		default:
+			err = fmt.Errorf("unsupported content encoding %q", encodings[i])
+		}
+
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// DecodeBody returns the body decoded as in the Content-Encoding header
+func DecodeBody(contentEncoding string, body string) (string, error) {
+	d, err := NewDecoder(contentEncoding, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer d.Close()
+
+	decoded, err := ioutil.ReadAll(d)
+	if err != nil {
+		return "", err
+	}
+
+	return string(decoded), nil
+}
+
+func (d *decoder) Close() error {
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		d.closers[i]()
+	}
+	d.closers = nil
+
+	return nil
+}
+
+// readEncodedBody returns the encoded and decoded bytes of the body,
+// limit wraps the decoder so a highly compressed body is not fully inflated
+func readEncodedBody(contentEncoding string, r io.Reader, limit func(io.Reader) io.Reader) ([]byte, []byte, error) {
+	encoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d, err := NewDecoder(contentEncoding, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer d.Close()
+
+	decoded, err := ioutil.ReadAll(limit(d))
+	return encoded, decoded, err
+}
@@ -0,0 +1,220 @@
+package crawler_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"github.com/alphagov/spotlight-gel/cacher"
+	. "github.com/alphagov/spotlight-gel/crawler"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+var _ = Describe("Encoding", func() {
+	encode := func(contentEncoding string, body string) string {
+		buffer := new(bytes.Buffer)
+
+		switch contentEncoding {
+		case "gzip":
+			w := gzip.NewWriter(buffer)
+			w.Write([]byte(body))
+			w.Close()
+		case "br":
+			w := brotli.NewWriter(buffer)
+			w.Write([]byte(body))
+			w.Close()
+		case "zstd":
+			w, _ := zstd.NewWriter(buffer)
+			w.Write([]byte(body))
+			w.Close()
+		}
+
+		return buffer.String()
+	}
+
+	newEncodedResponder := func(contentType string, contentEncoding string, body string) httpmock.Responder {
+		return func(req *http.Request) (*http.Response, error) {
+			resp := httpmock.NewStringResponse(http.StatusOK, body)
+			resp.Header.Set(cacher.HeaderContentType, contentType)
+			resp.Header.Set(cacher.HeaderContentEncoding, contentEncoding)
+			return resp, nil
+		}
+	}
+
+	download := func(url string, keepEncoding bool) *Downloaded {
+		parsedURL, _ := neturl.Parse(url)
+
+		return Download(&Input{
+			Client:       http.DefaultClient,
+			KeepEncoding: keepEncoding,
+			URL:          parsedURL,
+		})
+	}
+
+	BeforeEach(func() {
+		httpmock.Activate()
+	})
+
+	AfterEach(func() {
+		httpmock.DeactivateAndReset()
+	})
+
+	Describe("NewDecoder", func() {
+		It("should decode gzip, br and zstd", func() {
+			for _, contentEncoding := range []string{"gzip", "br", "zstd"} {
+				d, err := NewDecoder(contentEncoding, strings.NewReader(encode(contentEncoding, "body")))
+				Expect(err).ToNot(HaveOccurred())
+				decoded, err := ioutil.ReadAll(d)
+				d.Close()
+
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(decoded)).To(Equal("body"))
+			}
+		})
+
+		It("should decode in reverse order", func() {
+			decoded, err := DecodeBody("gzip, br", encode("br", encode("gzip", "body")))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded).To(Equal("body"))
+		})
+
+		It("should keep identity", func() {
+			decoded, err := DecodeBody("identity", "body")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded).To(Equal("body"))
+		})
+
+		It("should not accept unsupported encoding", func() {
+			_, err := NewDecoder("compress", strings.NewReader("body"))
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should not accept invalid gzip", func() {
+			_, err := DecodeBody("gzip", "body")
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Download", func() {
+		It("should request encodings", func() {
+			url := "http://domain.com/Encoding/accept"
+			httpmock.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+				return httpmock.NewStringResponse(http.StatusOK, req.Header.Get(cacher.HeaderAcceptEncoding)), nil
+			})
+			parsedURL, _ := neturl.Parse(url)
+			header := make(http.Header)
+			header.Set(cacher.HeaderAcceptEncoding, "gzip")
+
+			downloaded := Download(&Input{
+				Client: http.DefaultClient,
+				Header: header,
+				URL:    parsedURL,
+			})
+
+			Expect(downloaded.Body).To(Equal(AcceptEncoding))
+		})
+
+		It("should decode before parsing", func() {
+			for _, contentEncoding := range []string{"gzip", "br", "zstd"} {
+				url := "http://domain.com/Encoding/parse/" + contentEncoding
+				html := "<a href=\"http://domain.com/Encoding/target\">link</a>"
+				httpmock.RegisterResponder("GET", url, newEncodedResponder("text/html", contentEncoding, encode(contentEncoding, html)))
+
+				downloaded := download(url, true)
+
+				Expect(downloaded.Error).ToNot(HaveOccurred())
+				Expect(downloaded.Body).To(Equal("<a href=\"../target\">link</a>"))
+				Expect(downloaded.GetHeaderValues(cacher.HeaderContentEncoding)).To(BeNil())
+				Expect(len(downloaded.LinksDiscovered)).To(Equal(1))
+			}
+		})
+
+		It("should sniff decoded body", func() {
+			url := "http://domain.com/Encoding/sniff"
+			httpmock.RegisterResponder("GET", url, newEncodedResponder("", "gzip", encode("gzip", "<html><body>sniff</body></html>")))
+
+			downloaded := download(url, false)
+
+			Expect(downloaded.GetHeaderValues(cacher.HeaderContentType)).To(Equal([]string{"text/html"}))
+		})
+
+		It("should decode unparsed body", func() {
+			url := "http://domain.com/Encoding/unparsed"
+			httpmock.RegisterResponder("GET", url, newEncodedResponder("text/javascript", "br", encode("br", "alert(1)")))
+
+			downloaded := download(url, false)
+
+			Expect(downloaded.Error).ToNot(HaveOccurred())
+			Expect(downloaded.Body).To(Equal("alert(1)"))
+			Expect(downloaded.GetHeaderValues(cacher.HeaderContentEncoding)).To(BeNil())
+		})
+
+		It("should keep encoding of unparsed body", func() {
+			url := "http://domain.com/Encoding/keep"
+			encoded := encode("zstd", "alert(1)")
+			httpmock.RegisterResponder("GET", url, newEncodedResponder("text/javascript", "zstd", encoded))
+
+			downloaded := download(url, true)
+
+			Expect(downloaded.Error).ToNot(HaveOccurred())
+			Expect(downloaded.Body).To(Equal(encoded))
+			Expect(downloaded.GetHeaderValues(cacher.HeaderContentEncoding)).To(Equal([]string{"zstd"}))
+			Expect(downloaded.GetHeaderValues(cacher.HeaderVary)).To(Equal([]string{cacher.HeaderAcceptEncoding}))
+		})
+
+		It("should limit decoded body", func() {
+			url := "http://domain.com/Encoding/max"
+			encoded := encode("gzip", strings.Repeat("a", 1024))
+			httpmock.RegisterResponder("GET", url, newEncodedResponder("text/plain", "gzip", encoded))
+			parsedURL, _ := neturl.Parse(url)
+
+			downloaded := Download(&Input{
+				Client:       http.DefaultClient,
+				MaxBodyBytes: uint64(len(encoded)),
+				URL:          parsedURL,
+			})
+
+			Expect(downloaded.Error).To(Equal(ErrBodyTooLarge))
+		})
+
+		It("should limit decoded body while keeping encoding", func() {
+			url := "http://domain.com/Encoding/max-keep"
+			encoded := encode("gzip", strings.Repeat("a", 16<<20))
+			// the truncated stream only fails to decode past the limit
+			encoded = encoded[:len(encoded)-8]
+			httpmock.RegisterResponder("GET", url, newEncodedResponder("text/plain", "gzip", encoded))
+			parsedURL, _ := neturl.Parse(url)
+
+			downloaded := Download(&Input{
+				Client:       http.DefaultClient,
+				KeepEncoding: true,
+				MaxBodyBytes: 1 << 20,
+				URL:          parsedURL,
+			})
+
+			Expect(len(encoded)).To(BeNumerically("<", 1<<20))
+			Expect(downloaded.Error).To(Equal(ErrBodyTooLarge))
+		})
+
+		It("should handle unsupported encoding", func() {
+			url := "http://domain.com/Encoding/unsupported"
+			httpmock.RegisterResponder("GET", url, newEncodedResponder("text/html", "compress", "body"))
+
+			downloaded := download(url, false)
+
+			Expect(downloaded.Error).To(HaveOccurred())
+		})
+	})
+})
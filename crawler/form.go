@@ -0,0 +1,224 @@
+package crawler
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	htmlAtom "golang.org/x/net/html/atom"
+)
+
+// htmlForm collects the fields of a <form method="get"> while it is being tokenized
+type htmlForm struct {
+	action string
+	fields []*htmlFormField
+	// enumerable is true once a select, radio or checkbox has been found
+	enumerable bool
+
+	// selectField receives the options until </select>
+	selectField *htmlFormField
+	// optionText is true while the text of an <option> without value is expected
+	optionText bool
+}
+
+// htmlFormField represents the values a field may be submitted with,
+// an empty values field is never submitted
+type htmlFormField struct {
+	name   string
+	values []string
+	radio  bool
+	// optional fields may also be omitted, like unchecked checkboxes
+	optional bool
+}
+
+const htmlAttrMethod = "method"
+
+// trackHTMLFormToken records the form fields, urls are added once the form ends
+func trackHTMLFormToken(token *html.Token, result *Downloaded) {
+	if result.Input.FormLimit == 0 {
+		return
+	}
+
+	form := result.form
+	if token.DataAtom == htmlAtom.Form {
+		result.form = nil
+		if method, _ := getTokenAttr(token, htmlAttrMethod); len(method) == 0 || strings.EqualFold(method, "get") {
+			action, _ := getTokenAttr(token, htmlAttrAction)
+			result.form = &htmlForm{action: action}
+		}
+		return
+	}
+	if form == nil {
+		return
+	}
+
+	if _, disabled := getTokenAttr(token, "disabled"); disabled {
+		return
+	}
+
+	switch token.DataAtom {
+	case htmlAtom.Input:
+		form.addInput(token)
+	case htmlAtom.Select:
+		form.endOption()
+		form.selectField = nil
+		if name, _ := getTokenAttr(token, "name"); len(name) > 0 {
+			form.selectField = &htmlFormField{name: name}
+			form.fields = append(form.fields, form.selectField)
+			form.enumerable = true
+		}
+	case htmlAtom.Option:
+		form.endOption()
+		if form.selectField == nil {
+			return
+		}
+		if value, ok := getTokenAttr(token, "value"); ok {
+			form.selectField.values = append(form.selectField.values, value)
+		} else {
+			form.selectField.values = append(form.selectField.values, "")
+			form.optionText = true
+		}
+	}
+}
+
+// trackHTMLFormText records the text of an <option> without value
+func trackHTMLFormText(tokenizer *html.Tokenizer, result *Downloaded) {
+	form := result.form
+	if form == nil || !form.optionText {
+		return
+	}
+
+	values := form.selectField.values
+	values[len(values)-1] += string(tokenizer.Text())
+}
+
+// trackHTMLFormEndTag adds the urls of the form on </form>
+func trackHTMLFormEndTag(tag htmlAtom.Atom, result *Downloaded) {
+	form := result.form
+	if form == nil {
+		return
+	}
+
+	switch tag {
+	case htmlAtom.Option:
+		form.endOption()
+	case htmlAtom.Select:
+		form.endOption()
+		form.selectField = nil
+	case htmlAtom.Form:
+		form.endOption()
+		result.form = nil
+		if !form.enumerable {
+			return
+		}
+
+		for _, query := range form.expand(result.Input.FormLimit) {
+			formURL, err := url.Parse(form.action)
+			if err != nil {
+				return
+			}
+			formURL.RawQuery = query
+			formURL.Fragment = ""
+
+			result.resolveURL(HTMLTagFormQuery, formURL.String())
+		}
+	}
+}
+
+func (form *htmlForm) addInput(token *html.Token) {
+	name, _ := getTokenAttr(token, "name")
+	if len(name) == 0 {
+		return
+	}
+
+	inputType, _ := getTokenAttr(token, "type")
+	value, hasValue := getTokenAttr(token, "value")
+
+	switch strings.ToLower(inputType) {
+	case "submit", "button", "reset", "image", "file":
+		// submitted by user actions only
+	case "radio", "checkbox":
+		if !hasValue {
+			value = "on"
+		}
+		form.enumerable = true
+
+		if strings.EqualFold(inputType, "checkbox") {
+			form.fields = append(form.fields, &htmlFormField{name: name, values: []string{value}, optional: true})
+			return
+		}
+
+		for _, field := range form.fields {
+			if field.radio && field.name == name {
+				field.values = append(field.values, value)
+				return
+			}
+		}
+		form.fields = append(form.fields, &htmlFormField{name: name, values: []string{value}, radio: true})
+	default:
+		form.fields = append(form.fields, &htmlFormField{name: name, values: []string{value}})
+	}
+}
+
+// endOption collapses the whitespaces of an option text like browsers do
+func (form *htmlForm) endOption() {
+	if !form.optionText {
+		return
+	}
+	form.optionText = false
+
+	values := form.selectField.values
+	values[len(values)-1] = strings.Join(strings.Fields(values[len(values)-1]), " ")
+}
+
+// expand returns the encoded queries of the value combinations, up to limit
+func (form *htmlForm) expand(limit uint64) []string {
+	queries := []string{""}
+
+	for _, field := range form.fields {
+		values := field.values
+		if field.optional {
+			values = append([]string{""}, values...)
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		next := make([]string, 0, len(queries)*len(values))
+		for _, query := range queries {
+			for i, value := range values {
+				if field.optional && i == 0 {
+					next = append(next, query)
+					continue
+				}
+
+				pair := url.QueryEscape(field.name) + "=" + url.QueryEscape(value)
+				if len(query) > 0 {
+					pair = query + "&" + pair
+				}
+				next = append(next, pair)
+			}
+
+			if uint64(len(next)) >= limit {
+				break
+			}
+		}
+
+		queries = next
+		if uint64(len(queries)) > limit {
+			queries = queries[:limit]
+		}
+	}
+
+	return queries
+}
+
+func getTokenAttr(token *html.Token, key string) (string, bool) {
+	for _, attr := range token.Attr {
+		if len(attr.Namespace) == 0 && attr.Key == key {
+			return attr.Val, true
+		}
+	}
+
+	return "", false
+}
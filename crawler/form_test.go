@@ -0,0 +1,116 @@
+package crawler_test
+
+import (
+	"net/http"
+	neturl "net/url"
+	"sort"
+
+	"gopkg.in/jarcoal/httpmock.v1"
+
+	. "github.com/alphagov/spotlight-gel/crawler"
+	t "github.com/alphagov/spotlight-gel/testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Form", func() {
+	downloadForm := func(url string, markup string, limit uint64) []string {
+		httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(t.NewHTMLMarkup(markup)))
+		parsedURL, _ := neturl.Parse(url)
+
+		downloaded := Download(&Input{
+			Client:    http.DefaultClient,
+			FormLimit: limit,
+			URL:       parsedURL,
+		})
+
+		urls := make([]string, 0)
+		for _, link := range downloaded.LinksDiscovered {
+			if link.Context == HTMLTagFormQuery {
+				urls = append(urls, link.URL.String())
+			}
+		}
+		sort.Strings(urls)
+
+		return urls
+	}
+
+	BeforeEach(func() {
+		httpmock.Activate()
+	})
+
+	AfterEach(func() {
+		httpmock.DeactivateAndReset()
+	})
+
+	It("should expand select and radio", func() {
+		urls := downloadForm("http://domain.com/form/expand", `<form action="/search#results">`+
+			`<input type="hidden" name="section" value="news">`+
+			`<select name="year"><option value="2019">2019</option><option> Last   year </option>`+
+			`<option value="x" disabled>X</option></select>`+
+			`<input type="radio" name="sort" value="asc" checked><input type="radio" name="sort" value="desc">`+
+			`<input type="submit" name="go" value="Search">`+
+			`</form>`, 10)
+
+		Expect(urls).To(Equal([]string{
+			"http://domain.com/search?section=news&year=2019&sort=asc",
+			"http://domain.com/search?section=news&year=2019&sort=desc",
+			"http://domain.com/search?section=news&year=Last+year&sort=asc",
+			"http://domain.com/search?section=news&year=Last+year&sort=desc",
+		}))
+	})
+
+	It("should expand checkbox", func() {
+		urls := downloadForm("http://domain.com/form/checkbox", `<form action="/filter?page=2">`+
+			`<input type="checkbox" name="open"><input type="checkbox" name="type" value="pdf">`+
+			`</form>`, 10)
+
+		Expect(urls).To(Equal([]string{
+			"http://domain.com/filter",
+			"http://domain.com/filter?open=on",
+			"http://domain.com/filter?open=on&type=pdf",
+			"http://domain.com/filter?type=pdf",
+		}))
+	})
+
+	It("should use document url without action", func() {
+		urls := downloadForm("http://domain.com/form/action", `<form><select name="a"><option>1</option></select></form>`, 10)
+
+		Expect(urls).To(Equal([]string{"http://domain.com/form/action?a=1"}))
+	})
+
+	It("should stop at limit", func() {
+		urls := downloadForm("http://domain.com/form/limit", `<form action="/search">`+
+			`<select name="a"><option>1</option><option>2</option><option>3</option></select>`+
+			`<select name="b"><option>1</option><option>2</option><option>3</option></select>`+
+			`</form>`, 4)
+
+		Expect(urls).To(Equal([]string{
+			"http://domain.com/search?a=1&b=1",
+			"http://domain.com/search?a=1&b=2",
+			"http://domain.com/search?a=1&b=3",
+			"http://domain.com/search?a=2&b=1",
+		}))
+	})
+
+	It("should skip form without enumerable field", func() {
+		urls := downloadForm("http://domain.com/form/text", `<form action="/search"><input name="q"></form>`, 10)
+
+		Expect(urls).To(BeEmpty())
+	})
+
+	It("should skip post form", func() {
+		urls := downloadForm("http://domain.com/form/post", `<form method="POST" action="/search">`+
+			`<select name="a"><option>1</option></select></form>`, 10)
+
+		Expect(urls).To(BeEmpty())
+	})
+
+	It("should skip without limit", func() {
+		urls := downloadForm("http://domain.com/form/disabled", `<form action="/search">`+
+			`<select name="a"><option>1</option></select></form>`, 0)
+
+		Expect(urls).To(BeEmpty())
+	})
+})
@@ -0,0 +1,233 @@
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+	htmlAtom "golang.org/x/net/html/atom"
+)
+
+// InjectRules represents markup added to html documents before they are cached
+type InjectRules struct {
+	// Banner is a html/template snippet executed with InjectData (e.g. "Archived copy, captured at {{.CapturedAt}}")
+	Banner string
+	// BannerPosition is either InjectBodyStart (default) or InjectBodyEnd
+	BannerPosition string
+	// NoIndex adds <meta name="robots" content="noindex" /> to <head>
+	NoIndex bool
+	// Canonical is a html/template of the url of a <link rel="canonical" /> added to <head>,
+	// existing canonical links are removed
+	Canonical string
+}
+
+// InjectData represents the data available to InjectRules templates
+type InjectData struct {
+	// CapturedAt is the time the document was downloaded
+	CapturedAt time.Time
+	// OriginalURL is the url the document was downloaded from
+	OriginalURL string
+	// Host is the host of the original url
+	Host string
+	// Path is the path and query of the original url
+	Path string
+	// RootURL is the mirror root the document was crawled from, empty for on demand downloads
+	RootURL string
+}
+
+const (
+	// InjectBodyStart injects the banner after <body>
+	InjectBodyStart = "body-start"
+	// InjectBodyEnd injects the banner before </body>
+	InjectBodyEnd = "body-end"
+)
+
+var injectTemplates = struct {
+	sync.Mutex
+	m map[string]*template.Template
+}{m: make(map[string]*template.Template)}
+
+// Validate returns an error if a template cannot be parsed or the banner position is unknown
+func (r *InjectRules) Validate() error {
+	switch r.BannerPosition {
+	case "", InjectBodyStart, InjectBodyEnd:
+	default:
+		return fmt.Errorf("unknown banner position %q", r.BannerPosition)
+	}
+
+	if _, err := getInjectTemplate(r.Banner); err != nil {
+		return fmt.Errorf("invalid banner: %v", err)
+	}
+
+	if _, err := getInjectTemplate(r.Canonical); err != nil {
+		return fmt.Errorf("invalid canonical: %v", err)
+	}
+
+	return nil
+}
+
+func (r *InjectRules) hasHead() bool {
+	return r.NoIndex || len(r.Canonical) > 0
+}
+
+func (r *InjectRules) bannerAtEnd() bool {
+	return r.BannerPosition == InjectBodyEnd
+}
+
+// getInjectTemplate returns the parsed template of a text, templates are cached
+// so that documents sharing the same rules do not parse them again
+func getInjectTemplate(text string) (*template.Template, error) {
+	injectTemplates.Lock()
+	defer injectTemplates.Unlock()
+
+	if t, ok := injectTemplates.m[text]; ok {
+		return t, nil
+	}
+
+	t, err := template.New("inject").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	injectTemplates.m[text] = t
+	return t, nil
+}
+
+func newInjectData(result *Downloaded) InjectData {
+	data := InjectData{
+		CapturedAt:  time.Now(),
+		OriginalURL: result.Input.URL.String(),
+		Host:        result.Input.URL.Host,
+		Path:        result.Input.URL.RequestURI(),
+	}
+
+	if result.Input.Root != nil {
+		data.RootURL = result.Input.Root.String()
+	}
+
+	return data
+}
+
+func executeInjectTemplate(text string, result *Downloaded) (string, error) {
+	t, err := getInjectTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	if err := t.Execute(&buffer, newInjectData(result)); err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+// injectHTMLToken handles the start tags related to injected markup,
+// it returns true if the token has been written (or skipped)
+func injectHTMLToken(token *html.Token, result *Downloaded) bool {
+	rules := result.Input.InjectRules
+	if rules == nil {
+		return false
+	}
+
+	switch token.DataAtom {
+	case htmlAtom.Body:
+		if token.Type != html.StartTagToken {
+			return false
+		}
+
+		injectHTMLHead(result)
+		rewriteTokenAttr(token, result)
+		if !rules.bannerAtEnd() {
+			injectHTMLBanner(result)
+		}
+
+		return true
+	case htmlAtom.Link:
+		if len(rules.Canonical) == 0 {
+			return false
+		}
+
+		for _, attr := range token.Attr {
+			if attr.Key == htmlAttrRel {
+				if context, ok := getLinkRelContext(attr.Val); ok && context == HTMLTagLinkCanonical {
+					// the injected canonical link replaces the existing one
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// injectHTMLEndTag writes the injected markup that goes before an end tag
+func injectHTMLEndTag(tag htmlAtom.Atom, result *Downloaded) {
+	if result.Input.InjectRules == nil {
+		return
+	}
+
+	switch tag {
+	case htmlAtom.Head:
+		injectHTMLHead(result)
+	case htmlAtom.Body:
+		if result.Input.InjectRules.bannerAtEnd() {
+			injectHTMLBanner(result)
+		}
+	}
+}
+
+// injectHTMLEnd writes the end banner of documents without </body>,
+// fragments without <head> nor <body> are left alone
+func injectHTMLEnd(result *Downloaded) {
+	rules := result.Input.InjectRules
+	if rules == nil || !rules.bannerAtEnd() || !result.injectedHead {
+		return
+	}
+
+	injectHTMLBanner(result)
+}
+
+func injectHTMLHead(result *Downloaded) {
+	if result.injectedHead {
+		return
+	}
+	result.injectedHead = true
+
+	rules := result.Input.InjectRules
+	if !rules.hasHead() {
+		return
+	}
+
+	if rules.NoIndex {
+		result.buffer.WriteString(`<meta name="robots" content="noindex" />`)
+	}
+
+	if len(rules.Canonical) > 0 {
+		href, err := executeInjectTemplate(rules.Canonical, result)
+		if err == nil && len(href) > 0 {
+			result.buffer.WriteString(`<link rel="canonical" href="`)
+			result.buffer.WriteString(href)
+			result.buffer.WriteString(`" />`)
+		}
+	}
+}
+
+func injectHTMLBanner(result *Downloaded) {
+	if result.injectedBanner {
+		return
+	}
+	result.injectedBanner = true
+
+	if len(result.Input.InjectRules.Banner) == 0 {
+		return
+	}
+
+	banner, err := executeInjectTemplate(result.Input.InjectRules.Banner, result)
+	if err == nil {
+		result.buffer.WriteString(banner)
+	}
+}
@@ -0,0 +1,144 @@
+package crawler_test
+
+import (
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"time"
+
+	"gopkg.in/jarcoal/httpmock.v1"
+
+	. "github.com/alphagov/spotlight-gel/crawler"
+	t "github.com/alphagov/spotlight-gel/testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Inject", func() {
+	downloadWithInjectRules := func(url string, html string, rules *InjectRules) *Downloaded {
+		httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+		parsedURL, _ := neturl.Parse(url)
+		rootURL, _ := neturl.Parse("http://domain.com/")
+
+		return Download(&Input{
+			Client:      http.DefaultClient,
+			InjectRules: rules,
+			Root:        rootURL,
+			URL:         parsedURL,
+		})
+	}
+
+	BeforeEach(func() {
+		httpmock.Activate()
+	})
+
+	AfterEach(func() {
+		httpmock.DeactivateAndReset()
+	})
+
+	It("should inject banner after <body>", func() {
+		url := "http://domain.com/inject/banner/start?a=1&b=2"
+		html := `<html><head><title>Title</title></head><body class="page"><p>Content</p></body></html>`
+
+		downloaded := downloadWithInjectRules(url, html, &InjectRules{
+			Banner: `<div>Archived copy of {{.OriginalURL}} from {{.RootURL}} ({{.CapturedAt.Year}})</div>`,
+		})
+
+		Expect(downloaded.Body).To(Equal(`<html><head><title>Title</title></head><body class="page">` +
+			fmt.Sprintf(`<div>Archived copy of http://domain.com/inject/banner/start?a=1&amp;b=2 from http://domain.com/ (%d)</div>`,
+				time.Now().Year()) +
+			`<p>Content</p></body></html>`))
+	})
+
+	It("should inject banner before </body>", func() {
+		url := "http://domain.com/inject/banner/end"
+		html := `<html><body><p>Content</p></body></html>`
+
+		downloaded := downloadWithInjectRules(url, html, &InjectRules{
+			Banner:         `<footer>{{.Host}}{{.Path}}</footer>`,
+			BannerPosition: InjectBodyEnd,
+		})
+
+		Expect(downloaded.Body).To(Equal(`<html><body><p>Content</p><footer>domain.com/inject/banner/end</footer></body></html>`))
+	})
+
+	It("should inject banner at the end without </body>", func() {
+		url := "http://domain.com/inject/banner/end/missing"
+		html := `<html><body><p>Content</p>`
+
+		downloaded := downloadWithInjectRules(url, html, &InjectRules{
+			Banner:         `<footer>Archived</footer>`,
+			BannerPosition: InjectBodyEnd,
+		})
+
+		Expect(downloaded.Body).To(Equal(`<html><body><p>Content</p><footer>Archived</footer>`))
+	})
+
+	It("should not inject banner into fragments", func() {
+		url := "http://domain.com/inject/banner/fragment"
+		html := `<p>Content</p>`
+
+		downloaded := downloadWithInjectRules(url, html, &InjectRules{
+			Banner:         `<footer>Archived</footer>`,
+			BannerPosition: InjectBodyEnd,
+		})
+
+		Expect(downloaded.Body).To(Equal(html))
+	})
+
+	It("should inject noindex and canonical before </head>", func() {
+		url := "http://domain.com/inject/head"
+		html := `<html><head><link rel="canonical" href="http://domain.com/other"><title>Title</title></head>` +
+			`<body></body></html>`
+
+		downloaded := downloadWithInjectRules(url, html, &InjectRules{
+			NoIndex:   true,
+			Canonical: `https://www.domain.com{{.Path}}`,
+		})
+
+		Expect(downloaded.Body).To(Equal(`<html><head><title>Title</title>` +
+			`<meta name="robots" content="noindex" /><link rel="canonical" href="https://www.domain.com/inject/head" />` +
+			`</head><body></body></html>`))
+		Expect(downloaded.LinksDiscovered).To(BeEmpty())
+	})
+
+	It("should inject head before <body> without </head>", func() {
+		url := "http://domain.com/inject/head/missing"
+		html := `<title>Title</title><body>Content</body>`
+
+		downloaded := downloadWithInjectRules(url, html, &InjectRules{NoIndex: true})
+
+		Expect(downloaded.Body).To(Equal(`<title>Title</title><meta name="robots" content="noindex" /><body>Content</body>`))
+	})
+
+	It("should keep document without rules", func() {
+		url := "http://domain.com/inject/none"
+		html := t.NewHTMLMarkup(`<link rel="canonical" href="http://domain.com/inject/other"><p>Content</p>`)
+
+		downloaded := downloadWithInjectRules(url, html, nil)
+
+		Expect(downloaded.Body).To(Equal(t.NewHTMLMarkup(`<link rel="canonical" href="./other"><p>Content</p>`)))
+	})
+
+	Describe("Validate", func() {
+		It("should accept valid rules", func() {
+			rules := &InjectRules{
+				Banner:         `{{.CapturedAt.Format "2006-01-02"}}`,
+				BannerPosition: InjectBodyStart,
+				Canonical:      `{{.OriginalURL}}`,
+			}
+
+			Expect(rules.Validate()).To(Succeed())
+		})
+
+		It("should reject invalid template", func() {
+			Expect((&InjectRules{Banner: `{{.CapturedAt`}).Validate()).ToNot(Succeed())
+			Expect((&InjectRules{Canonical: `{{end}}`}).Validate()).ToNot(Succeed())
+		})
+
+		It("should reject unknown position", func() {
+			Expect((&InjectRules{BannerPosition: "head"}).Validate()).ToNot(Succeed())
+		})
+	})
+})
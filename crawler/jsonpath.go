@@ -0,0 +1,144 @@
+package crawler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONPath represents a JSONPath-like selector of values inside a JSON document.
+// Supported syntax: $ (root), .key, ['key'], [0], [*], .* and .. (recursive descent).
+type JSONPath struct {
+	value string
+	steps []jsonPathStep
+}
+
+type jsonPathStep struct {
+	key       string
+	index     int
+	isIndex   bool
+	wildcard  bool
+	recursive bool
+}
+
+// ParseJSONPath returns the JSONPath represented by a string such as '$.items[*].url' or '$..href'
+func ParseJSONPath(value string) (JSONPath, error) {
+	path := JSONPath{value: value}
+
+	if !strings.HasPrefix(value, "$") {
+		return path, fmt.Errorf("path %q must start with '$'", value)
+	}
+
+	s := value[1:]
+	for len(s) > 0 {
+		step := jsonPathStep{}
+
+		switch {
+		case strings.HasPrefix(s, ".."):
+			step.recursive = true
+			s = s[2:]
+			if strings.HasPrefix(s, "[") {
+				break
+			}
+			fallthrough
+		case s[0] == '.':
+			if !step.recursive {
+				s = s[1:]
+			}
+
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			key := s[:end]
+			s = s[end:]
+
+			if len(key) == 0 {
+				return path, fmt.Errorf("path %q has an empty key", value)
+			}
+			if key == "*" {
+				step.wildcard = true
+			} else {
+				step.key = key
+			}
+
+			path.steps = append(path.steps, step)
+			continue
+		case s[0] != '[':
+			return path, fmt.Errorf("path %q has unexpected %q", value, s)
+		}
+
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return path, fmt.Errorf("path %q has an unclosed '['", value)
+		}
+		inner := s[1:end]
+		s = s[end+1:]
+
+		switch {
+		case inner == "*":
+			step.wildcard = true
+		case len(inner) > 1 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+			step.key = inner[1 : len(inner)-1]
+		default:
+			index, err := strconv.Atoi(inner)
+			if err != nil || index < 0 {
+				return path, fmt.Errorf("path %q has invalid index %q", value, inner)
+			}
+			step.index = index
+			step.isIndex = true
+		}
+
+		path.steps = append(path.steps, step)
+	}
+
+	return path, nil
+}
+
+// Match returns true if the selector matches the location of a value,
+// the location is a list of object keys (string) and array indices (int) from the root
+func (p JSONPath) Match(location []interface{}) bool {
+	return matchJSONPathSteps(p.steps, location)
+}
+
+func (p JSONPath) String() string {
+	return p.value
+}
+
+func matchJSONPathSteps(steps []jsonPathStep, location []interface{}) bool {
+	if len(steps) == 0 {
+		return len(location) == 0
+	}
+
+	step := steps[0]
+	if step.recursive {
+		for i := range location {
+			if step.match(location[i]) && matchJSONPathSteps(steps[1:], location[i+1:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(location) == 0 || !step.match(location[0]) {
+		return false
+	}
+
+	return matchJSONPathSteps(steps[1:], location[1:])
+}
+
+func (s jsonPathStep) match(element interface{}) bool {
+	if s.wildcard {
+		return true
+	}
+
+	switch e := element.(type) {
+	case string:
+		return !s.isIndex && s.key == e
+	case int:
+		return s.isIndex && s.index == e
+	}
+
+	return false
+}
@@ -0,0 +1,56 @@
+package crawler_test
+
+import (
+	. "github.com/alphagov/spotlight-gel/crawler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSONPath", func() {
+	match := func(value string, location ...interface{}) bool {
+		path, err := ParseJSONPath(value)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(path.String()).To(Equal(value))
+
+		return path.Match(location)
+	}
+
+	It("should match root", func() {
+		Expect(match("$")).To(BeTrue())
+		Expect(match("$", "a")).To(BeFalse())
+	})
+
+	It("should match keys", func() {
+		Expect(match("$.a.b", "a", "b")).To(BeTrue())
+		Expect(match("$['a'][\"b\"]", "a", "b")).To(BeTrue())
+		Expect(match("$.a.b", "a")).To(BeFalse())
+		Expect(match("$.a.b", "a", "c")).To(BeFalse())
+	})
+
+	It("should match indices", func() {
+		Expect(match("$.items[1]", "items", 1)).To(BeTrue())
+		Expect(match("$.items[1]", "items", 0)).To(BeFalse())
+		Expect(match("$.items[1]", "items", "1")).To(BeFalse())
+	})
+
+	It("should match wildcards", func() {
+		Expect(match("$.items[*].url", "items", 3, "url")).To(BeTrue())
+		Expect(match("$.links.*", "links", "self")).To(BeTrue())
+		Expect(match("$.links.*", "links", "self", "href")).To(BeFalse())
+	})
+
+	It("should match recursive descent", func() {
+		Expect(match("$..href", "href")).To(BeTrue())
+		Expect(match("$..href", "a", 0, "b", "href")).To(BeTrue())
+		Expect(match("$..links[*]", "data", "links", 2)).To(BeTrue())
+		Expect(match("$..href", "href", "a")).To(BeFalse())
+	})
+
+	It("should handle invalid path", func() {
+		for _, value := range []string{"a.b", "$.", "$..", "$[1", "$[x]", "$[-1]", "$a"} {
+			_, err := ParseJSONPath(value)
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+})
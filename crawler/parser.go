@@ -0,0 +1,126 @@
+package crawler
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Parser processes a response body, it returns the rewritten body and the links it found.
+// Links may also be recorded with Downloaded.ProcessURL, which returns the rewritten url.
+type Parser func(body io.Reader, result *Downloaded) (string, []Link, error)
+
+// Parsers maps media types to parsers, keys are either exact media types (e.g. text/html)
+// or wildcards (e.g. image/*, application/*+json or */*)
+type Parsers map[string]Parser
+
+var defaultParsers = Parsers{
+	"text/css":              parseBodyCSS,
+	"text/html":             parseBodyHTML,
+	"application/xhtml+xml": parseBodyHTML,
+	"image/svg+xml":         parseBodySVG,
+	"application/json":      parseBodyJSON,
+	"application/*+json":    parseBodyJSON,
+	"application/rss+xml":   parseBodyFeed,
+	"application/atom+xml":  parseBodyFeed,
+	"application/xml":       parseBodyFeed,
+	"text/xml":              parseBodyFeed,
+	"*/*":                   parseBodyRaw,
+}
+
+// DefaultParsers returns a copy of the built-in parsers
+func DefaultParsers() Parsers {
+	return defaultParsers.copy()
+}
+
+// ParseMediaType returns the lowercase media type of a Content-Type header value
+func ParseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+
+	return mediaType
+}
+
+// genericMediaTypes do not tell anything about the content, which is sniffed instead
+var genericMediaTypes = map[string]bool{
+	"":                         true,
+	"application/octet-stream": true,
+	"application/unknown":      true,
+	"binary/octet-stream":      true,
+	"unknown/unknown":          true,
+}
+
+// DetectContentType works like http.DetectContentType with additional detection of svg and json
+func DetectContentType(data []byte) string {
+	contentType := http.DetectContentType(data)
+
+	mediaType := ParseMediaType(contentType)
+	if mediaType != "text/xml" && mediaType != "text/plain" {
+		return contentType
+	}
+
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n")
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<svg")),
+		mediaType == "text/xml" && bytes.Contains(trimmed, []byte("<svg")):
+		return "image/svg+xml"
+	case mediaType == "text/plain" && len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '['):
+		return "application/json"
+	}
+
+	return contentType
+}
+
+func isTextMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/xml" ||
+		mediaType == "application/json" ||
+		mediaType == "application/javascript"
+}
+
+// Find returns the parser registered for the media type,
+// falling back to the most specific matching wildcard
+func (p Parsers) Find(mediaType string) Parser {
+	if parser, ok := p[mediaType]; ok {
+		return parser
+	}
+
+	var (
+		found        Parser
+		foundPattern string
+		foundScore   = -1
+	)
+	for pattern, parser := range p {
+		if !strings.Contains(pattern, "*") {
+			continue
+		}
+		if matched, _ := path.Match(pattern, mediaType); !matched {
+			continue
+		}
+
+		score := len(pattern) - strings.Count(pattern, "*")
+		if score > foundScore || (score == foundScore && pattern < foundPattern) {
+			found = parser
+			foundPattern = pattern
+			foundScore = score
+		}
+	}
+
+	return found
+}
+
+func (p Parsers) copy() Parsers {
+	parsers := make(Parsers, len(p))
+	for mediaType, parser := range p {
+		parsers[mediaType] = parser
+	}
+
+	return parsers
+}
@@ -0,0 +1,100 @@
+package crawler_test
+
+import (
+	"io"
+	"strings"
+
+	. "github.com/alphagov/spotlight-gel/crawler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parsers", func() {
+	newParser := func(name string) Parser {
+		return func(io.Reader, *Downloaded) (string, []Link, error) {
+			return name, nil, nil
+		}
+	}
+
+	parse := func(parser Parser) string {
+		Expect(parser).ToNot(BeNil())
+		body, _, _ := parser(strings.NewReader(""), &Downloaded{})
+
+		return body
+	}
+
+	Describe("Find", func() {
+		parsers := Parsers{
+			"text/html":          newParser("html"),
+			"text/*":             newParser("text"),
+			"application/*+json": newParser("json"),
+			"*/*":                newParser("any"),
+		}
+
+		It("should find exact media type", func() {
+			Expect(parse(parsers.Find("text/html"))).To(Equal("html"))
+		})
+
+		It("should find most specific wildcard", func() {
+			Expect(parse(parsers.Find("text/plain"))).To(Equal("text"))
+			Expect(parse(parsers.Find("application/ld+json"))).To(Equal("json"))
+			Expect(parse(parsers.Find("application/json"))).To(Equal("any"))
+			Expect(parse(parsers.Find("image/png"))).To(Equal("any"))
+		})
+
+		It("should return nil", func() {
+			Expect(Parsers{"text/*": newParser("text")}.Find("image/png")).To(BeNil())
+			Expect(parsers.Find("")).To(BeNil())
+		})
+	})
+
+	Describe("DefaultParsers", func() {
+		It("should return a copy", func() {
+			parsers := DefaultParsers()
+			delete(parsers, "text/html")
+
+			Expect(DefaultParsers().Find("text/html")).ToNot(BeNil())
+		})
+
+		It("should keep unknown body intact", func() {
+			body, links, err := DefaultParsers().Find("image/png")(strings.NewReader("data"), &Downloaded{})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(Equal("data"))
+			Expect(links).To(BeEmpty())
+		})
+	})
+
+	Describe("ParseMediaType", func() {
+		It("should parse", func() {
+			Expect(ParseMediaType("Text/HTML; charset=utf-8")).To(Equal("text/html"))
+			Expect(ParseMediaType("text/html;;")).To(Equal("text/html"))
+			Expect(ParseMediaType("")).To(Equal(""))
+		})
+	})
+
+	Describe("DetectContentType", func() {
+		It("should detect html", func() {
+			Expect(ParseMediaType(DetectContentType([]byte("<!DOCTYPE html><html></html>")))).To(Equal("text/html"))
+		})
+
+		It("should detect svg", func() {
+			Expect(DetectContentType([]byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`))).To(Equal("image/svg+xml"))
+			Expect(DetectContentType([]byte(`<?xml version="1.0"?><svg></svg>`))).To(Equal("image/svg+xml"))
+		})
+
+		It("should detect json", func() {
+			Expect(DetectContentType([]byte(` {"key":"value"}`))).To(Equal("application/json"))
+			Expect(DetectContentType([]byte(`[1,2]`))).To(Equal("application/json"))
+		})
+
+		It("should not detect svg in text", func() {
+			Expect(ParseMediaType(DetectContentType([]byte("use <svg> for vectors")))).To(Equal("text/plain"))
+		})
+
+		It("should detect binary", func() {
+			Expect(DetectContentType([]byte{0x00, 0x01, 0x02})).To(Equal("application/octet-stream"))
+		})
+	})
+})
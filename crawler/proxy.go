@@ -0,0 +1,89 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+)
+
+const (
+	// ProxyDirect is the Upstream.Proxy value that connects to the hosts without any proxy
+	ProxyDirect = "direct"
+)
+
+// Proxy represents the proxy used for all hosts
+type Proxy struct {
+	// URL is the proxy url, http and https proxies are used with CONNECT for https urls,
+	// credentials in the url are sent to the proxy
+	URL *neturl.URL
+	// Bypass holds the hosts connected to directly, patterns are the same as Upstream.Host
+	Bypass []string
+}
+
+// ParseProxyURL returns the proxy url, its scheme must be http, https or socks5,
+// errors never include the value as it may hold credentials
+func ParseProxyURL(value string) (*neturl.URL, error) {
+	proxyURL, err := neturl.Parse(value)
+	if err != nil {
+		return nil, errors.New("proxy url cannot be parsed")
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("proxy scheme %q must be http, https or socks5", proxyURL.Scheme)
+	}
+
+	if len(proxyURL.Hostname()) == 0 {
+		return nil, errors.New("proxy url has no host")
+	}
+
+	return proxyURL, nil
+}
+
+func (proxy Proxy) validate() error {
+	if proxy.URL == nil {
+		if len(proxy.Bypass) > 0 {
+			return errors.New("proxy bypass cannot be used without proxy")
+		}
+		return nil
+	}
+
+	_, err := ParseProxyURL(proxy.URL.String())
+	return err
+}
+
+func (proxy Proxy) bypass(url *neturl.URL) bool {
+	for _, pattern := range proxy.Bypass {
+		if MatchHost(pattern, url) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// buildProxyFunc returns the proxy func of the upstream transport,
+// the upstream proxy has priority over the global proxy which has priority over the environment
+func buildProxyFunc(upstream Upstream, proxy Proxy) (func(*http.Request) (*neturl.URL, error), error) {
+	switch {
+	case upstream.Proxy == ProxyDirect:
+		return nil, nil
+	case len(upstream.Proxy) > 0:
+		proxyURL, err := ParseProxyURL(upstream.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		return http.ProxyURL(proxyURL), nil
+	case proxy.URL != nil:
+		return func(req *http.Request) (*neturl.URL, error) {
+			if proxy.bypass(req.URL) {
+				return nil, nil
+			}
+			return proxy.URL, nil
+		}, nil
+	}
+
+	return http.ProxyFromEnvironment, nil
+}
@@ -0,0 +1,224 @@
+package crawler
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	htmlAtom "golang.org/x/net/html/atom"
+)
+
+// SanitizeRules represents what is removed from html documents before they are cached
+type SanitizeRules struct {
+	// Scripts removes <script> elements, their urls are not downloaded
+	Scripts bool
+	// EventAttrs removes on* event handler attributes (e.g. onclick)
+	EventAttrs bool
+	// JavascriptURLs removes url attributes with a javascript: url
+	JavascriptURLs bool
+	// UnwrapNoscript replaces <noscript> elements with their content
+	UnwrapNoscript bool
+	// Iframes removes <iframe> elements, their urls are not downloaded
+	Iframes bool
+	// Meta removes <meta /> elements by name, http-equiv or property (e.g. refresh or robots)
+	Meta []string
+}
+
+const (
+	// SanitizeScripts option name of .Scripts
+	SanitizeScripts = "scripts"
+	// SanitizeEventAttrs option name of .EventAttrs
+	SanitizeEventAttrs = "event-attrs"
+	// SanitizeJavascriptURLs option name of .JavascriptURLs
+	SanitizeJavascriptURLs = "javascript-urls"
+	// SanitizeUnwrapNoscript option name of .UnwrapNoscript
+	SanitizeUnwrapNoscript = "unwrap-noscript"
+	// SanitizeIframes option name of .Iframes
+	SanitizeIframes = "iframes"
+)
+
+// sanitizeURLAttrs are the attributes which may hold a javascript: url
+var sanitizeURLAttrs = map[string]bool{
+	htmlAttrAction:   true,
+	htmlAttrData:     true,
+	htmlAttrHref:     true,
+	htmlAttrPoster:   true,
+	htmlAttrSrc:      true,
+	svgAttrXlinkHref: true,
+	"background":     true,
+	"cite":           true,
+	"formaction":     true,
+}
+
+// SetOptions enables the named options and disables the others
+func (r *SanitizeRules) SetOptions(names []string) error {
+	options := SanitizeRules{Meta: r.Meta}
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "":
+		case SanitizeScripts:
+			options.Scripts = true
+		case SanitizeEventAttrs:
+			options.EventAttrs = true
+		case SanitizeJavascriptURLs:
+			options.JavascriptURLs = true
+		case SanitizeUnwrapNoscript:
+			options.UnwrapNoscript = true
+		case SanitizeIframes:
+			options.Iframes = true
+		default:
+			return fmt.Errorf("unknown option %q", name)
+		}
+	}
+
+	*r = options
+	return nil
+}
+
+// GetOptions returns the names of the enabled options
+func (r *SanitizeRules) GetOptions() []string {
+	names := make([]string, 0)
+	for _, option := range []struct {
+		enabled bool
+		name    string
+	}{
+		{r.Scripts, SanitizeScripts},
+		{r.EventAttrs, SanitizeEventAttrs},
+		{r.JavascriptURLs, SanitizeJavascriptURLs},
+		{r.UnwrapNoscript, SanitizeUnwrapNoscript},
+		{r.Iframes, SanitizeIframes},
+	} {
+		if option.enabled {
+			names = append(names, option.name)
+		}
+	}
+
+	return names
+}
+
+// sanitizeHTMLToken applies the rules to a start tag, it returns true if the element has been consumed.
+// Attributes are removed from the token which is then written by the caller.
+func sanitizeHTMLToken(tokenizer *html.Tokenizer, token *html.Token, result *Downloaded) bool {
+	rules := result.Input.SanitizeRules
+	if rules == nil {
+		return false
+	}
+
+	switch token.DataAtom {
+	case htmlAtom.Script:
+		if rules.Scripts {
+			return sanitizeHTMLSkipElement(tokenizer, token)
+		}
+	case htmlAtom.Iframe:
+		if rules.Iframes {
+			return sanitizeHTMLSkipElement(tokenizer, token)
+		}
+	case htmlAtom.Noscript:
+		if rules.UnwrapNoscript {
+			return sanitizeHTMLUnwrapElement(tokenizer, token, result)
+		}
+	case htmlAtom.Meta:
+		if sanitizeHTMLMetaMatches(rules, token) {
+			return true
+		}
+	}
+
+	if rules.EventAttrs || rules.JavascriptURLs {
+		attrs := token.Attr[:0]
+		for _, attr := range token.Attr {
+			key := strings.ToLower(attr.Key)
+			if rules.EventAttrs && strings.HasPrefix(key, "on") {
+				continue
+			}
+			if rules.JavascriptURLs && sanitizeURLAttrs[key] && isJavascriptURL(attr.Val) {
+				continue
+			}
+
+			attrs = append(attrs, attr)
+		}
+		token.Attr = attrs
+	}
+
+	return false
+}
+
+// sanitizeHTMLSkipElement drops the tokens up to the element end tag
+func sanitizeHTMLSkipElement(tokenizer *html.Tokenizer, token *html.Token) bool {
+	if token.Type == html.SelfClosingTagToken {
+		return true
+	}
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return true
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == token.Data {
+				return true
+			}
+		}
+	}
+}
+
+// sanitizeHTMLUnwrapElement parses the content of a raw text element (e.g. <noscript>) as html
+func sanitizeHTMLUnwrapElement(tokenizer *html.Tokenizer, token *html.Token, result *Downloaded) bool {
+	if token.Type == html.SelfClosingTagToken {
+		return true
+	}
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return true
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == token.Data {
+				return true
+			}
+			result.buffer.Write(tokenizer.Raw())
+		case html.TextToken:
+			inner := html.NewTokenizer(strings.NewReader(string(tokenizer.Raw())))
+			for {
+				if parseBodyHTMLToken(inner, result) {
+					break
+				}
+			}
+		default:
+			result.buffer.Write(tokenizer.Raw())
+		}
+	}
+}
+
+func sanitizeHTMLMetaMatches(rules *SanitizeRules, token *html.Token) bool {
+	for _, attr := range token.Attr {
+		switch strings.ToLower(attr.Key) {
+		case "name", htmlAttrHTTPEquiv, "property":
+			for _, meta := range rules.Meta {
+				if strings.EqualFold(strings.TrimSpace(attr.Val), meta) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// isJavascriptURL returns true for javascript: urls, ignoring case and the characters browsers strip
+func isJavascriptURL(value string) bool {
+	var scheme strings.Builder
+	for _, r := range value {
+		if r <= ' ' {
+			continue
+		}
+		if r == ':' {
+			return strings.EqualFold(scheme.String(), "javascript")
+		}
+
+		scheme.WriteRune(r)
+		if scheme.Len() > len("javascript") {
+			return false
+		}
+	}
+
+	return false
+}
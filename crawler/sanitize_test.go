@@ -0,0 +1,136 @@
+package crawler_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"path/filepath"
+
+	"gopkg.in/jarcoal/httpmock.v1"
+
+	. "github.com/alphagov/spotlight-gel/crawler"
+	t "github.com/alphagov/spotlight-gel/testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+var _ = Describe("Sanitize", func() {
+	downloadWithSanitizeRules := func(name string, rules *SanitizeRules) *Downloaded {
+		html, err := ioutil.ReadFile(filepath.Join("testdata", "sanitize", name+".html"))
+		Expect(err).ToNot(HaveOccurred())
+
+		url := "http://domain.com/sanitize/" + name
+		httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(string(html)))
+		parsedURL, _ := neturl.Parse(url)
+
+		return Download(&Input{
+			Client:        http.DefaultClient,
+			SanitizeRules: rules,
+			URL:           parsedURL,
+		})
+	}
+
+	expectGolden := func(name string, downloaded *Downloaded) {
+		Expect(downloaded.Error).ToNot(HaveOccurred())
+
+		path := filepath.Join("testdata", "sanitize", name+".golden.html")
+		if *updateGolden {
+			Expect(ioutil.WriteFile(path, []byte(downloaded.Body), 0644)).To(Succeed())
+		}
+
+		golden, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(downloaded.Body).To(Equal(string(golden)))
+	}
+
+	BeforeEach(func() {
+		httpmock.Activate()
+	})
+
+	AfterEach(func() {
+		httpmock.DeactivateAndReset()
+	})
+
+	Describe("Golden", func() {
+		for _, c := range []struct {
+			name  string
+			rules SanitizeRules
+		}{
+			{SanitizeScripts, SanitizeRules{Scripts: true}},
+			{SanitizeEventAttrs, SanitizeRules{EventAttrs: true}},
+			{SanitizeJavascriptURLs, SanitizeRules{JavascriptURLs: true}},
+			{SanitizeUnwrapNoscript, SanitizeRules{UnwrapNoscript: true}},
+			{SanitizeIframes, SanitizeRules{Iframes: true}},
+			{"meta", SanitizeRules{Meta: []string{"refresh", "robots", "og:url"}}},
+			{"all", SanitizeRules{
+				Scripts:        true,
+				EventAttrs:     true,
+				JavascriptURLs: true,
+				UnwrapNoscript: true,
+				Iframes:        true,
+				Meta:           []string{"refresh"},
+			}},
+		} {
+			name := c.name
+			rules := c.rules
+
+			It("should sanitize "+name, func() {
+				expectGolden(name, downloadWithSanitizeRules(name, &rules))
+			})
+		}
+	})
+
+	It("should keep everything without rules", func() {
+		downloaded := downloadWithSanitizeRules("all", nil)
+
+		Expect(downloaded.Body).To(ContainSubstring(`<script src="../js/app.js">`))
+		Expect(downloaded.Body).To(ContainSubstring(`<body onload="init()">`))
+		Expect(downloaded.Body).To(ContainSubstring(`<noscript>`))
+	})
+
+	It("should not download removed urls", func() {
+		downloaded := downloadWithSanitizeRules("all", &SanitizeRules{
+			Scripts:        true,
+			UnwrapNoscript: true,
+			Iframes:        true,
+		})
+
+		_, scriptFound := downloaded.LinksAssets["http://domain.com/js/app.js"]
+		Expect(scriptFound).To(BeFalse())
+		_, iframeFound := downloaded.LinksDiscovered["http://domain.com/tracking"]
+		Expect(iframeFound).To(BeFalse())
+		Expect(downloaded.LinksDiscovered["http://domain.com/fallback"].Context).To(Equal(HTMLTagA))
+	})
+
+	It("should pick up noscript urls", func() {
+		downloaded := downloadWithSanitizeRules(SanitizeUnwrapNoscript, &SanitizeRules{UnwrapNoscript: true})
+
+		Expect(downloaded.LinksAssets["http://domain.com/css/noscript.css"].Context).To(Equal(HTMLTagLinkStylesheet))
+		Expect(downloaded.LinksAssets["http://domain.com/pixel.gif"].Context).To(Equal(HTMLTagImg))
+	})
+
+	Describe("SetOptions", func() {
+		It("should set options", func() {
+			rules := SanitizeRules{Meta: []string{"robots"}}
+			Expect(rules.SetOptions([]string{SanitizeScripts, " iframes", ""})).To(Succeed())
+
+			Expect(rules).To(Equal(SanitizeRules{
+				Scripts: true,
+				Iframes: true,
+				Meta:    []string{"robots"},
+			}))
+			Expect(rules.GetOptions()).To(Equal([]string{SanitizeScripts, SanitizeIframes}))
+		})
+
+		It("should not set unknown option", func() {
+			rules := SanitizeRules{Scripts: true}
+
+			Expect(rules.SetOptions([]string{"styles"})).ToNot(Succeed())
+			Expect(rules.Scripts).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,255 @@
+package crawler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// Upstream represents the connection settings of the hosts matching Host
+type Upstream struct {
+	// Host is a host name such as example.com, *.example.com matches its sub domains,
+	// a port restricts the match to urls with this port
+	Host string
+
+	// Username and Password are sent with basic auth
+	Username string
+	Password string
+	// BearerToken is sent in the Authorization header
+	BearerToken string
+
+	// CAFile is a PEM bundle of certificate authorities trusted in addition to the system ones
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key used for mutual TLS
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify accepts any server certificate, for staging hosts only
+	InsecureSkipVerify bool
+	// Resolve holds 'host:port:address' entries, connections to host:port are made to address:port instead
+	Resolve []string
+	// Proxy is the url of the proxy used for this host instead of the global one,
+	// ProxyDirect connects without proxy
+	Proxy string
+}
+
+// Upstreams holds the upstreams with their transport, the first matching upstream is used
+type Upstreams struct {
+	upstreams  []Upstream
+	transports []http.RoundTripper
+
+	proxy Proxy
+	// transport is used for the other hosts when there is a global proxy
+	transport http.RoundTripper
+}
+
+// NewUpstreams returns the Upstreams after loading the certificate files,
+// proxy is used for the hosts without their own proxy
+func NewUpstreams(upstreams []Upstream, proxy Proxy) (*Upstreams, error) {
+	if err := proxy.validate(); err != nil {
+		return nil, err
+	}
+
+	u := &Upstreams{
+		upstreams:  make([]Upstream, len(upstreams)),
+		transports: make([]http.RoundTripper, len(upstreams)),
+		proxy:      proxy,
+	}
+
+	for i, upstream := range upstreams {
+		if len(upstream.Host) == 0 {
+			return nil, errors.New("host cannot be empty")
+		}
+		if len(upstream.BearerToken) > 0 && (len(upstream.Username) > 0 || len(upstream.Password) > 0) {
+			return nil, fmt.Errorf("host %q: basic auth and bearer token cannot be used together", upstream.Host)
+		}
+
+		transport, err := upstream.buildTransport(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("host %q: %v", upstream.Host, err)
+		}
+
+		u.upstreams[i] = upstream
+		u.transports[i] = transport
+	}
+
+	if proxy.URL != nil {
+		u.transport, _ = Upstream{}.buildTransport(proxy)
+	}
+
+	return u, nil
+}
+
+// Match returns the first upstream matching the url host, nil if none does
+func (u *Upstreams) Match(url *neturl.URL) *Upstream {
+	if i := u.match(url); i > -1 {
+		return &u.upstreams[i]
+	}
+
+	return nil
+}
+
+// List returns the upstreams
+func (u *Upstreams) List() []Upstream {
+	if u == nil || len(u.upstreams) == 0 {
+		return nil
+	}
+
+	return append([]Upstream(nil), u.upstreams...)
+}
+
+// Proxy returns the global proxy
+func (u *Upstreams) Proxy() Proxy {
+	if u == nil {
+		return Proxy{}
+	}
+
+	return u.proxy
+}
+
+// ProxyHost returns the host of the global proxy, credentials are kept out of logs
+func (u *Upstreams) ProxyHost() string {
+	if proxy := u.Proxy(); proxy.URL != nil {
+		return proxy.URL.Host
+	}
+
+	return ""
+}
+
+// Hosts returns the host of each upstream, credentials are kept out of logs
+func (u *Upstreams) Hosts() []string {
+	if u == nil {
+		return nil
+	}
+
+	hosts := make([]string, len(u.upstreams))
+	for i, upstream := range u.upstreams {
+		hosts[i] = upstream.Host
+	}
+
+	return hosts
+}
+
+// Apply adds the credentials to the request and returns the transport of the matching upstream,
+// it returns nil if the client transport should be used
+func (u *Upstreams) Apply(req *http.Request) http.RoundTripper {
+	if u == nil {
+		return nil
+	}
+
+	i := u.match(req.URL)
+	if i < 0 {
+		return u.transport
+	}
+
+	upstream := u.upstreams[i]
+	if len(upstream.Username) > 0 || len(upstream.Password) > 0 {
+		req.SetBasicAuth(upstream.Username, upstream.Password)
+	} else if len(upstream.BearerToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+upstream.BearerToken)
+	}
+
+	return u.transports[i]
+}
+
+func (u *Upstreams) match(url *neturl.URL) int {
+	if u == nil || url == nil {
+		return -1
+	}
+
+	for i, upstream := range u.upstreams {
+		if MatchHost(upstream.Host, url) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// buildTransport returns a transport with the TLS, dial and proxy settings, nil if there are none
+func (upstream Upstream) buildTransport(proxy Proxy) (http.RoundTripper, error) {
+	if len(upstream.CAFile) == 0 && len(upstream.CertFile) == 0 && len(upstream.KeyFile) == 0 &&
+		!upstream.InsecureSkipVerify && len(upstream.Resolve) == 0 &&
+		len(upstream.Proxy) == 0 && proxy.URL == nil {
+		return nil, nil
+	}
+
+	proxyFunc, err := buildProxyFunc(upstream, proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: upstream.InsecureSkipVerify}
+
+	if len(upstream.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(upstream.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", upstream.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(upstream.CertFile) > 0 || len(upstream.KeyFile) > 0 {
+		if len(upstream.CertFile) == 0 || len(upstream.KeyFile) == 0 {
+			return nil, errors.New("cert file and key file must be used together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(upstream.CertFile, upstream.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	resolve := make(map[string]string)
+	for _, entry := range upstream.Resolve {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("resolve %q must be 'host:port:address'", entry)
+		}
+
+		address := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+		if net.ParseIP(address) == nil {
+			return nil, fmt.Errorf("resolve %q has invalid address %q", entry, parts[2])
+		}
+		resolve[strings.ToLower(net.JoinHostPort(parts[0], parts[1]))] = net.JoinHostPort(address, parts[1])
+	}
+
+	// same settings as http.DefaultTransport
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy: proxyFunc,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if resolved, ok := resolve[strings.ToLower(addr)]; ok {
+				addr = resolved
+			}
+
+			return dialer.DialContext(ctx, network, addr)
+		},
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,284 @@
+package crawler_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"time"
+
+	. "github.com/alphagov/spotlight-gel/crawler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/jarcoal/httpmock.v1"
+)
+
+var _ = Describe("Upstreams", func() {
+	var tempFiles []string
+
+	writeTempFile := func(data []byte) string {
+		f, err := ioutil.TempFile("", "upstream")
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		f.Write(data)
+		tempFiles = append(tempFiles, f.Name())
+		return f.Name()
+	}
+
+	writeCertificatePEM := func(cert *x509.Certificate) string {
+		return writeTempFile(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+
+	// writeClientCertificate returns the paths of a self-signed certificate and its key
+	writeClientCertificate := func() (string, string) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "mirror"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		Expect(err).ToNot(HaveOccurred())
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+
+		certPath := writeTempFile(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+		keyPath := writeTempFile(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+		return certPath, keyPath
+	}
+
+	download := func(url string, upstreams []Upstream) *Downloaded {
+		u, err := NewUpstreams(upstreams, Proxy{})
+		Expect(err).ToNot(HaveOccurred())
+		parsedURL, _ := neturl.Parse(url)
+
+		return Download(&Input{
+			Client:    http.DefaultClient,
+			URL:       parsedURL,
+			Upstreams: u,
+		})
+	}
+
+	newServer := func() *httptest.Server {
+		return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "upstream")
+		}))
+	}
+
+	BeforeEach(func() {
+		httpmock.Activate()
+		tempFiles = nil
+	})
+
+	AfterEach(func() {
+		httpmock.DeactivateAndReset()
+		for _, tempFile := range tempFiles {
+			os.Remove(tempFile)
+		}
+	})
+
+	Describe("Match", func() {
+		upstreams, _ := NewUpstreams([]Upstream{
+			{Host: "domain.com"},
+			{Host: "*.domain.com:8443"},
+			{Host: "*.domain.com"},
+		}, Proxy{})
+
+		match := func(url string) string {
+			parsedURL, _ := neturl.Parse(url)
+			upstream := upstreams.Match(parsedURL)
+			if upstream == nil {
+				return ""
+			}
+
+			return upstream.Host
+		}
+
+		It("should match host", func() {
+			Expect(match("http://DOMAIN.com/Match/host")).To(Equal("domain.com"))
+			Expect(match("http://domain.com:8080/Match/host")).To(Equal("domain.com"))
+		})
+
+		It("should match sub domains", func() {
+			Expect(match("https://www.domain.com/Match/sub")).To(Equal("*.domain.com"))
+			Expect(match("https://api.www.domain.com/Match/sub")).To(Equal("*.domain.com"))
+		})
+
+		It("should match port", func() {
+			Expect(match("https://api.domain.com:8443/Match/port")).To(Equal("*.domain.com:8443"))
+		})
+
+		It("should not match other hosts", func() {
+			Expect(match("http://domain2.com/Match/other")).To(Equal(""))
+			Expect(match("http://notdomain.com/Match/other")).To(Equal(""))
+		})
+
+		It("should handle nil", func() {
+			var nilUpstreams *Upstreams
+			parsedURL, _ := neturl.Parse("http://domain.com/Match/nil")
+
+			Expect(nilUpstreams.Match(parsedURL)).To(BeNil())
+			Expect(nilUpstreams.List()).To(BeNil())
+		})
+	})
+
+	Describe("NewUpstreams", func() {
+		It("should not accept empty host", func() {
+			_, err := NewUpstreams([]Upstream{{}}, Proxy{})
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should not accept basic auth with bearer token", func() {
+			_, err := NewUpstreams([]Upstream{{Host: "domain.com", Username: "u", BearerToken: "t"}}, Proxy{})
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should not accept missing ca file", func() {
+			_, err := NewUpstreams([]Upstream{{Host: "domain.com", CAFile: "/NewUpstreams/missing.pem"}}, Proxy{})
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should not accept ca file without certificate", func() {
+			_, err := NewUpstreams([]Upstream{{Host: "domain.com", CAFile: writeTempFile([]byte("not a pem"))}}, Proxy{})
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should not accept cert file without key file", func() {
+			certPath, _ := writeClientCertificate()
+			_, err := NewUpstreams([]Upstream{{Host: "domain.com", CertFile: certPath}}, Proxy{})
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should not accept invalid resolve", func() {
+			for _, resolve := range []string{"domain.com:443", "domain.com:443:not-an-ip"} {
+				_, err := NewUpstreams([]Upstream{{Host: "domain.com", Resolve: []string{resolve}}}, Proxy{})
+
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Describe("Download", func() {
+		It("should send basic auth", func() {
+			url := "http://domain.com/Upstreams/basic/auth"
+			httpmock.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+				user, password, _ := req.BasicAuth()
+				return httpmock.NewStringResponse(200, user+":"+password), nil
+			})
+
+			downloaded := download(url, []Upstream{{Host: "domain.com", Username: "user", Password: "p@ss"}})
+
+			Expect(downloaded.Body).To(Equal("user:p@ss"))
+		})
+
+		It("should send bearer token", func() {
+			url := "http://domain.com/Upstreams/bearer"
+			httpmock.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+				return httpmock.NewStringResponse(200, req.Header.Get("Authorization")), nil
+			})
+
+			downloaded := download(url, []Upstream{
+				{Host: "domain2.com", BearerToken: "other"},
+				{Host: "domain.com", BearerToken: "token"},
+			})
+
+			Expect(downloaded.Body).To(Equal("Bearer token"))
+		})
+
+		It("should not send credentials to other hosts", func() {
+			url := "http://domain2.com/Upstreams/other/host"
+			httpmock.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+				return httpmock.NewStringResponse(200, req.Header.Get("Authorization")), nil
+			})
+
+			downloaded := download(url, []Upstream{{Host: "domain.com", BearerToken: "token"}})
+
+			Expect(downloaded.Body).To(Equal(""))
+		})
+
+		It("should trust ca file", func() {
+			server := newServer()
+			defer server.Close()
+
+			downloaded := download(server.URL, []Upstream{{Host: "127.0.0.1", CAFile: writeCertificatePEM(server.Certificate())}})
+
+			Expect(downloaded.Error).ToNot(HaveOccurred())
+			Expect(downloaded.Body).To(Equal("upstream"))
+		})
+
+		It("should not trust unknown certificate", func() {
+			server := newServer()
+			defer server.Close()
+
+			// the unused resolve entry builds a transport that connects to the server instead of httpmock
+			downloaded := download(server.URL, []Upstream{{Host: "127.0.0.1", Resolve: []string{"unused.test:443:127.0.0.1"}}})
+
+			Expect(downloaded.Error).To(HaveOccurred())
+		})
+
+		It("should skip verify", func() {
+			server := newServer()
+			defer server.Close()
+
+			downloaded := download(server.URL, []Upstream{{Host: "127.0.0.1", InsecureSkipVerify: true}})
+
+			Expect(downloaded.Error).ToNot(HaveOccurred())
+			Expect(downloaded.Body).To(Equal("upstream"))
+		})
+
+		It("should send client certificate", func() {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+			}))
+			server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+			server.StartTLS()
+			defer server.Close()
+			certPath, keyPath := writeClientCertificate()
+
+			downloaded := download(server.URL, []Upstream{{
+				Host:     "127.0.0.1",
+				CAFile:   writeCertificatePEM(server.Certificate()),
+				CertFile: certPath,
+				KeyFile:  keyPath,
+			}})
+
+			Expect(downloaded.Error).ToNot(HaveOccurred())
+			Expect(downloaded.Body).To(Equal("mirror"))
+		})
+
+		It("should resolve host", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, r.Host)
+			}))
+			defer server.Close()
+			serverURL, _ := neturl.Parse(server.URL)
+			url := fmt.Sprintf("http://upstream.test:%s/Upstreams/resolve", serverURL.Port())
+
+			downloaded := download(url, []Upstream{{
+				Host:    "upstream.test",
+				Resolve: []string{fmt.Sprintf("upstream.test:%s:127.0.0.1", serverURL.Port())},
+			}})
+
+			Expect(downloaded.Error).ToNot(HaveOccurred())
+			Expect(downloaded.Body).To(Equal("upstream.test:" + serverURL.Port()))
+		})
+	})
+})
@@ -71,3 +71,78 @@ func LongestCommonPrefix(path1 string, path2 string) string {
 
 	return strings.Join(x, sep)
 }
+
+// SrcsetCandidate represents an image candidate string from a srcset attribute
+type SrcsetCandidate struct {
+	URL string
+	// Descriptor is the optional width (e.g. 100w) or pixel density (e.g. 2x) descriptor
+	Descriptor string
+}
+
+// ParseSrcset returns the image candidates of a srcset attribute value,
+// commas inside urls (e.g. /w_100,h_100/img.jpg) are kept as specified by the HTML standard
+func ParseSrcset(srcset string) []SrcsetCandidate {
+	candidates := make([]SrcsetCandidate, 0)
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+	}
+
+	i := 0
+	for i < len(srcset) {
+		for i < len(srcset) && (isSpace(srcset[i]) || srcset[i] == ',') {
+			i++
+		}
+		if i >= len(srcset) {
+			break
+		}
+
+		start := i
+		for i < len(srcset) && !isSpace(srcset[i]) {
+			i++
+		}
+		url := srcset[start:i]
+
+		descriptor := ""
+		if strings.HasSuffix(url, ",") {
+			url = strings.TrimRight(url, ",")
+		} else {
+			start = i
+			parens := 0
+			for ; i < len(srcset); i++ {
+				if srcset[i] == '(' {
+					parens++
+				} else if srcset[i] == ')' && parens > 0 {
+					parens--
+				} else if srcset[i] == ',' && parens == 0 {
+					break
+				}
+			}
+			descriptor = strings.TrimSpace(srcset[start:i])
+		}
+
+		candidates = append(candidates, SrcsetCandidate{URL: url, Descriptor: descriptor})
+	}
+
+	return candidates
+}
+
+func (c SrcsetCandidate) String() string {
+	if len(c.Descriptor) == 0 {
+		return c.URL
+	}
+
+	return c.URL + " " + c.Descriptor
+}
+
+// MatchHost returns true if the url host matches the pattern such as example.com,
+// *.example.com matches its sub domains and a port restricts the match to urls with this port
+func MatchHost(pattern string, url *neturl.URL) bool {
+	host := url.Hostname()
+	if strings.Contains(pattern, ":") {
+		host = url.Host
+	}
+
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(host)
+	return pattern == host || (strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]))
+}
@@ -405,4 +405,64 @@ var _ = Describe("Utils", func() {
 			})
 		})
 	})
+
+	Describe("ParseSrcset", func() {
+		It("should parse single url", func() {
+			Expect(ParseSrcset("image.jpg")).To(Equal([]SrcsetCandidate{{URL: "image.jpg"}}))
+		})
+
+		It("should parse descriptors", func() {
+			Expect(ParseSrcset(" small.jpg 100w,\n large.jpg 2x ")).To(Equal([]SrcsetCandidate{
+				{URL: "small.jpg", Descriptor: "100w"},
+				{URL: "large.jpg", Descriptor: "2x"},
+			}))
+		})
+
+		It("should parse url without descriptor followed by comma", func() {
+			Expect(ParseSrcset("a.jpg, b.jpg 2x")).To(Equal([]SrcsetCandidate{
+				{URL: "a.jpg"},
+				{URL: "b.jpg", Descriptor: "2x"},
+			}))
+		})
+
+		It("should keep commas inside url", func() {
+			Expect(ParseSrcset("/w_100,h_100/a.jpg 1x, /w_200,h_200/a.jpg 2x")).To(Equal([]SrcsetCandidate{
+				{URL: "/w_100,h_100/a.jpg", Descriptor: "1x"},
+				{URL: "/w_200,h_200/a.jpg", Descriptor: "2x"},
+			}))
+		})
+
+		It("should handle empty value", func() {
+			Expect(len(ParseSrcset(" , "))).To(Equal(0))
+		})
+
+		It("should format candidate", func() {
+			Expect(SrcsetCandidate{URL: "a.jpg"}.String()).To(Equal("a.jpg"))
+			Expect(SrcsetCandidate{URL: "a.jpg", Descriptor: "2x"}.String()).To(Equal("a.jpg 2x"))
+		})
+	})
+
+	Describe("MatchHost", func() {
+		match := func(pattern string, url string) bool {
+			parsedURL, err := neturl.Parse(url)
+			Expect(err).ToNot(HaveOccurred())
+			return MatchHost(pattern, parsedURL)
+		}
+
+		It("should match host name", func() {
+			Expect(match("domain.com", "http://DOMAIN.com/")).To(BeTrue())
+			Expect(match("domain.com", "http://domain.com:8080/")).To(BeTrue())
+			Expect(match("domain.com", "http://sub.domain.com/")).To(BeFalse())
+		})
+
+		It("should match sub domains", func() {
+			Expect(match("*.domain.com", "http://a.b.domain.com/")).To(BeTrue())
+			Expect(match("*.domain.com", "http://domain.com/")).To(BeFalse())
+		})
+
+		It("should match port", func() {
+			Expect(match("domain.com:8080", "http://domain.com:8080/")).To(BeTrue())
+			Expect(match("domain.com:8080", "http://domain.com/")).To(BeFalse())
+		})
+	})
 })
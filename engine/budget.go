@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"errors"
+	neturl "net/url"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// CrawlBudgetMaxURLs is the reason of crawls stopped by CrawlBudget.MaxURLs
+	CrawlBudgetMaxURLs = "max-urls"
+	// CrawlBudgetMaxBytes is the reason of crawls stopped by CrawlBudget.MaxBytes
+	CrawlBudgetMaxBytes = "max-bytes"
+	// CrawlBudgetMaxDuration is the reason of crawls stopped by CrawlBudget.MaxDuration
+	CrawlBudgetMaxDuration = "max-duration"
+)
+
+func (e *engine) GetMirrorBudget(url *neturl.URL) (CrawlBudgetStatus, error) {
+	if url == nil {
+		return CrawlBudgetStatus{}, errors.New("cross-host mirror has no crawl budget")
+	}
+
+	m := e.getMirror(buildMirrorRoot(url))
+	if m == nil {
+		return CrawlBudgetStatus{}, errors.New("mirror not found")
+	}
+
+	return m.budget.getStatus(), nil
+}
+
+// withDefaults returns the budget with its zero limits taken from defaults
+func (b CrawlBudget) withDefaults(defaults CrawlBudget) CrawlBudget {
+	if b.MaxURLs == 0 {
+		b.MaxURLs = defaults.MaxURLs
+	}
+	if b.MaxBytes == 0 {
+		b.MaxBytes = defaults.MaxBytes
+	}
+	if b.MaxDuration == 0 {
+		b.MaxDuration = defaults.MaxDuration
+	}
+	if b.MaxObjectBytes == 0 {
+		b.MaxObjectBytes = defaults.MaxObjectBytes
+	}
+
+	return b
+}
+
+// engineBudget keeps the counters of the current crawl of a mirror
+type engineBudget struct {
+	root   *neturl.URL
+	logger *logrus.Logger
+
+	mutex    sync.Mutex
+	options  CrawlBudget
+	started  time.Time
+	urls     uint64
+	bytes    uint64
+	skipped  uint64
+	exceeded string
+	// queued is only kept with MaxURLs so it cannot grow past the limit
+	queued map[string]bool
+}
+
+func newEngineBudget(root *neturl.URL, options CrawlBudget, logger *logrus.Logger) *engineBudget {
+	b := &engineBudget{
+		root:    root,
+		options: options,
+		logger:  logger,
+	}
+	b.reset()
+
+	return b
+}
+
+// reset starts a new crawl
+func (b *engineBudget) reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.started = time.Now()
+	b.urls = 0
+	b.bytes = 0
+	b.skipped = 0
+	b.exceeded = ""
+	b.queued = nil
+	if b.options.MaxURLs > 0 {
+		b.queued = make(map[string]bool)
+	}
+}
+
+// setOptions changes the limits of the current crawl, the counters are kept
+func (b *engineBudget) setOptions(options CrawlBudget) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.options == options {
+		return
+	}
+	if b.options.MaxURLs == 0 && options.MaxURLs > 0 {
+		b.queued = make(map[string]bool)
+	} else if options.MaxURLs == 0 {
+		b.queued = nil
+	}
+	b.options = options
+	b.exceeded = ""
+}
+
+// queue returns true and counts the url if it can be queued,
+// urls queued already during the crawl are only counted once with MaxURLs
+func (b *engineBudget) queue(url *neturl.URL) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.check() {
+		return false
+	}
+
+	if b.queued != nil {
+		key := url.String()
+		if b.queued[key] {
+			return true
+		}
+		if b.urls >= b.options.MaxURLs {
+			b.exceed(CrawlBudgetMaxURLs)
+			return false
+		}
+		b.queued[key] = true
+	}
+	b.urls++
+
+	return true
+}
+
+// allows returns true if urls queued before can still be downloaded
+func (b *engineBudget) allows() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.check()
+}
+
+// downloaded counts the bytes of a downloaded body
+func (b *engineBudget) downloaded(size int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.bytes += uint64(size)
+}
+
+// skip counts a response larger than MaxObjectBytes
+func (b *engineBudget) skip(url *neturl.URL) {
+	b.mutex.Lock()
+	b.skipped++
+	b.mutex.Unlock()
+
+	b.logger.WithFields(logrus.Fields{
+		"root":           b.root,
+		"url":            url,
+		"maxObjectBytes": b.options.MaxObjectBytes,
+	}).Warn("Skipped response over crawl budget")
+}
+
+func (b *engineBudget) getStatus() CrawlBudgetStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.check()
+
+	return CrawlBudgetStatus{
+		Budget:   b.options,
+		Started:  b.started,
+		URLs:     b.urls,
+		Bytes:    b.bytes,
+		Skipped:  b.skipped,
+		Exceeded: b.exceeded,
+	}
+}
+
+// check returns false once MaxBytes or MaxDuration is hit, the caller must hold the mutex.
+// MaxURLs only stops new urls, the urls queued within the limit are still downloaded
+func (b *engineBudget) check() bool {
+	switch {
+	case b.options.MaxBytes > 0 && b.bytes >= b.options.MaxBytes:
+		b.exceed(CrawlBudgetMaxBytes)
+	case b.options.MaxDuration > 0 && time.Since(b.started) >= b.options.MaxDuration:
+		b.exceed(CrawlBudgetMaxDuration)
+	default:
+		return true
+	}
+
+	return false
+}
+
+// exceed records and logs the first limit being hit, the caller must hold the mutex
+func (b *engineBudget) exceed(reason string) {
+	if len(b.exceeded) > 0 {
+		return
+	}
+	b.exceeded = reason
+
+	b.logger.WithFields(logrus.Fields{
+		"root":    b.root,
+		"reason":  reason,
+		"urls":    b.urls,
+		"bytes":   b.bytes,
+		"skipped": b.skipped,
+		"elapsed": time.Since(b.started),
+	}).Warn("Crawl budget exceeded, stopped queuing")
+}
@@ -4,26 +4,42 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	neturl "net/url"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/alphagov/spotlight-gel/cacher"
+	"github.com/alphagov/spotlight-gel/crawler"
 	"github.com/namsral/flag"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v2"
 )
 
 // Config represents an engine configuration
 type Config struct {
+	Path        string
 	LoggerLevel configLoggerLevel
+	Role        Role
 
 	HostRewrites        configStringMap
 	HostsWhitelist      configStringSlice
+	CrawlRules          configCrawlRuleSlice
+	RewriteRules        configRewriteRuleSlice
+	HeaderRules         configHeaderRuleSlice
 	BumpTTL             time.Duration
 	AutoEnqueueInterval time.Duration
 	HttpTimeout         time.Duration
+	Canonical           cacher.CanonicalRules
+	CachePost           bool
+	// Budget applies to each mirror, the limits set by a mirror take precedence
+	Budget CrawlBudget
 
 	Cacher  configCacher
 	Crawler configCrawler
@@ -31,6 +47,100 @@ type Config struct {
 	Port        int64
 	MirrorURLs  configURLSlice
 	MirrorPorts configIntSlice
+	Mirrors     []ConfigMirror
+	// Upstreams are loaded from the config file, credentials have their environment variables expanded
+	Upstreams []crawler.Upstream
+}
+
+// ConfigMirror represents the configuration of a single mirror loaded from the config file
+type ConfigMirror struct {
+	URL  *neturl.URL
+	Port int
+
+	AutoDownloadDepth *uint64
+	CacheTTL          time.Duration
+	RequestHeader     http.Header
+	HostRewrites      map[string]string
+	HostsWhitelist    []string
+	CrawlRules        []CrawlRule
+	RewriteRules      []RewriteRule
+	HeaderRules       []HeaderRule
+	SanitizeRules     *crawler.SanitizeRules
+	InjectRules       *crawler.InjectRules
+	DOMRules          []crawler.DOMRule
+	FormLimit         *uint64
+	Schedules         []MirrorSchedule
+	CookieJar         string
+	Login             *Login
+	Budget            CrawlBudget
+}
+
+type configFileMirror struct {
+	URL       string               `yaml:"url"`
+	Port      *int                 `yaml:"port"`
+	Depth     *uint64              `yaml:"depth"`
+	CacheTTL  time.Duration        `yaml:"cache-ttl"`
+	Header    map[string]string    `yaml:"header"`
+	Rewrite   map[string]string    `yaml:"rewrite"`
+	Whitelist []string             `yaml:"whitelist"`
+	Rules     []string             `yaml:"rules"`
+	Schedules []configFileSchedule `yaml:"schedules"`
+
+	Sanitize     []string          `yaml:"sanitize"`
+	SanitizeMeta []string          `yaml:"sanitize-meta"`
+	Inject       *configFileInject `yaml:"inject"`
+	DOM          []string          `yaml:"dom"`
+	BodyRules    []string          `yaml:"body-rules"`
+	HeaderRules  []string          `yaml:"header-rules"`
+	FormLimit    *uint64           `yaml:"form-limit"`
+	CookieJar    string            `yaml:"cookie-jar"`
+	Login        *configFileLogin  `yaml:"login"`
+	Budget       configFileBudget  `yaml:"budget"`
+}
+
+type configFileUpstream struct {
+	Host               string   `yaml:"host"`
+	Username           string   `yaml:"username"`
+	Password           string   `yaml:"password"`
+	BearerToken        string   `yaml:"bearer-token"`
+	CAFile             string   `yaml:"ca-file"`
+	CertFile           string   `yaml:"cert-file"`
+	KeyFile            string   `yaml:"key-file"`
+	InsecureSkipVerify bool     `yaml:"insecure-skip-verify"`
+	Resolve            []string `yaml:"resolve"`
+	Proxy              string   `yaml:"proxy"`
+}
+
+type configFileLogin struct {
+	LoggedOut string                `yaml:"logged-out"`
+	Steps     []configFileLoginStep `yaml:"steps"`
+}
+
+type configFileLoginStep struct {
+	URL    string            `yaml:"url"`
+	Method string            `yaml:"method"`
+	Form   map[string]string `yaml:"form"`
+}
+
+type configFileInject struct {
+	Banner         string `yaml:"banner"`
+	BannerPosition string `yaml:"banner-position"`
+	NoIndex        bool   `yaml:"noindex"`
+	Canonical      string `yaml:"canonical"`
+}
+
+type configFileBudget struct {
+	MaxURLs        uint64        `yaml:"max-urls"`
+	MaxBytes       uint64        `yaml:"max-bytes"`
+	MaxDuration    time.Duration `yaml:"max-duration"`
+	MaxObjectBytes uint64        `yaml:"max-object-bytes"`
+}
+
+type configFileSchedule struct {
+	Cron       string        `yaml:"cron"`
+	Depth      *uint64       `yaml:"depth"`
+	NearExpiry time.Duration `yaml:"near-expiry"`
+	Jitter     time.Duration `yaml:"jitter"`
 }
 
 type configCacher struct {
@@ -40,14 +150,30 @@ type configCacher struct {
 
 type configCrawler struct {
 	AutoDownloadDepth configUint64
+	DOMRules          configDOMRuleSlice
+	FormLimit         configUint64
+	JSONPaths         configJSONPathSlice
+	KeepCharset       bool
+	KeepEncoding      bool
 	NoCrossHost       bool
 	NoProxy           bool
+	Proxy             string
+	ProxyBypass       configStringSlice
 	RequestHeader     configHTTPHeader
+	Sanitize          crawler.SanitizeRules
+	Inject            crawler.InjectRules
 	WorkerCount       configUint64
 }
 
+type configCanonicalOptions cacher.CanonicalRules
+type configCrawlRuleSlice []CrawlRule
+type configHeaderRuleSlice []HeaderRule
+type configRewriteRuleSlice []RewriteRule
+type configDOMRuleSlice []crawler.DOMRule
 type configHTTPHeader http.Header
+type configJSONPathSlice []crawler.JSONPath
 type configLoggerLevel logrus.Level
+type configSanitizeOptions crawler.SanitizeRules
 type configIntSlice []int
 type configStringMap map[string]string
 type configStringSlice []string
@@ -57,18 +183,33 @@ type configURLSlice []*neturl.URL
 const (
 	// ConfigEnvVarPrefix the environment variable prefix
 	ConfigEnvVarPrefix = "SITEMIRROR"
+	// ConfigFileKeyMirrors the config file key for the list of mirrors
+	ConfigFileKeyMirrors = "mirrors"
+	// ConfigFileKeyUpstreams the config file key for the list of upstreams
+	ConfigFileKeyUpstreams = "upstreams"
 	// ConfigDefaultLoggerLevel default value for .LoggerLevel
 	ConfigDefaultLoggerLevel = logrus.InfoLevel
+	// ConfigDefaultRole default value for .Role
+	ConfigDefaultRole = RoleAll
 	// ConfigDefaultBumpTTL default value for .BumpTTL
 	ConfigDefaultBumpTTL = time.Minute
 	// ConfigDefaultAutoEnqueueInterval default value for .AutoEnqueueInterval
 	ConfigDefaultAutoEnqueueInterval = time.Duration(0)
 	// ConfigDefaultHttpTimeout default value for .HttpTimeout
 	ConfigDefaultHttpTimeout = 10 * time.Second
+	// ConfigDefaultCanonicalOptions default value for .Canonical options
+	ConfigDefaultCanonicalOptions = cacher.CanonicalLowercaseHost + "," +
+		cacher.CanonicalStripDefaultPort + "," +
+		cacher.CanonicalDecodeUnreserved + "," +
+		cacher.CanonicalSortQuery
 	// ConfigDefaultCacherDefaultTTL default value for .Cacher.DefaultTTL
 	ConfigDefaultCacherDefaultTTL = 10 * time.Minute
 	// ConfigDefaultCrawlerAutoDownloadDepth default value for .Crawler.AutoDownloadDepth
 	ConfigDefaultCrawlerAutoDownloadDepth = uint64(1)
+	// ConfigDefaultCrawlerKeepCharset default value for .Crawler.KeepCharset
+	ConfigDefaultCrawlerKeepCharset = false
+	// ConfigDefaultCrawlerKeepEncoding default value for .Crawler.KeepEncoding
+	ConfigDefaultCrawlerKeepEncoding = false
 	// ConfigDefaultCrawlerNoCrossHost default value for .Crawler.NoCrossHost
 	ConfigDefaultCrawlerNoCrossHost = false
 	// ConfigDefaultCrawlerNoProxy default value for .Crawler.NoProxy
@@ -79,22 +220,59 @@ const (
 	ConfigDefaultPort = int64(-1)
 )
 
-// ParseConfig returns configuration derived from command line arguments or environment variables
+func init() {
+	// the config file is in yaml and will be loaded by ParseConfig itself,
+	// do not let the flag package try to parse it as key value pairs
+	flag.DefaultConfigFlagname = ""
+}
+
+// ParseConfig returns configuration derived from command line arguments or environment variables.
+// If a config file is specified, its values are used for flags that have not been set.
 func ParseConfig(arg0 string, otherArgs []string, output io.Writer) (*Config, error) {
+	fs, config := NewConfigFlagSet(arg0, output)
+	err := ParseConfigFlagSet(fs, config, otherArgs, output)
+
+	return config, err
+}
+
+// NewConfigFlagSet returns a flag set with all configuration flags defined,
+// callers may define additional flags before calling ParseConfigFlagSet
+func NewConfigFlagSet(arg0 string, output io.Writer) (*flag.FlagSet, *Config) {
 	config := &Config{}
 
-	fs := flag.NewFlagSetWithEnvPrefix(arg0, ConfigEnvVarPrefix, 0)
+	fs := flag.NewFlagSetWithEnvPrefix(arg0, ConfigEnvVarPrefix, flag.ContinueOnError)
 	fs.SetOutput(output)
 
+	fs.StringVar(&config.Path, "config", "", "Path to YAML config file, flags and env vars take precedence")
+
 	config.LoggerLevel = configLoggerLevel(ConfigDefaultLoggerLevel)
 	fs.Var(&config.LoggerLevel, "log", "Logging output level")
 
+	config.Role = ConfigDefaultRole
+	fs.Var(&config.Role, "role", "Engine role, must be 'serve-only', 'crawl-only', 'on-demand-proxy', 'all' "+
+		"or a comma separated list of 'serve', 'crawl' and 'proxy'")
+
 	fs.Var(&config.HostRewrites, "rewrite", "Link rewrites, must be 'source.domain.com=http://target.domain.com/some/path'")
 	fs.Var(&config.HostsWhitelist, "whitelist", "Restricted list of crawlable hosts")
+	fs.Var(&config.CrawlRules, "rule", "Ordered crawl rules, must be 'include|exclude key=value ...', "+
+		"keys are scheme, host, path, path-regexp and query")
+	fs.Var(&config.RewriteRules, "body-rule", "Ordered response body rewrites applied before caching, "+
+		"must be '[key=value ...] s/pattern/replacement/flags', keys are the -rule keys and type")
+	fs.Var(&config.HeaderRules, "header-rule", "Ordered response header changes applied before caching, "+
+		"must be '[key=value ...] set|add Key: value' or '[key=value ...] remove Key'")
 	fs.DurationVar(&config.BumpTTL, "cache-bump", ConfigDefaultBumpTTL, "Validity of cache bump")
 	fs.DurationVar(&config.AutoEnqueueInterval, "auto-refresh", ConfigDefaultAutoEnqueueInterval, "Interval for url auto refreshes, default=no refresh")
 	fs.DurationVar(&config.HttpTimeout, "http-timeout", ConfigDefaultHttpTimeout, "HTTP request timeout")
 
+	canonicalOptions := (*configCanonicalOptions)(&config.Canonical)
+	canonicalOptions.Set(ConfigDefaultCanonicalOptions)
+	fs.Var(canonicalOptions, "canonical", "URL canonicalization options, comma separated list of "+
+		"'lowercase-host', 'strip-default-port', 'decode-unreserved', 'sort-query', 'merge-trailing-slash'")
+	fs.Var((*configStringSlice)(&config.Canonical.DropQueryKeys), "drop-query",
+		"Query parameter to drop from urls, a trailing * matches any parameter with the prefix (e.g. 'utm_*')")
+	fs.BoolVar(&config.CachePost, "cache-post", false, "Cache POST responses by url and request body, "+
+		"form-urlencoded and JSON bodies are normalized")
+
 	fs.StringVar(&config.Cacher.Path, "cache-path", "", "HTTP Cache path (default working directory)")
 	fs.DurationVar(&config.Cacher.DefaultTTL, "cache-ttl", ConfigDefaultCacherDefaultTTL, "Validity of cached data")
 
@@ -102,8 +280,45 @@ func ParseConfig(arg0 string, otherArgs []string, output io.Writer) (*Config, er
 	fs.Var(&config.Crawler.AutoDownloadDepth, "auto-download-depth", "Maximum link depth for auto downloads, default=1")
 	//noinspection GoBoolExpressions
 	fs.BoolVar(&config.Crawler.NoCrossHost, "no-cross-host", ConfigDefaultCrawlerNoCrossHost, "Disable cross-host links")
-	fs.BoolVar(&config.Crawler.NoProxy, "no-proxy", ConfigDefaultCrawlerNoProxy, "Disable proxy to upstream only serve auto-download content")
+	//noinspection GoBoolExpressions
+	fs.BoolVar(&config.Crawler.KeepCharset, "keep-charset", ConfigDefaultCrawlerKeepCharset,
+		"Keep the original charset of documents instead of transcoding them to UTF-8")
+	//noinspection GoBoolExpressions
+	fs.BoolVar(&config.Crawler.KeepEncoding, "keep-encoding", ConfigDefaultCrawlerKeepEncoding,
+		"Keep gzip, br and zstd bodies as received for content types that are not parsed, users get them decoded if needed")
+	fs.BoolVar(&config.Crawler.NoProxy, "no-proxy", ConfigDefaultCrawlerNoProxy, "Deprecated: same as -role=serve,crawl")
+	fs.StringVar(&config.Crawler.Proxy, "proxy", "", "Proxy url for upstream requests, must be 'http://', 'https://' "+
+		"or 'socks5://' with optional 'user:password@', default=HTTP_PROXY and HTTPS_PROXY environment variables")
+	fs.Var(&config.Crawler.ProxyBypass, "proxy-bypass", "Host connected to without -proxy (e.g. '*.internal.domain.com'), "+
+		"multiple hosts are supported")
 	fs.Var(&config.Crawler.RequestHeader, "header", "Custom request header, must be 'key=value'")
+	fs.Var(&config.Crawler.JSONPaths, "json-path", "JSONPath-like selector of urls in JSON responses (e.g. '$.items[*].url'), "+
+		"multiple selectors are supported. Default to same host urls in any string value.")
+	fs.Var((*configSanitizeOptions)(&config.Crawler.Sanitize), "sanitize", "HTML sanitization options, comma separated list of "+
+		"'scripts', 'event-attrs', 'javascript-urls', 'unwrap-noscript', 'iframes'")
+	fs.Var((*configStringSlice)(&config.Crawler.Sanitize.Meta), "sanitize-meta",
+		"Meta tag to remove from html documents by name, http-equiv or property (e.g. 'refresh')")
+	fs.StringVar(&config.Crawler.Inject.Banner, "inject-banner", "",
+		"HTML template injected into documents, e.g. 'Archived copy, captured at {{.CapturedAt}} from {{.OriginalURL}}'")
+	fs.StringVar(&config.Crawler.Inject.BannerPosition, "inject-banner-position", crawler.InjectBodyStart,
+		"Position of the injected banner, must be 'body-start' or 'body-end'")
+	//noinspection GoBoolExpressions
+	fs.BoolVar(&config.Crawler.Inject.NoIndex, "inject-noindex", false, "Inject <meta name=\"robots\" content=\"noindex\"> into documents")
+	fs.StringVar(&config.Crawler.Inject.Canonical, "inject-canonical", "",
+		"Template of the <link rel=\"canonical\"> url injected into documents, e.g. '{{.OriginalURL}}'")
+	fs.Var(&config.Crawler.DOMRules, "dom", "DOM rule applied to html documents before urls are extracted, "+
+		"must be 'remove selector', 'replace selector => html', 'set-attr selector => attr=value' or 'wrap selector => html', "+
+		"multiple rules are supported")
+	fs.Var(&config.Crawler.FormLimit, "form-limit", "Maximum number of urls built from the select, radio and checkbox "+
+		"fields of each GET form, default=0 (disabled)")
+	fs.Var((*configUint64)(&config.Budget.MaxURLs), "max-urls", "Maximum number of distinct urls queued by each mirror crawl, "+
+		"default=0 (no limit)")
+	fs.Var((*configUint64)(&config.Budget.MaxBytes), "max-bytes", "Maximum total size in bytes of the bodies downloaded "+
+		"by each mirror crawl, default=0 (no limit)")
+	fs.DurationVar(&config.Budget.MaxDuration, "max-duration", 0, "Maximum duration of each mirror crawl, "+
+		"queued urls are dropped once it is over, default=no limit")
+	fs.Var((*configUint64)(&config.Budget.MaxObjectBytes), "max-object-bytes", "Maximum size in bytes of each body, "+
+		"larger responses are not cached, default=0 (no limit)")
 	config.Crawler.WorkerCount = configUint64(ConfigDefaultCrawlerWorkerCount)
 	fs.Var(&config.Crawler.WorkerCount, "workers", "Number of download workers")
 
@@ -112,9 +327,289 @@ func ParseConfig(arg0 string, otherArgs []string, output io.Writer) (*Config, er
 	fs.Var(&config.MirrorPorts, "mirror-port", "Port to mirror a single site, each port number should immediately follow its URL. "+
 		"For url that doesn't have any port, it will still be mirrored but without a web server.")
 
-	err := fs.Parse(otherArgs)
+	return fs, config
+}
 
-	return config, err
+// ParseConfigFlagSet parses arguments, environment variables and the config file into config
+func ParseConfigFlagSet(fs *flag.FlagSet, config *Config, args []string, output io.Writer) error {
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if len(config.Path) > 0 {
+		err = parseConfigFile(fs, config)
+		if err != nil {
+			fmt.Fprintf(output, "Cannot load config file %s: %v\n", config.Path, err)
+			return err
+		}
+	}
+
+	if config.Crawler.NoProxy {
+		// serve and crawl as the separate server and downloader engines used to
+		config.Role &^= RoleProxy
+	}
+
+	if err := config.Crawler.Inject.Validate(); err != nil {
+		fmt.Fprintf(output, "Invalid inject options: %v\n", err)
+		return err
+	}
+
+	if _, err := config.Crawler.getProxy(); err != nil {
+		fmt.Fprintf(output, "Invalid proxy: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func parseConfigFile(fs *flag.FlagSet, config *Config) error {
+	data, err := ioutil.ReadFile(config.Path)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	if mirrors, ok := values[ConfigFileKeyMirrors]; ok {
+		delete(values, ConfigFileKeyMirrors)
+		if err := parseConfigFileMirrors(mirrors, config); err != nil {
+			return err
+		}
+	}
+
+	if upstreams, ok := values[ConfigFileKeyUpstreams]; ok {
+		delete(values, ConfigFileKeyUpstreams)
+		if err := parseConfigFileUpstreams(upstreams, config); err != nil {
+			return err
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("unknown key %q", key)
+		}
+		if set[key] {
+			// flags and env vars take precedence over the file
+			continue
+		}
+
+		for _, value := range configFileFlagValues(values[key]) {
+			if err := fs.Set(key, value); err != nil {
+				return fmt.Errorf("invalid value %q for key %q: %v", value, key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseConfigFileUpstreams(value interface{}, config *Config) error {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	var fileUpstreams []configFileUpstream
+	if err := yaml.UnmarshalStrict(data, &fileUpstreams); err != nil {
+		return err
+	}
+
+	for i, fileUpstream := range fileUpstreams {
+		upstream := crawler.Upstream{
+			Host:               fileUpstream.Host,
+			CAFile:             fileUpstream.CAFile,
+			CertFile:           fileUpstream.CertFile,
+			KeyFile:            fileUpstream.KeyFile,
+			InsecureSkipVerify: fileUpstream.InsecureSkipVerify,
+			Resolve:            fileUpstream.Resolve,
+		}
+
+		credentials := []struct {
+			key    string
+			value  string
+			target *string
+		}{
+			{"username", fileUpstream.Username, &upstream.Username},
+			{"password", fileUpstream.Password, &upstream.Password},
+			{"bearer-token", fileUpstream.BearerToken, &upstream.BearerToken},
+			{"proxy", fileUpstream.Proxy, &upstream.Proxy},
+		}
+		for _, credential := range credentials {
+			expanded, err := expandEnvValue(credential.value)
+			if err != nil {
+				return fmt.Errorf("upstreams[%d].%s: %v", i, credential.key, err)
+			}
+			*credential.target = expanded
+		}
+
+		// load the certificates now to report errors with the config file
+		if _, err := crawler.NewUpstreams([]crawler.Upstream{upstream}, crawler.Proxy{}); err != nil {
+			return fmt.Errorf("upstreams[%d]: %v", i, err)
+		}
+
+		config.Upstreams = append(config.Upstreams, upstream)
+	}
+
+	return nil
+}
+
+func parseConfigFileMirrors(value interface{}, config *Config) error {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	var fileMirrors []configFileMirror
+	if err := yaml.UnmarshalStrict(data, &fileMirrors); err != nil {
+		return err
+	}
+
+	for i, fileMirror := range fileMirrors {
+		parsedURL, err := neturl.Parse(fileMirror.URL)
+		if err != nil || !parsedURL.IsAbs() {
+			return fmt.Errorf("mirrors[%d]: invalid url %q", i, fileMirror.URL)
+		}
+
+		mirror := ConfigMirror{
+			URL:  parsedURL,
+			Port: int(ConfigDefaultPort),
+
+			AutoDownloadDepth: fileMirror.Depth,
+			CacheTTL:          fileMirror.CacheTTL,
+			HostRewrites:      fileMirror.Rewrite,
+			HostsWhitelist:    fileMirror.Whitelist,
+			FormLimit:         fileMirror.FormLimit,
+			CookieJar:         fileMirror.CookieJar,
+			Budget:            CrawlBudget(fileMirror.Budget),
+		}
+
+		if fileMirror.Port != nil {
+			mirror.Port = *fileMirror.Port
+		}
+
+		for j, fileRule := range fileMirror.Rules {
+			rule, err := ParseCrawlRule(fileRule)
+			if err != nil {
+				return fmt.Errorf("mirrors[%d].rules[%d]: %v", i, j, err)
+			}
+
+			mirror.CrawlRules = append(mirror.CrawlRules, rule)
+		}
+
+		for j, fileRule := range fileMirror.BodyRules {
+			rule, err := ParseRewriteRule(fileRule)
+			if err != nil {
+				return fmt.Errorf("mirrors[%d].body-rules[%d]: %v", i, j, err)
+			}
+
+			mirror.RewriteRules = append(mirror.RewriteRules, rule)
+		}
+
+		for j, fileRule := range fileMirror.HeaderRules {
+			rule, err := ParseHeaderRule(fileRule)
+			if err != nil {
+				return fmt.Errorf("mirrors[%d].header-rules[%d]: %v", i, j, err)
+			}
+
+			mirror.HeaderRules = append(mirror.HeaderRules, rule)
+		}
+
+		if fileMirror.Sanitize != nil || fileMirror.SanitizeMeta != nil {
+			mirror.SanitizeRules = &crawler.SanitizeRules{Meta: fileMirror.SanitizeMeta}
+			if err := mirror.SanitizeRules.SetOptions(fileMirror.Sanitize); err != nil {
+				return fmt.Errorf("mirrors[%d].sanitize: %v", i, err)
+			}
+		}
+
+		if fileMirror.Inject != nil {
+			mirror.InjectRules = &crawler.InjectRules{
+				Banner:         fileMirror.Inject.Banner,
+				BannerPosition: fileMirror.Inject.BannerPosition,
+				NoIndex:        fileMirror.Inject.NoIndex,
+				Canonical:      fileMirror.Inject.Canonical,
+			}
+			if err := mirror.InjectRules.Validate(); err != nil {
+				return fmt.Errorf("mirrors[%d].inject: %v", i, err)
+			}
+		}
+
+		for j, fileRule := range fileMirror.DOM {
+			rule, err := crawler.ParseDOMRule(fileRule)
+			if err != nil {
+				return fmt.Errorf("mirrors[%d].dom[%d]: %v", i, j, err)
+			}
+
+			mirror.DOMRules = append(mirror.DOMRules, rule)
+		}
+
+		if fileMirror.Login != nil {
+			login, err := fileMirror.Login.parse()
+			if err != nil {
+				return fmt.Errorf("mirrors[%d].login%v", i, err)
+			}
+
+			mirror.Login = login
+		}
+
+		for j, fileSchedule := range fileMirror.Schedules {
+			if _, err := cron.ParseStandard(fileSchedule.Cron); err != nil {
+				return fmt.Errorf("mirrors[%d].schedules[%d]: invalid cron %q: %v", i, j, fileSchedule.Cron, err)
+			}
+
+			mirror.Schedules = append(mirror.Schedules, MirrorSchedule{
+				Spec:       fileSchedule.Cron,
+				Depth:      fileSchedule.Depth,
+				NearExpiry: fileSchedule.NearExpiry,
+				Jitter:     fileSchedule.Jitter,
+			})
+		}
+
+		if fileMirror.Header != nil {
+			mirror.RequestHeader = make(http.Header)
+			for headerKey, headerValue := range fileMirror.Header {
+				mirror.RequestHeader.Add(headerKey, headerValue)
+			}
+		}
+
+		config.Mirrors = append(config.Mirrors, mirror)
+	}
+
+	return nil
+}
+
+func configFileFlagValues(value interface{}) []string {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	case map[interface{}]interface{}:
+		values := make([]string, 0, len(v))
+		for key, item := range v {
+			values = append(values, fmt.Sprintf("%v=%v", key, item))
+		}
+		sort.Strings(values)
+		return values
+	}
+
+	return []string{fmt.Sprint(value)}
 }
 
 // FromConfig return an Engine instance with all configuration applied
@@ -122,7 +617,7 @@ func FromConfig(fs cacher.Fs, config *Config) Engine {
 	httpClient := &http.Client{
 		Timeout: config.HttpTimeout,
 	}
-	if config.Crawler.NoProxy {
+	if !config.Role.Has(RoleCrawl) && !config.Role.Has(RoleProxy) {
 		httpClient = nil
 	}
 
@@ -146,8 +641,22 @@ func FromConfig(fs cacher.Fs, config *Config) Engine {
 			}
 		}
 
+		if config.CrawlRules != nil {
+			e.SetCrawlRules([]CrawlRule(config.CrawlRules))
+		}
+		if config.RewriteRules != nil {
+			e.SetRewriteRules([]RewriteRule(config.RewriteRules))
+		}
+		if config.HeaderRules != nil {
+			e.SetHeaderRules([]HeaderRule(config.HeaderRules))
+		}
+
+		canonicalRules := config.Canonical
+		e.SetCanonicalRules(&canonicalRules)
+		e.SetRole(config.Role)
 		e.SetBumpTTL(config.BumpTTL)
 		e.SetAutoEnqueueInterval(config.AutoEnqueueInterval)
+		e.GetServer().SetCachePost(config.CachePost)
 	}
 
 	{
@@ -159,43 +668,452 @@ func FromConfig(fs cacher.Fs, config *Config) Engine {
 	}
 
 	{
-		crawler := e.GetCrawler()
-		crawler.SetAutoDownloadDepth(uint64(config.Crawler.AutoDownloadDepth))
-		crawler.SetNoCrossHost(config.Crawler.NoCrossHost)
-		crawler.SetNoProxy(config.Crawler.NoProxy)
+		crawlerObj := e.GetCrawler()
+		crawlerObj.SetAutoDownloadDepth(uint64(config.Crawler.AutoDownloadDepth))
+		crawlerObj.SetNoCrossHost(config.Crawler.NoCrossHost)
+		crawlerObj.SetKeepCharset(config.Crawler.KeepCharset)
+		crawlerObj.SetKeepEncoding(config.Crawler.KeepEncoding)
+		sanitizeRules := config.Crawler.Sanitize
+		crawlerObj.SetSanitizeRules(&sanitizeRules)
+		injectRules := config.Crawler.Inject
+		crawlerObj.SetInjectRules(&injectRules)
+		if config.Crawler.DOMRules != nil {
+			crawlerObj.SetDOMRules([]crawler.DOMRule(config.Crawler.DOMRules))
+		}
+		crawlerObj.SetFormLimit(uint64(config.Crawler.FormLimit))
+		if config.Crawler.JSONPaths != nil {
+			crawlerObj.SetJSONPaths([]crawler.JSONPath(config.Crawler.JSONPaths))
+		}
+		if proxy, _ := config.Crawler.getProxy(); config.Upstreams != nil || proxy.URL != nil {
+			setCrawlerUpstreams(crawlerObj, config.Upstreams, proxy, logger)
+		}
 
 		if config.Crawler.RequestHeader != nil {
 			requestHeader := http.Header(config.Crawler.RequestHeader)
 			for headerKey, headerValues := range requestHeader {
 				for _, headerValue := range headerValues {
-					crawler.AddRequestHeader(headerKey, headerValue)
+					crawlerObj.AddRequestHeader(headerKey, headerValue)
 				}
 			}
 		}
 
-		crawler.SetWorkerCount(uint64(config.Crawler.WorkerCount))
+		crawlerObj.SetWorkerCount(uint64(config.Crawler.WorkerCount))
+	}
+
+	for _, mirror := range config.getMirrors() {
+		e.MirrorWithOptions(mirror.URL, mirror.Port, mirror.getOptions())
+	}
+
+	return e
+}
+
+// Reload applies the differences between the specified configuration and the running engine.
+// Settings that cannot be changed on the fly are reported as requiring a restart.
+func (e *engine) Reload(config *Config) {
+	var (
+		changes  = 0
+		restarts = make([]string, 0)
+	)
+
+	if level := logrus.Level(config.LoggerLevel); e.logger.Level != level {
+		e.SetLoggerLevel(level)
+		changes++
+	}
+
+	{
+		hostRewrites := map[string]string(config.HostRewrites)
+		existing := e.GetHostRewrites()
+		for from := range existing {
+			if _, ok := hostRewrites[from]; !ok {
+				e.RemoveHostRewrite(from)
+				changes++
+			}
+		}
+		for from, to := range hostRewrites {
+			if existingTo, ok := existing[from]; !ok || existingTo != to {
+				e.AddHostRewrite(from, to)
+				changes++
+			}
+		}
+	}
+
+	{
+		hostsWhitelist := make(map[string]bool)
+		for _, host := range config.HostsWhitelist {
+			hostsWhitelist[host] = true
+		}
+
+		existing := make(map[string]bool)
+		for _, host := range e.GetHostsWhitelist() {
+			existing[host] = true
+			if !hostsWhitelist[host] {
+				e.RemoveHostWhitelisted(host)
+				changes++
+			}
+		}
+		for _, host := range config.HostsWhitelist {
+			if !existing[host] {
+				e.AddHostWhitelisted(host)
+				changes++
+			}
+		}
+	}
+
+	if rules := []CrawlRule(config.CrawlRules); !reflect.DeepEqual(e.GetCrawlRules(), rules) {
+		e.SetCrawlRules(rules)
+		changes++
+	}
+
+	if rules := []RewriteRule(config.RewriteRules); !reflect.DeepEqual(e.GetRewriteRules(), rules) {
+		e.SetRewriteRules(rules)
+		changes++
+	}
+
+	if rules := []HeaderRule(config.HeaderRules); !reflect.DeepEqual(e.GetHeaderRules(), rules) {
+		e.SetHeaderRules(rules)
+		changes++
+	}
+
+	if canonicalRules := config.Canonical; !reflect.DeepEqual(e.GetCanonicalRules(), &canonicalRules) {
+		e.SetCanonicalRules(&canonicalRules)
+		changes++
+	}
+
+	if e.GetBumpTTL() != config.BumpTTL {
+		e.SetBumpTTL(config.BumpTTL)
+		changes++
+	}
+
+	if e.GetServer().GetCachePost() != config.CachePost {
+		e.GetServer().SetCachePost(config.CachePost)
+		changes++
+	}
+
+	if e.GetAutoEnqueueInterval() != config.AutoEnqueueInterval {
+		restarts = append(restarts, "auto-refresh")
+	}
+
+	if e.GetRole() != config.Role {
+		restarts = append(restarts, "role")
+	}
+
+	if e.crawler.GetClientTimeout() != config.HttpTimeout && config.Role != RoleServeOnly {
+		restarts = append(restarts, "http-timeout")
 	}
 
 	{
-		if config.Port > ConfigDefaultPort {
-			e.Mirror(nil, int(config.Port))
+		cacherObj := e.GetCacher()
+		if len(config.Cacher.Path) > 0 && cacherObj.GetPath() != config.Cacher.Path {
+			restarts = append(restarts, "cache-path")
 		}
+		if cacherObj.GetDefaultTTL() != config.Cacher.DefaultTTL {
+			cacherObj.SetDefaultTTL(config.Cacher.DefaultTTL)
+			changes++
+		}
+	}
 
-		if config.MirrorURLs != nil {
-			mirrorURLs := []*neturl.URL(config.MirrorURLs)
-			mirrorPorts := []int(config.MirrorPorts)
-			for i, url := range mirrorURLs {
-				port := -1
-				if i < len(mirrorPorts) {
-					port = mirrorPorts[i]
+	{
+		crawlerObj := e.GetCrawler()
+		if depth := uint64(config.Crawler.AutoDownloadDepth); crawlerObj.GetAutoDownloadDepth() != depth {
+			crawlerObj.SetAutoDownloadDepth(depth)
+			changes++
+		}
+		if crawlerObj.GetNoCrossHost() != config.Crawler.NoCrossHost {
+			crawlerObj.SetNoCrossHost(config.Crawler.NoCrossHost)
+			changes++
+		}
+		if crawlerObj.GetKeepCharset() != config.Crawler.KeepCharset {
+			crawlerObj.SetKeepCharset(config.Crawler.KeepCharset)
+			changes++
+		}
+		if crawlerObj.GetKeepEncoding() != config.Crawler.KeepEncoding {
+			crawlerObj.SetKeepEncoding(config.Crawler.KeepEncoding)
+			changes++
+		}
+		if sanitizeRules := config.Crawler.Sanitize; !reflect.DeepEqual(crawlerObj.GetSanitizeRules(), &sanitizeRules) {
+			crawlerObj.SetSanitizeRules(&sanitizeRules)
+			changes++
+		}
+		if injectRules := config.Crawler.Inject; !reflect.DeepEqual(crawlerObj.GetInjectRules(), &injectRules) {
+			crawlerObj.SetInjectRules(&injectRules)
+			changes++
+		}
+		if rules := []crawler.DOMRule(config.Crawler.DOMRules); !reflect.DeepEqual(crawlerObj.GetDOMRules(), rules) {
+			crawlerObj.SetDOMRules(rules)
+			changes++
+		}
+		if limit := uint64(config.Crawler.FormLimit); crawlerObj.GetFormLimit() != limit {
+			crawlerObj.SetFormLimit(limit)
+			changes++
+		}
+		if paths := []crawler.JSONPath(config.Crawler.JSONPaths); !reflect.DeepEqual(crawlerObj.GetJSONPaths(), paths) {
+			crawlerObj.SetJSONPaths(paths)
+			changes++
+		}
+		upstreams := crawlerObj.GetUpstreams()
+		if proxy, _ := config.Crawler.getProxy(); !reflect.DeepEqual(upstreams.List(), config.Upstreams) ||
+			!reflect.DeepEqual(upstreams.Proxy(), proxy) {
+			setCrawlerUpstreams(crawlerObj, config.Upstreams, proxy, e.logger)
+			changes++
+		}
+		if crawlerObj.GetWorkerCount() != uint64(config.Crawler.WorkerCount) {
+			restarts = append(restarts, "workers")
+		}
+	}
+
+	{
+		mirrors := config.getMirrors()
+		keys := make(map[string]bool)
+		for _, mirror := range mirrors {
+			var root *neturl.URL
+			if mirror.URL != nil {
+				root = buildMirrorRoot(mirror.URL)
+			}
+			keys[buildMirrorKey(root)] = true
+		}
+
+		e.mutex.Lock()
+		existing := make(map[string]*engineMirror)
+		for key, m := range e.mirrors {
+			existing[key] = m
+		}
+		e.mutex.Unlock()
+
+		for key, m := range existing {
+			if !keys[key] {
+				e.RemoveMirror(m.root)
+				changes++
+			}
+		}
+
+		for _, mirror := range mirrors {
+			var root *neturl.URL
+			if mirror.URL != nil {
+				root = buildMirrorRoot(mirror.URL)
+			}
+			options := mirror.getOptions()
+
+			if m, ok := existing[buildMirrorKey(root)]; ok {
+				if m.port == mirror.Port {
+					if !reflect.DeepEqual(m.options, *options) {
+						if e.addMirror(root, mirror.Port, options) == nil {
+							changes++
+						}
+					}
+					continue
 				}
 
-				e.Mirror(url, port)
+				e.RemoveMirror(root)
 			}
+
+			e.MirrorWithOptions(mirror.URL, mirror.Port, options)
+			changes++
 		}
 	}
 
-	return e
+	e.logger.WithFields(logrus.Fields{
+		"path":     config.Path,
+		"changes":  changes,
+		"restarts": restarts,
+	}).Info("Reloaded config")
+}
+
+func (config *Config) getMirrors() []ConfigMirror {
+	mirrors := make([]ConfigMirror, 0)
+
+	if config.Port > ConfigDefaultPort {
+		mirrors = append(mirrors, ConfigMirror{Port: int(config.Port)})
+	}
+
+	mirrorPorts := []int(config.MirrorPorts)
+	for i, url := range config.MirrorURLs {
+		port := int(ConfigDefaultPort)
+		if i < len(mirrorPorts) {
+			port = mirrorPorts[i]
+		}
+
+		mirrors = append(mirrors, ConfigMirror{URL: url, Port: port})
+	}
+
+	mirrors = append(mirrors, config.Mirrors...)
+	for i := range mirrors {
+		mirrors[i].Budget = mirrors[i].Budget.withDefaults(config.Budget)
+	}
+
+	return mirrors
+}
+
+func setCrawlerUpstreams(crawlerObj crawler.Crawler, list []crawler.Upstream, proxy crawler.Proxy, logger *logrus.Logger) {
+	upstreams, err := crawler.NewUpstreams(list, proxy)
+	if err != nil {
+		logger.WithField("error", err).Error("Upstreams cannot be loaded")
+		return
+	}
+
+	crawlerObj.SetUpstreams(upstreams)
+}
+
+// getProxy returns the global proxy of the crawler
+func (c *configCrawler) getProxy() (crawler.Proxy, error) {
+	proxy := crawler.Proxy{Bypass: []string(c.ProxyBypass)}
+	if len(c.Proxy) == 0 {
+		if len(proxy.Bypass) > 0 {
+			return proxy, errors.New("proxy bypass cannot be used without proxy")
+		}
+		return proxy, nil
+	}
+
+	proxyURL, err := crawler.ParseProxyURL(c.Proxy)
+	if err != nil {
+		return proxy, err
+	}
+	proxy.URL = proxyURL
+
+	return proxy, nil
+}
+
+func (mirror *ConfigMirror) getOptions() *MirrorOptions {
+	return &MirrorOptions{
+		AutoDownloadDepth: mirror.AutoDownloadDepth,
+		CacheTTL:          mirror.CacheTTL,
+		RequestHeader:     mirror.RequestHeader,
+		HostRewrites:      mirror.HostRewrites,
+		HostsWhitelist:    mirror.HostsWhitelist,
+		CrawlRules:        mirror.CrawlRules,
+		RewriteRules:      mirror.RewriteRules,
+		HeaderRules:       mirror.HeaderRules,
+		SanitizeRules:     mirror.SanitizeRules,
+		InjectRules:       mirror.InjectRules,
+		DOMRules:          mirror.DOMRules,
+		FormLimit:         mirror.FormLimit,
+		Schedules:         mirror.Schedules,
+		CookieJar:         mirror.CookieJar,
+		Login:             mirror.Login,
+		Budget:            mirror.Budget,
+	}
+}
+
+// parse returns the Login, errors start with the path of the invalid field
+func (fileLogin *configFileLogin) parse() (*Login, error) {
+	login := &Login{}
+
+	if len(fileLogin.LoggedOut) > 0 {
+		loggedOut, err := regexp.Compile(fileLogin.LoggedOut)
+		if err != nil {
+			return nil, fmt.Errorf(".logged-out: %v", err)
+		}
+		login.LoggedOut = loggedOut
+	}
+
+	if len(fileLogin.Steps) == 0 {
+		return nil, errors.New(".steps: at least one step is required")
+	}
+
+	for j, fileStep := range fileLogin.Steps {
+		parsedURL, err := neturl.Parse(fileStep.URL)
+		if err != nil || !parsedURL.IsAbs() {
+			return nil, fmt.Errorf(".steps[%d]: invalid url %q", j, fileStep.URL)
+		}
+
+		method := strings.ToUpper(fileStep.Method)
+		if len(method) > 0 && method != "GET" && method != "POST" {
+			return nil, fmt.Errorf(".steps[%d]: method must be GET or POST", j)
+		}
+
+		login.Steps = append(login.Steps, LoginStep{
+			URL:    parsedURL,
+			Method: method,
+			Form:   fileStep.Form,
+		})
+	}
+
+	return login, nil
+}
+
+func (f *configCanonicalOptions) String() string {
+	return strings.Join((*cacher.CanonicalRules)(f).GetOptions(), ",")
+}
+
+func (f *configCanonicalOptions) Set(value string) error {
+	return (*cacher.CanonicalRules)(f).SetOptions(strings.Split(value, ","))
+}
+
+func (f *configSanitizeOptions) String() string {
+	return strings.Join((*crawler.SanitizeRules)(f).GetOptions(), ",")
+}
+
+func (f *configSanitizeOptions) Set(value string) error {
+	return (*crawler.SanitizeRules)(f).SetOptions(strings.Split(value, ","))
+}
+
+func (f *configCrawlRuleSlice) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *configCrawlRuleSlice) Set(value string) error {
+	rule, err := ParseCrawlRule(value)
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, rule)
+	return nil
+}
+
+func (f *configRewriteRuleSlice) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *configRewriteRuleSlice) Set(value string) error {
+	rule, err := ParseRewriteRule(value)
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, rule)
+	return nil
+}
+
+func (f *configHeaderRuleSlice) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *configHeaderRuleSlice) Set(value string) error {
+	rule, err := ParseHeaderRule(value)
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, rule)
+	return nil
+}
+
+func (f *configDOMRuleSlice) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *configDOMRuleSlice) Set(value string) error {
+	rule, err := crawler.ParseDOMRule(value)
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, rule)
+	return nil
+}
+
+func (f *configJSONPathSlice) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *configJSONPathSlice) Set(value string) error {
+	path, err := crawler.ParseJSONPath(value)
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, path)
+	return nil
 }
 
 func (f *configHTTPHeader) String() string {
@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	neturl "net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/alphagov/spotlight-gel/cacher"
+)
+
+// engineCookieJar is a http.CookieJar that keeps a copy of the cookies it receives
+// and writes them to a file, so that sessions survive restarts
+type engineCookieJar struct {
+	fs     cacher.Fs
+	path   string
+	logger *logrus.Logger
+
+	jar     *cookiejar.Jar
+	mutex   sync.Mutex
+	entries map[string]engineCookieJarEntry
+}
+
+type engineCookieJarEntry struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+// newEngineCookieJar returns a jar loaded from path, an empty path keeps the cookies in memory only
+func newEngineCookieJar(fs cacher.Fs, path string, logger *logrus.Logger) *engineCookieJar {
+	jar, _ := cookiejar.New(nil)
+	j := &engineCookieJar{
+		fs:     fs,
+		path:   path,
+		logger: logger,
+
+		jar:     jar,
+		entries: make(map[string]engineCookieJarEntry),
+	}
+
+	if len(path) > 0 {
+		j.load()
+	}
+
+	return j
+}
+
+func (j *engineCookieJar) SetCookies(u *neturl.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+	if len(j.path) == 0 {
+		return
+	}
+
+	now := time.Now()
+	j.mutex.Lock()
+	for _, cookie := range cookies {
+		stored := *cookie
+		if stored.MaxAge > 0 {
+			// keep the deadline instead of the relative age
+			stored.Expires = now.Add(time.Duration(stored.MaxAge) * time.Second)
+			stored.MaxAge = 0
+		}
+
+		key := buildEngineCookieJarKey(u, &stored)
+		if stored.MaxAge < 0 || (!stored.Expires.IsZero() && stored.Expires.Before(now)) {
+			delete(j.entries, key)
+			continue
+		}
+		j.entries[key] = engineCookieJarEntry{URL: u.String(), Cookie: &stored}
+	}
+	j.mutex.Unlock()
+
+	j.save()
+}
+
+func (j *engineCookieJar) Cookies(u *neturl.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+func (j *engineCookieJar) load() {
+	f, err := j.fs.OpenFile(j.path, os.O_RDONLY, 0)
+	if err != nil {
+		j.logger.WithField("path", j.path).Debug("Cookie jar file cannot be opened")
+		return
+	}
+	defer f.Close()
+
+	var entries []engineCookieJarEntry
+	data, err := ioutil.ReadAll(f)
+	if err == nil {
+		err = json.Unmarshal(data, &entries)
+	}
+	if err != nil {
+		j.logger.WithFields(logrus.Fields{
+			"path":  j.path,
+			"error": err,
+		}).Error("Cookie jar file cannot be read")
+		return
+	}
+
+	now := time.Now()
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for _, entry := range entries {
+		u, err := neturl.Parse(entry.URL)
+		if err != nil || entry.Cookie == nil ||
+			(!entry.Cookie.Expires.IsZero() && entry.Cookie.Expires.Before(now)) {
+			continue
+		}
+
+		j.jar.SetCookies(u, []*http.Cookie{entry.Cookie})
+		j.entries[buildEngineCookieJarKey(u, entry.Cookie)] = entry
+	}
+
+	j.logger.WithFields(logrus.Fields{
+		"path":    j.path,
+		"cookies": len(j.entries),
+	}).Debug("Loaded cookie jar")
+}
+
+func (j *engineCookieJar) save() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	entries := make([]engineCookieJarEntry, 0, len(j.entries))
+	for _, entry := range j.entries {
+		entries = append(entries, entry)
+	}
+
+	data, err := json.Marshal(entries)
+	if err == nil {
+		err = cacher.MakeDir(j.fs, j.path)
+	}
+	if err == nil {
+		var f cacher.File
+		// the cookies hold session secrets, keep them private to the owner
+		f, err = j.fs.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err == nil {
+			_, err = f.Write(data)
+			f.Close()
+		}
+	}
+
+	if err != nil {
+		j.logger.WithFields(logrus.Fields{
+			"path":  j.path,
+			"error": err,
+		}).Error("Cookie jar file cannot be written")
+	}
+}
+
+func buildEngineCookieJarKey(u *neturl.URL, cookie *http.Cookie) string {
+	return u.Host + "|" + cookie.Domain + "|" + cookie.Path + "|" + cookie.Name
+}
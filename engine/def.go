@@ -3,6 +3,7 @@ package engine
 import (
 	"net/http"
 	"net/url"
+	"regexp"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -20,18 +21,124 @@ type Engine interface {
 	GetServer() web.Server
 
 	AddHostRewrite(string, string)
+	RemoveHostRewrite(string)
 	GetHostRewrites() map[string]string
 	AddHostWhitelisted(string)
+	RemoveHostWhitelisted(string)
 	GetHostsWhitelist() []string
+	SetCrawlRules([]CrawlRule)
+	GetCrawlRules() []CrawlRule
+	SetRewriteRules([]RewriteRule)
+	GetRewriteRules() []RewriteRule
+	SetHeaderRules([]HeaderRule)
+	GetHeaderRules() []HeaderRule
+	SetCanonicalRules(*cacher.CanonicalRules)
+	GetCanonicalRules() *cacher.CanonicalRules
+	SetRole(Role)
+	GetRole() Role
 	SetBumpTTL(time.Duration)
 	GetBumpTTL() time.Duration
 	SetAutoEnqueueInterval(time.Duration)
 	GetAutoEnqueueInterval() time.Duration
+	SetLoggerLevel(logrus.Level)
 
 	Mirror(*url.URL, int) error
+	MirrorWithOptions(*url.URL, int, *MirrorOptions) error
+	RemoveMirror(*url.URL) error
+	GetMirrorSchedules(*url.URL) ([]MirrorScheduleStatus, error)
+	RefreshMirror(*url.URL, MirrorSchedule) error
+	GetMirrorBudget(*url.URL) (CrawlBudgetStatus, error)
+	Reload(*Config)
 	Stop()
 }
 
+// MirrorOptions represents settings that apply to a single mirror root,
+// they take precedence over the engine wide settings
+type MirrorOptions struct {
+	AutoDownloadDepth *uint64
+	CacheTTL          time.Duration
+	RequestHeader     http.Header
+	HostRewrites      map[string]string
+	HostsWhitelist    []string
+	CrawlRules        []CrawlRule
+	RewriteRules      []RewriteRule
+	HeaderRules       []HeaderRule
+	SanitizeRules     *crawler.SanitizeRules
+	InjectRules       *crawler.InjectRules
+	DOMRules          []crawler.DOMRule
+	FormLimit         *uint64
+	Schedules         []MirrorSchedule
+	// CookieJar is the file keeping the cookies of the mirror,
+	// cookies are kept in memory if it is empty and Login is set
+	CookieJar string
+	Login     *Login
+	Budget    CrawlBudget
+}
+
+// Login represents the requests sent to log in before a mirror is crawled
+type Login struct {
+	Steps []LoginStep
+	// LoggedOut matches the bodies or redirect locations of responses served to logged out visitors,
+	// the login runs again when it is found, nil logs in once
+	LoggedOut *regexp.Regexp
+}
+
+// LoginStep represents a single login request
+type LoginStep struct {
+	URL *url.URL
+	// Method is GET or POST, POST is used by default if Form is set
+	Method string
+	// Form holds the fields sent in the body of a POST request or the query of a GET request,
+	// values may refer to environment variables with $NAME or ${NAME}
+	Form map[string]string
+}
+
+// MirrorSchedule represents a recurring refresh of a mirror
+type MirrorSchedule struct {
+	// Spec is a cron expression, descriptors such as @daily or @every 1h are supported
+	Spec string
+	// Depth limits the links being downloaded again, nil means the mirror depth
+	Depth *uint64
+	// NearExpiry only refreshes cached urls expiring within the duration instead of crawling from the root
+	NearExpiry time.Duration
+	// Jitter delays each run by a random duration up to this value
+	Jitter time.Duration
+}
+
+// MirrorScheduleStatus represents the state of a mirror schedule
+type MirrorScheduleStatus struct {
+	Schedule MirrorSchedule
+	LastRun  time.Time
+	NextRun  time.Time
+}
+
+// CrawlBudget represents the limits of a mirror crawl, zero means no limit.
+// The crawl starts when the mirror is added and again on each refresh,
+// no more urls are queued once a limit is hit
+type CrawlBudget struct {
+	// MaxURLs limits the distinct urls queued under the root
+	MaxURLs uint64
+	// MaxBytes limits the total size of the downloaded bodies
+	MaxBytes uint64
+	// MaxDuration limits the time since the crawl started
+	MaxDuration time.Duration
+	// MaxObjectBytes limits the size of each body, larger responses are not cached
+	MaxObjectBytes uint64
+}
+
+// CrawlBudgetStatus represents the counters of the current crawl of a mirror
+type CrawlBudgetStatus struct {
+	Budget  CrawlBudget
+	Started time.Time
+	// URLs is the number of urls queued, each url is only counted once if MaxURLs is set
+	URLs  uint64
+	Bytes uint64
+	// Skipped is the number of responses larger than MaxObjectBytes
+	Skipped uint64
+	// Exceeded is the limit that stopped the crawl, empty while within budget
+	Exceeded string
+}
+
 var (
 	ResponseBodyMethodNotAllowed = "Sorry, your request is not supported and cannot be processed."
 	ResponseBad                  = "Sorry, cache miss"
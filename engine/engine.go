@@ -1,8 +1,11 @@
 package engine
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	neturl "net/url"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -18,12 +21,20 @@ type engine struct {
 	logger *logrus.Logger
 	mutex  sync.Mutex
 
+	fs         cacher.Fs
+	httpClient *http.Client
+
 	cacher  cacher.Cacher
 	crawler crawler.Crawler
 	server  web.Server
 
+	role                Role
 	hostRewrites        map[string]engineHostRewrite
 	hostsWhitelist      []string
+	crawlRules          []CrawlRule
+	rewriteRules        []RewriteRule
+	headerRules         []HeaderRule
+	mirrors             map[string]*engineMirror
 	bumpTTL             time.Duration
 	autoEnqueueInterval time.Duration
 
@@ -36,6 +47,23 @@ type engine struct {
 
 type engineHostRewrite func(*neturl.URL) string
 
+type engineMirror struct {
+	root         *neturl.URL
+	port         int
+	options      MirrorOptions
+	hostRewrites map[string]engineHostRewrite
+	closer       io.Closer
+	schedules    []*engineSchedule
+	client       *http.Client
+	jar          *engineCookieJar
+	login        *engineLogin
+	budget       *engineBudget
+
+	refreshMutex sync.Mutex
+	refreshDepth uint64
+	refreshed    map[string]bool
+}
+
 // New returns a new Engine instance
 func New(fs cacher.Fs, httpClient *http.Client, logger *logrus.Logger) Engine {
 	e := &engine{}
@@ -49,38 +77,101 @@ func (e *engine) init(fs cacher.Fs, httpClient *http.Client, logger *logrus.Logg
 	}
 	e.logger = logger
 
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	e.fs = fs
+	e.httpClient = httpClient
+
 	e.cacher = cacher.NewHTTPCacher(fs, logger)
 	e.crawler = crawler.New(httpClient, logger)
 	e.server = web.NewServer(e.cacher, logger)
 
+	e.role = RoleAll
 	e.bumpTTL = time.Minute
 
 	e.stopped = abool.New()
 	e.downloadedSomething = make(chan interface{})
 
 	e.crawler.SetURLRewriter(func(u *neturl.URL) {
-		e.rewriteURL(u)
+		e.rewriteURL(nil, u)
+	})
+
+	e.crawler.SetInputRewriter(func(input *crawler.Input) {
+		m := e.getMirror(input.Root)
+		if m == nil {
+			return
+		}
+
+		if m.options.RequestHeader != nil {
+			header := make(http.Header)
+			for headerKey, headerValues := range input.Header {
+				header[headerKey] = append([]string(nil), headerValues...)
+			}
+			for headerKey, headerValues := range m.options.RequestHeader {
+				header[http.CanonicalHeaderKey(headerKey)] = headerValues
+			}
+			input.Header = header
+		}
+
+		if m.options.SanitizeRules != nil {
+			input.SanitizeRules = m.options.SanitizeRules
+		}
+		if m.options.InjectRules != nil {
+			input.InjectRules = m.options.InjectRules
+		}
+		if m.options.DOMRules != nil {
+			input.DOMRules = m.options.DOMRules
+		}
+		if m.options.FormLimit != nil {
+			input.FormLimit = *m.options.FormLimit
+		}
+		input.MaxBodyBytes = m.options.Budget.MaxObjectBytes
+		if m.client != nil {
+			input.Client = m.client
+		}
+		if m.login != nil {
+			m.login.ensure(input.Header, input.Upstreams)
+		}
+
+		rewriter := func(u *neturl.URL) {
+			e.rewriteURL(m, u)
+		}
+		input.Rewriter = &rewriter
 	})
 
 	e.crawler.SetOnURLShouldQueue(func(u *neturl.URL) bool {
-		if e.crawler.GetNoProxy() {
+		if !e.GetRole().Has(RoleCrawl) {
 			e.logger.WithField("url", u).Debug("Ignoring queuing")
 			return false
 		}
-		if !e.checkHostWhitelisted(u.Host) {
+
+		return true
+	})
+
+	e.crawler.SetOnItemShouldQueue(func(item crawler.QueueItem) bool {
+		m := e.getMirror(item.Root)
+		if !e.checkHostWhitelisted(m, item.URL.Host) {
 			e.logger.WithFields(logrus.Fields{
-				"host": u.Host,
-				"list": e.hostsWhitelist,
+				"host": item.URL.Host,
+				"root": item.Root,
 			}).Debug("Host is not whitelisted")
 			return false
 		}
+		if !e.checkCrawlRules(m, item.URL) {
+			return false
+		}
+		if m != nil && !item.OnDemand && !m.budget.queue(item.URL) {
+			e.logger.WithField("url", item.URL).Debug("Crawl budget exceeded")
+			return false
+		}
 
 		return true
 	})
 
-	e.crawler.SetOnURLShouldDownload(func(u *neturl.URL) bool {
-		if e.crawler.GetNoProxy() {
-			e.logger.WithField("url", u).Debug("Cache miss and proxy disabled so will not download")
+	shouldDownloadURL := func(u *neturl.URL) bool {
+		if !e.GetRole().Has(RoleCrawl) {
+			e.logger.WithField("url", u).Debug("Crawling disabled so will not download")
 			return false
 		}
 		if e.cacher.CheckCacheExists(u) {
@@ -88,12 +179,30 @@ func (e *engine) init(fs cacher.Fs, httpClient *http.Client, logger *logrus.Logg
 			return false
 		}
 
+		return true
+	}
+	e.crawler.SetOnURLShouldDownload(shouldDownloadURL)
+
+	e.crawler.SetOnItemShouldDownload(func(item crawler.QueueItem) bool {
+		m := e.getMirror(item.Root)
+		if m != nil && !item.OnDemand && !m.budget.allows() {
+			e.logger.WithField("url", item.URL).Debug("Crawl budget exceeded so will not download")
+			return false
+		}
+		if item.Refresh {
+			// refresh items skip the url hook
+			if m != nil && m.shouldRefresh(item) {
+				return e.GetRole().Has(RoleCrawl)
+			}
+			return shouldDownloadURL(item.URL)
+		}
+
 		return true
 	})
 
 	e.crawler.SetOnDownloaded(func(downloaded *crawler.Downloaded) {
 		if (downloaded.StatusCode == 0 || downloaded.StatusCode >= 500) &&
-			e.cacher.CheckCacheExists(downloaded.Input.URL) {
+			e.cacher.CheckCacheExists(BuildCacheURL(downloaded.Input.URL, downloaded.Input.Post)) {
 			e.logger.WithFields(logrus.Fields{
 				"url":        downloaded.Input.URL,
 				"statusCode": downloaded.StatusCode,
@@ -101,7 +210,42 @@ func (e *engine) init(fs cacher.Fs, httpClient *http.Client, logger *logrus.Logg
 			return
 		}
 
+		m := e.getMirror(downloaded.Input.Root)
+		if m != nil && downloaded.Error == crawler.ErrBodyTooLarge {
+			m.budget.skip(downloaded.Input.URL)
+			return
+		}
+		if m != nil && m.login != nil {
+			if m.login.isLoggedOut(downloaded) {
+				retry := m.login.retry(downloaded.Input.URL)
+				e.logger.WithFields(logrus.Fields{
+					"url":   downloaded.Input.URL,
+					"retry": retry,
+				}).Warn("Skipped writing cache of logged out response")
+
+				if retry {
+					e.crawler.Enqueue(crawler.QueueItem{
+						URL:           downloaded.Input.URL,
+						ForceDownload: true,
+						Root:          downloaded.Input.Root,
+						OnDemand:      downloaded.Input.OnDemand,
+						Post:          downloaded.Input.Post,
+					})
+				}
+				return
+			}
+			m.login.downloaded(downloaded.Input.URL)
+		}
+
+		e.rewriteDownloaded(m, downloaded)
+
 		input := BuildCacherInputFromCrawlerDownloaded(downloaded)
+		if m != nil {
+			input.TTL = m.options.CacheTTL
+			if !downloaded.Input.OnDemand {
+				m.budget.downloaded(len(downloaded.Body))
+			}
+		}
 		e.cacher.Write(input)
 
 		e.mutex.Lock()
@@ -115,30 +259,39 @@ func (e *engine) init(fs cacher.Fs, httpClient *http.Client, logger *logrus.Logg
 	})
 
 	downloadAndServe := func(issue *web.ServerIssue) {
-		e.cacher.WritePlaceholder(issue.URL, e.bumpTTL)
+		e.cacher.WritePlaceholder(BuildCacheURL(issue.URL, issue.Post), e.bumpTTL)
 		downloaded := e.crawler.Download(crawler.QueueItem{
 			URL:           issue.URL,
 			ForceDownload: true,
+			Root:          e.findMirrorRoot(issue.URL),
+			OnDemand:      true,
+			Post:          issue.Post,
 		})
 		web.ServeDownloaded(downloaded, issue.Info)
 	}
 	e.server.SetOnServerIssue(func(issue *web.ServerIssue) {
-		if e.GetCrawler().GetNoProxy() {
-			issue.Info.WriteBody([]byte(ResponseBad))
-			return
-		}
+		role := e.GetRole()
 		switch issue.Type {
 		case web.MethodNotAllowed:
 			issue.Info.WriteBody([]byte(ResponseBodyMethodNotAllowed))
-		case web.CacheNotFound:
-			downloadAndServe(issue)
-		case web.CacheError:
+		case web.CacheNotFound, web.CacheError:
+			if !role.Has(RoleProxy) {
+				issue.Info.WriteBody([]byte(ResponseBad))
+				return
+			}
 			downloadAndServe(issue)
 		case web.CacheExpired:
-			e.cacher.Bump(issue.URL, e.bumpTTL)
+			if !role.Has(RoleCrawl) && !role.Has(RoleProxy) {
+				// the stale cache has been served already
+				return
+			}
+			e.cacher.Bump(BuildCacheURL(issue.URL, issue.Post), e.bumpTTL)
 			e.crawler.Enqueue(crawler.QueueItem{
 				URL:           issue.URL,
 				ForceDownload: true,
+				Root:          e.findMirrorRoot(issue.URL),
+				OnDemand:      true,
+				Post:          issue.Post,
 			})
 		}
 	})
@@ -164,30 +317,29 @@ func (e *engine) AddHostRewrite(from string, to string) {
 		e.hostRewrites = make(map[string]engineHostRewrite)
 	}
 
-	var parsedTo *neturl.URL
-	if strings.HasPrefix(to, "http") {
-		parsedTo, _ = neturl.Parse(to)
-	}
+	e.hostRewrites[from] = newEngineHostRewrite(to)
 
-	e.hostRewrites[from] = func(url *neturl.URL) string {
-		if url != nil {
-			if parsedTo != nil {
-				url.Scheme = parsedTo.Scheme
-				url.Host = parsedTo.Host
-				url.Path = strings.TrimRight(parsedTo.Path, "/") + url.Path
-			} else {
-				url.Host = to
-			}
-		}
+	e.logger.WithFields(logrus.Fields{
+		"from":     from,
+		"to":       to,
+		"mappings": len(e.hostRewrites),
+	}).Info("Added host rewrite")
+}
 
-		return to
+func (e *engine) RemoveHostRewrite(from string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, ok := e.hostRewrites[from]; !ok {
+		return
 	}
 
+	delete(e.hostRewrites, from)
+
 	e.logger.WithFields(logrus.Fields{
 		"from":     from,
-		"to":       to,
 		"mappings": len(e.hostRewrites),
-	}).Info("Added host rewrite")
+	}).Info("Removed host rewrite")
 }
 
 func (e *engine) GetHostRewrites() map[string]string {
@@ -233,21 +385,112 @@ func (e *engine) AddHostWhitelisted(host string) {
 	}).Info("Added host into whitelist")
 }
 
-func (e *engine) GetHostsWhitelist() []string {
+func (e *engine) RemoveHostWhitelisted(host string) {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
-	hostsWhitelist := make([]string, len(e.hostsWhitelist))
-	if e.hostsWhitelist != nil {
-		i := 0
-		for _, host := range e.hostsWhitelist {
-			hostsWhitelist[i] = host
+	for i, hostWhitelist := range e.hostsWhitelist {
+		if hostWhitelist == host {
+			e.hostsWhitelist = append(e.hostsWhitelist[:i:i], e.hostsWhitelist[i+1:]...)
+			if len(e.hostsWhitelist) == 0 {
+				e.hostsWhitelist = nil
+			}
+
+			e.logger.WithFields(logrus.Fields{
+				"host": host,
+				"list": e.hostsWhitelist,
+			}).Info("Removed host from whitelist")
+
+			return
 		}
 	}
+}
+
+func (e *engine) GetHostsWhitelist() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	hostsWhitelist := make([]string, len(e.hostsWhitelist))
+	copy(hostsWhitelist, e.hostsWhitelist)
 
 	return hostsWhitelist
 }
 
+func (e *engine) SetCrawlRules(rules []CrawlRule) {
+	e.mutex.Lock()
+	e.crawlRules = append([]CrawlRule(nil), rules...)
+	e.mutex.Unlock()
+
+	e.logger.WithField("rules", rules).Info("Updated crawl rules")
+}
+
+func (e *engine) GetCrawlRules() []CrawlRule {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return append([]CrawlRule(nil), e.crawlRules...)
+}
+
+func (e *engine) SetRewriteRules(rules []RewriteRule) {
+	e.mutex.Lock()
+	e.rewriteRules = append([]RewriteRule(nil), rules...)
+	e.mutex.Unlock()
+
+	e.logger.WithField("rules", rules).Info("Updated rewrite rules")
+}
+
+func (e *engine) GetRewriteRules() []RewriteRule {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return append([]RewriteRule(nil), e.rewriteRules...)
+}
+
+func (e *engine) SetHeaderRules(rules []HeaderRule) {
+	e.mutex.Lock()
+	e.headerRules = append([]HeaderRule(nil), rules...)
+	e.mutex.Unlock()
+
+	e.logger.WithField("rules", rules).Info("Updated header rules")
+}
+
+func (e *engine) GetHeaderRules() []HeaderRule {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return append([]HeaderRule(nil), e.headerRules...)
+}
+
+func (e *engine) SetCanonicalRules(rules *cacher.CanonicalRules) {
+	// the server relies on the rules of its cacher
+	e.cacher.SetCanonicalRules(rules)
+	e.crawler.SetCanonicalRules(rules)
+}
+
+func (e *engine) GetCanonicalRules() *cacher.CanonicalRules {
+	return e.cacher.GetCanonicalRules()
+}
+
+func (e *engine) SetRole(role Role) {
+	e.mutex.Lock()
+	old := e.role
+	e.role = role
+	e.mutex.Unlock()
+
+	e.logger.WithFields(logrus.Fields{
+		"old": old,
+		"new": role,
+	}).Info("Updated engine role")
+}
+
+func (e *engine) GetRole() Role {
+	e.mutex.Lock()
+	role := e.role
+	e.mutex.Unlock()
+
+	return role
+}
+
 func (e *engine) SetBumpTTL(ttl time.Duration) {
 	e.mutex.Lock()
 	e.bumpTTL = ttl
@@ -276,40 +519,112 @@ func (e *engine) GetAutoEnqueueInterval() time.Duration {
 	return interval
 }
 
+func (e *engine) SetLoggerLevel(level logrus.Level) {
+	old := e.logger.Level
+	e.logger.SetLevel(level)
+
+	e.logger.WithFields(logrus.Fields{
+		"old": old,
+		"new": level,
+	}).Info("Updated engine logger level")
+}
+
 func (e *engine) Mirror(url *neturl.URL, port int) error {
+	return e.MirrorWithOptions(url, port, nil)
+}
+
+func (e *engine) MirrorWithOptions(url *neturl.URL, port int, options *MirrorOptions) error {
 	var root *neturl.URL
 
 	if url != nil {
-		root, _ = neturl.Parse(url.String())
-		if len(root.Path) == 0 {
-			root.Path = "/"
-		}
+		root = buildMirrorRoot(url)
+	}
+
+	if options == nil {
+		options = &MirrorOptions{}
+	}
+	if err := e.addMirror(root, port, options); err != nil {
+		return err
+	}
 
+	role := e.GetRole()
+	if root != nil && role.Has(RoleCrawl) {
 		e.autoEnqueue(root)
-		e.crawler.Enqueue(crawler.QueueItem{URL: root})
+		e.crawler.Enqueue(crawler.QueueItem{URL: root, Root: root})
 	}
 
-	if port < 0 {
+	if port < 0 || !role.Has(RoleServe) {
 		return nil
 	}
 
-	if e.GetCrawler().GetNoProxy() {
-		_, err := e.server.ListenAndServe(root, port)
+	closer, err := e.server.ListenAndServe(root, port)
+	if err == nil {
+		e.mutex.Lock()
+		if m, ok := e.mirrors[buildMirrorKey(root)]; ok {
+			m.closer = closer
+		}
+		e.mutex.Unlock()
+	}
 
-		loggerContext := e.logger.WithFields(logrus.Fields{
-			"url":  url,
-			"port": port,
-			"root": root,
-		})
-		if err != nil {
-			loggerContext.Error("Mirror cannot be setup")
-		} else {
-			loggerContext.Info("Mirror is up")
+	loggerContext := e.logger.WithFields(logrus.Fields{
+		"url":  url,
+		"port": port,
+		"root": root,
+	})
+	if err != nil {
+		loggerContext.Error("Mirror cannot be setup")
+	} else {
+		loggerContext.Info("Mirror is up")
+	}
+
+	return err
+}
+
+func (e *engine) RemoveMirror(url *neturl.URL) error {
+	var root *neturl.URL
+	if url != nil {
+		root = buildMirrorRoot(url)
+	}
+	key := buildMirrorKey(root)
+
+	e.mutex.Lock()
+	m, ok := e.mirrors[key]
+	if ok {
+		delete(e.mirrors, key)
+	}
+	e.mutex.Unlock()
+
+	if !ok {
+		return errors.New("mirror not found")
+	}
+
+	m.stopSchedules()
+
+	if root != nil {
+		e.crawler.RemoveRootAutoDownloadDepth(root)
+
+		e.autoEnqueueMutex.Lock()
+		for i, autoEnqueueURL := range e.autoEnqueueUrls {
+			if autoEnqueueURL.String() == key {
+				e.autoEnqueueUrls = append(e.autoEnqueueUrls[:i:i], e.autoEnqueueUrls[i+1:]...)
+				break
+			}
 		}
+		e.autoEnqueueMutex.Unlock()
+	}
 
-		return err
+	var err error
+	if m.closer != nil {
+		err = m.closer.Close()
 	}
-	return nil
+
+	e.logger.WithFields(logrus.Fields{
+		"root":  root,
+		"port":  m.port,
+		"error": err,
+	}).Info("Removed mirror")
+
+	return err
 }
 
 func (e *engine) Stop() {
@@ -359,9 +674,13 @@ func (e *engine) autoEnqueue(url *neturl.URL) {
 
 				e.autoEnqueueMutex.Lock()
 				for _, url := range e.autoEnqueueUrls {
+					if m := e.getMirror(url); m != nil {
+						m.budget.reset()
+					}
 					e.GetCrawler().Enqueue(crawler.QueueItem{
 						URL:           url,
 						ForceDownload: true,
+						Root:          url,
 					})
 					e.logger.WithField("url", url).Debug("Engine.autoEnqueue enqueued")
 				}
@@ -371,11 +690,149 @@ func (e *engine) autoEnqueue(url *neturl.URL) {
 	})
 }
 
-func (e *engine) rewriteURL(url *neturl.URL) {
+func (e *engine) addMirror(root *neturl.URL, port int, options *MirrorOptions) error {
+	schedules, err := newEngineSchedules(options.Schedules)
+	if err != nil {
+		e.logger.WithFields(logrus.Fields{
+			"root":  root,
+			"error": err,
+		}).Error("Mirror schedules cannot be parsed")
+		return err
+	}
+
+	m := &engineMirror{
+		root:      root,
+		port:      port,
+		options:   *options,
+		schedules: schedules,
+		budget:    newEngineBudget(root, options.Budget, e.logger),
+	}
+
+	if len(options.CookieJar) > 0 || options.Login != nil {
+		m.jar = newEngineCookieJar(e.fs, options.CookieJar, e.logger)
+		m.client = &http.Client{
+			Transport: e.httpClient.Transport,
+			Timeout:   e.httpClient.Timeout,
+			Jar:       m.jar,
+		}
+		if options.Login != nil {
+			m.login = newEngineLogin(options.Login, m.client, e.logger)
+		}
+	}
+
+	if options.HostRewrites != nil {
+		m.hostRewrites = make(map[string]engineHostRewrite)
+		for from, to := range options.HostRewrites {
+			m.hostRewrites[from] = newEngineHostRewrite(to)
+		}
+	}
+
+	if root != nil {
+		if options.AutoDownloadDepth != nil {
+			e.crawler.SetRootAutoDownloadDepth(root, *options.AutoDownloadDepth)
+		} else {
+			e.crawler.RemoveRootAutoDownloadDepth(root)
+		}
+	}
+
+	e.mutex.Lock()
+	if e.mirrors == nil {
+		e.mirrors = make(map[string]*engineMirror)
+	}
+	key := buildMirrorKey(root)
+	existing, ok := e.mirrors[key]
+	if ok && existing.port == port {
+		m.closer = existing.closer
+	}
+	if ok && existing.options.CookieJar == options.CookieJar && reflect.DeepEqual(existing.options.Login, options.Login) {
+		// keep the session
+		m.client, m.jar, m.login = existing.client, existing.jar, existing.login
+	}
+	if ok {
+		// keep the counters of the current crawl
+		m.budget = existing.budget
+		m.budget.setOptions(options.Budget)
+	}
+	e.mirrors[key] = m
+	e.mutex.Unlock()
+
+	if ok {
+		existing.stopSchedules()
+	}
+	e.startSchedules(m)
+
+	e.logger.WithFields(logrus.Fields{
+		"root":    root,
+		"port":    port,
+		"options": options,
+	}).Debug("Added mirror")
+
+	return nil
+}
+
+func (e *engine) getMirror(root *neturl.URL) *engineMirror {
+	if root == nil {
+		return nil
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.mirrors == nil {
+		return nil
+	}
+
+	return e.mirrors[buildMirrorKey(root)]
+}
+
+// findMirrorRoot returns the root of the mirror with the longest path prefix of the url,
+// urls outside of the root paths of their host belong to the mirror with the shortest root
+func (e *engine) findMirrorRoot(url *neturl.URL) *neturl.URL {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var found, fallback *neturl.URL
+	for _, m := range e.mirrors {
+		if m.root == nil || m.root.Scheme != url.Scheme || m.root.Host != url.Host {
+			continue
+		}
+
+		if matchMirrorRootPath(m.root.Path, url.Path) && (found == nil || len(m.root.Path) > len(found.Path)) {
+			found = m.root
+		}
+		if fallback == nil || len(m.root.Path) < len(fallback.Path) ||
+			(len(m.root.Path) == len(fallback.Path) && m.root.Path < fallback.Path) {
+			fallback = m.root
+		}
+	}
+
+	if found == nil {
+		return fallback
+	}
+
+	return found
+}
+
+// matchMirrorRootPath returns true if the path is the root path or one of its sub paths
+func matchMirrorRootPath(rootPath string, path string) bool {
+	if !strings.HasPrefix(path, rootPath) {
+		return false
+	}
+
+	return len(path) == len(rootPath) || strings.HasSuffix(rootPath, "/") || path[len(rootPath)] == '/'
+}
+
+func (e *engine) rewriteURL(m *engineMirror, url *neturl.URL) {
 	e.mutex.Lock()
 	hostRewrites := e.hostRewrites
 	e.mutex.Unlock()
 
+	if m != nil && m.hostRewrites != nil {
+		if _, ok := m.hostRewrites[url.Host]; ok {
+			hostRewrites = m.hostRewrites
+		}
+	}
+
 	if hostRewrites == nil {
 		return
 	}
@@ -395,11 +852,15 @@ func (e *engine) rewriteURL(url *neturl.URL) {
 	}
 }
 
-func (e *engine) checkHostWhitelisted(host string) bool {
+func (e *engine) checkHostWhitelisted(m *engineMirror, host string) bool {
 	e.mutex.Lock()
 	hostsWhitelist := e.hostsWhitelist
 	e.mutex.Unlock()
 
+	if m != nil && m.options.HostsWhitelist != nil {
+		hostsWhitelist = m.options.HostsWhitelist
+	}
+
 	if hostsWhitelist == nil {
 		return true
 	}
@@ -413,9 +874,119 @@ func (e *engine) checkHostWhitelisted(host string) bool {
 	return false
 }
 
+func (e *engine) rewriteDownloaded(m *engineMirror, downloaded *crawler.Downloaded) {
+	e.mutex.Lock()
+	bodyRules := e.rewriteRules
+	headerRules := e.headerRules
+	e.mutex.Unlock()
+
+	if m != nil {
+		// mirror rules are applied first
+		if m.options.RewriteRules != nil {
+			bodyRules = append(append([]RewriteRule(nil), m.options.RewriteRules...), bodyRules...)
+		}
+		if m.options.HeaderRules != nil {
+			headerRules = append(append([]HeaderRule(nil), m.options.HeaderRules...), headerRules...)
+		}
+	}
+
+	if len(bodyRules) == 0 && len(headerRules) == 0 {
+		return
+	}
+
+	header := make(http.Header)
+	for _, headerKey := range downloaded.GetHeaderKeys() {
+		header[headerKey] = downloaded.GetHeaderValues(headerKey)
+		downloaded.DelHeader(headerKey)
+	}
+
+	downloaded.Body = ApplyRewriteRules(downloaded.Input.URL, header, downloaded.Body, bodyRules, headerRules)
+	for headerKey, headerValues := range header {
+		for _, headerValue := range headerValues {
+			downloaded.AddHeader(headerKey, headerValue)
+		}
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"url":         downloaded.Input.URL,
+		"bodyRules":   len(bodyRules),
+		"headerRules": len(headerRules),
+	}).Debug("Applied rewrite rules")
+}
+
+func (e *engine) checkCrawlRules(m *engineMirror, url *neturl.URL) bool {
+	e.mutex.Lock()
+	rules := e.crawlRules
+	e.mutex.Unlock()
+
+	if m != nil && m.options.CrawlRules != nil {
+		// mirror rules are evaluated first
+		rules = append(append([]CrawlRule(nil), m.options.CrawlRules...), rules...)
+	}
+
+	for i, rule := range rules {
+		if rule.Match(url) {
+			e.logger.WithFields(logrus.Fields{
+				"url":   url,
+				"rule":  rule,
+				"index": i,
+			}).Debug("Matched crawl rule")
+
+			return !rule.Exclude
+		}
+	}
+
+	return true
+}
+
+func buildMirrorRoot(url *neturl.URL) *neturl.URL {
+	root, _ := neturl.Parse(url.String())
+	if len(root.Path) == 0 {
+		root.Path = "/"
+	}
+
+	return root
+}
+
+func buildMirrorKey(root *neturl.URL) string {
+	if root == nil {
+		// cross-host mirror
+		return ""
+	}
+
+	return root.String()
+}
+
+func newEngineHostRewrite(to string) engineHostRewrite {
+	var parsedTo *neturl.URL
+	if strings.HasPrefix(to, "http") {
+		parsedTo, _ = neturl.Parse(to)
+	}
+
+	return func(url *neturl.URL) string {
+		if url != nil {
+			if parsedTo != nil {
+				url.Scheme = parsedTo.Scheme
+				url.Host = parsedTo.Host
+				url.Path = strings.TrimRight(parsedTo.Path, "/") + url.Path
+			} else {
+				url.Host = to
+			}
+		}
+
+		return to
+	}
+}
+
 func (e *engine) cleanUp() {
 	stoppedAtomicChange := e.stopped.SetToIf(false, true)
 	if stoppedAtomicChange {
+		e.mutex.Lock()
+		for _, m := range e.mirrors {
+			m.stopSchedules()
+		}
+		e.mutex.Unlock()
+
 		e.crawler.Stop()
 		e.server.Stop()
 
@@ -7,7 +7,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	neturl "net/url"
+	"os"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/alphagov/spotlight-gel/cacher"
@@ -244,6 +247,81 @@ var _ = Describe("Engine", func() {
 				Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
 			})
 
+			It("should download and cache post", func() {
+				urlRoot := "http://domain.com"
+				urlPath := "/engine/mirror/cache/post"
+				httpmock.RegisterResponder("GET", urlRoot+"/", httpmock.NewStringResponder(200, ""))
+				httpmock.RegisterResponder("POST", urlRoot+urlPath, func(req *http.Request) (*http.Response, error) {
+					body, _ := ioutil.ReadAll(req.Body)
+					return httpmock.NewStringResponse(200, "results for "+string(body)), nil
+				})
+
+				e := newEngine()
+				e.GetServer().SetCachePost(true)
+				mirrorURL(e, urlRoot+"/", 0)
+				defer e.Stop()
+
+				port, _ := e.GetServer().GetListeningPort("domain.com")
+				post := func(body string) string {
+					resp, _ := httpClient.Post(fmt.Sprintf("http://localhost:%d"+urlPath, port),
+						"application/x-www-form-urlencoded", strings.NewReader(body))
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+					respBody, _ := ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+					return string(respBody)
+				}
+
+				Expect(post("a=1&b=2")).To(Equal("results for a=1&b=2"))
+				Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
+
+				Expect(post("b=2&a=1")).To(Equal("results for a=1&b=2"))
+				Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
+
+				Expect(post("a=2")).To(Equal("results for a=2"))
+				Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Three))
+			})
+
+			It("should not serve post cache to get", func() {
+				urlRoot := "http://domain.com"
+				urlPath := "/engine/mirror/cache/post/get"
+				httpmock.RegisterResponder("GET", urlRoot+"/", httpmock.NewStringResponder(200, ""))
+				httpmock.RegisterResponder("GET", urlRoot+urlPath, httpmock.NewStringResponder(200, "get"))
+				httpmock.RegisterResponder("POST", urlRoot+urlPath, httpmock.NewStringResponder(200, "post"))
+				parsedURL, _ := neturl.Parse(urlRoot + urlPath)
+				postURL := cacher.BuildPostURL(parsedURL, "application/x-www-form-urlencoded", []byte("a=1"))
+
+				e := newEngine()
+				e.GetServer().SetCachePost(true)
+				mirrorURL(e, urlRoot+"/", 0)
+				defer e.Stop()
+
+				port, _ := e.GetServer().GetListeningPort("domain.com")
+				readBody := func(resp *http.Response) string {
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+					respBody, _ := ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+					return string(respBody)
+				}
+				post := func() string {
+					resp, _ := httpClient.Post(fmt.Sprintf("http://localhost:%d"+urlPath, port),
+						"application/x-www-form-urlencoded", strings.NewReader("a=1"))
+					return readBody(resp)
+				}
+
+				Expect(post()).To(Equal("post"))
+				Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
+
+				resp, _ := httpClient.Get(fmt.Sprintf("http://localhost:%d%s?%s", port, urlPath, postURL.RawQuery))
+				Expect(readBody(resp)).To(Equal("get"))
+				Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Three))
+				Expect(e.GetCacher().CheckCacheExists(parsedURL)).To(BeTrue())
+
+				Expect(post()).To(Equal("post"))
+				Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Three))
+			})
+
 			It("should requeue for cache expired", func() {
 				urlRoot := "http://domain.com"
 				urlPath := "/engine/mirror/cache/expired/should/requeue"
@@ -279,6 +357,398 @@ var _ = Describe("Engine", func() {
 		})
 	})
 
+	Describe("MirrorWithOptions", func() {
+		It("should use request header", func() {
+			url := "http://domain.com/engine/MirrorWithOptions/header"
+			parsedURL, _ := neturl.Parse(url)
+			headerValue := make(chan string, 1)
+			httpmock.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+				headerValue <- req.Header.Get("Key")
+				return httpmock.NewStringResponse(http.StatusOK, ""), nil
+			})
+			header := make(http.Header)
+			header.Set("Key", "Value")
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{RequestHeader: header})
+			defer e.Stop()
+
+			Expect(<-headerValue).To(Equal("Value"))
+		})
+
+		It("should use request header of the mirror with the longest root on demand", func() {
+			urlRoot := "http://domain.com"
+			urlPath := "/engine/MirrorWithOptions/roots"
+			headerValues := make(map[string]chan string)
+			e := newEngine()
+			defer e.Stop()
+			mirror := func(path string, port int) {
+				parsedURL, _ := neturl.Parse(urlRoot + urlPath + path)
+				httpmock.RegisterResponder("GET", parsedURL.String(), httpmock.NewStringResponder(http.StatusOK, ""))
+				headerValues[path] = make(chan string, 1)
+				httpmock.RegisterResponder("GET", parsedURL.String()+"/page", func(req *http.Request) (*http.Response, error) {
+					headerValues[path] <- req.Header.Get("Key")
+					return httpmock.NewStringResponse(http.StatusOK, ""), nil
+				})
+				header := make(http.Header)
+				header.Set("Key", path)
+
+				Expect(e.MirrorWithOptions(parsedURL, port, &MirrorOptions{RequestHeader: header})).To(Succeed())
+			}
+
+			mirror("/a", 0)
+			mirror("/a/b", -1)
+			mirror("/c", -1)
+
+			port, _ := e.GetServer().GetListeningPort("domain.com")
+			for _, path := range []string{"/a", "/a/b", "/c"} {
+				resp, _ := httpClient.Get(fmt.Sprintf("http://localhost:%d%s%s/page", port, urlPath, path))
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(<-headerValues[path]).To(Equal(path))
+			}
+
+			// outside of the roots, the shortest one is used
+			otherValue := make(chan string, 1)
+			httpmock.RegisterResponder("GET", urlRoot+urlPath+"/other", func(req *http.Request) (*http.Response, error) {
+				otherValue <- req.Header.Get("Key")
+				return httpmock.NewStringResponse(http.StatusOK, ""), nil
+			})
+			resp, _ := httpClient.Get(fmt.Sprintf("http://localhost:%d%s/other", port, urlPath))
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(<-otherValue).To(Equal("/a"))
+		})
+
+		It("should rewrite host", func() {
+			url0 := "http://domain.com/engine/MirrorWithOptions/rewrite/0"
+			parsedURL0, _ := neturl.Parse(url0)
+			url1Path := "/engine/MirrorWithOptions/rewrite/1"
+			html0 := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>", "http://other.domain.com"+url1Path))
+			httpmock.RegisterResponder("GET", url0, t.NewHTMLResponder(html0))
+			httpmock.RegisterResponder("GET", "http://domain.com"+url1Path, httpmock.NewStringResponder(http.StatusOK, ""))
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL0, -1, &MirrorOptions{
+				HostRewrites: map[string]string{"other.domain.com": "domain.com"},
+			})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
+			Expect(e.GetHostRewrites()).To(BeEmpty())
+		})
+
+		It("should use hosts whitelist", func() {
+			url0 := "http://domain.com/engine/MirrorWithOptions/whitelist/0"
+			parsedURL0, _ := neturl.Parse(url0)
+			url1 := "http://domain1.com/engine/MirrorWithOptions/whitelist/1"
+			html0 := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>", url1))
+			httpmock.RegisterResponder("GET", url0, t.NewHTMLResponder(html0))
+			httpmock.RegisterResponder("GET", url1, httpmock.NewStringResponder(http.StatusOK, ""))
+
+			e := newEngine()
+			e.AddHostWhitelisted("domain.com")
+			e.MirrorWithOptions(parsedURL0, -1, &MirrorOptions{
+				HostsWhitelist: []string{"domain.com", "domain1.com"},
+			})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
+		})
+
+		It("should use cache ttl", func() {
+			url := "http://domain.com/engine/MirrorWithOptions/cache/ttl"
+			parsedURL, _ := neturl.Parse(url)
+			httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, ""))
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{CacheTTL: 24 * time.Hour})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			f, err := e.GetCacher().Open(parsedURL)
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+
+			written, _ := ioutil.ReadAll(f)
+			Expect(string(written)).To(ContainSubstring("max-age=86400"))
+		})
+
+		It("should use inject rules", func() {
+			url := "http://domain.com/engine/MirrorWithOptions/inject"
+			parsedURL, _ := neturl.Parse(url)
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(t.NewHTMLMarkup("<body><p>Text</p></body>")))
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{
+				InjectRules: &crawler.InjectRules{Banner: "<p>Archived {{.OriginalURL}}</p>"},
+			})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			f, err := e.GetCacher().Open(parsedURL)
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+
+			written, _ := ioutil.ReadAll(f)
+			Expect(string(written)).To(ContainSubstring("<body><p>Archived " + url + "</p><p>Text</p></body>"))
+		})
+
+		It("should use dom rules", func() {
+			url := "http://domain.com/engine/MirrorWithOptions/dom"
+			parsedURL, _ := neturl.Parse(url)
+			html := t.NewHTMLMarkup(`<div class="cookie"><a href="/engine/MirrorWithOptions/dom/accept">Accept</a></div><p>Text</p>`)
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+			rule, _ := crawler.ParseDOMRule("remove .cookie")
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{DOMRules: []crawler.DOMRule{rule}})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			f, err := e.GetCacher().Open(parsedURL)
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+
+			written, _ := ioutil.ReadAll(f)
+			Expect(string(written)).To(ContainSubstring("<body><p>Text</p></body>"))
+			Expect(string(written)).ToNot(ContainSubstring("accept"))
+		})
+
+		It("should use form limit", func() {
+			url := "http://domain.com/engine/MirrorWithOptions/form"
+			html := t.NewHTMLMarkup(`<form action="/engine/MirrorWithOptions/form/search">` +
+				`<select name="year"><option>2019</option><option>2020</option><option>2021</option></select></form>`)
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+			httpmock.RegisterResponder("GET", "=~^http://domain\\.com/engine/MirrorWithOptions/form/search",
+				httpmock.NewStringResponder(200, ""))
+			parsedURL, _ := neturl.Parse(url)
+			limit := uint64Two
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{FormLimit: &limit})
+			defer e.Stop()
+
+			// the form action and the first two years, found once the page has been parsed
+			Eventually(e.GetCrawler().GetDownloadedCount).Should(Equal(uint64(4)))
+			Expect(e.GetCrawler().GetLinkFoundCount()).To(Equal(uint64Three))
+		})
+
+		It("should use rewrite and header rules", func() {
+			url := "http://domain.com/engine/MirrorWithOptions/rewrite.js"
+			parsedURL, _ := neturl.Parse(url)
+			httpmock.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+				resp := httpmock.NewStringResponse(200, `fetch("http://origin.com/a", "http://origin.com/b")`)
+				resp.Header.Set("Content-Type", "application/javascript")
+				resp.Header.Set("Set-Cookie", "session=1")
+				return resp, nil
+			})
+			mirrorRule, _ := ParseRewriteRule("type=application/javascript s|http://origin.com|https://www.domain.com|g")
+			globalRule, _ := ParseRewriteRule("s|https://www|https://archive|")
+			headerRule, _ := ParseHeaderRule("remove Set-Cookie")
+
+			e := newEngine()
+			e.SetRewriteRules([]RewriteRule{globalRule})
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{
+				RewriteRules: []RewriteRule{mirrorRule},
+				HeaderRules:  []HeaderRule{headerRule},
+			})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			f, err := e.GetCacher().Open(parsedURL)
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+
+			written, _ := ioutil.ReadAll(f)
+			Expect(string(written)).To(ContainSubstring(`fetch("https://archive.domain.com/a", "https://www.domain.com/b")`))
+			Expect(string(written)).To(ContainSubstring("application/javascript"))
+			Expect(string(written)).ToNot(ContainSubstring("Set-Cookie"))
+		})
+
+		It("should use sanitize rules", func() {
+			url := "http://domain.com/engine/MirrorWithOptions/sanitize"
+			parsedURL, _ := neturl.Parse(url)
+			html := t.NewHTMLMarkup(`<script src="/engine/MirrorWithOptions/sanitize.js"></script><p onclick="f()">Text</p>`)
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+
+			e := newEngine()
+			e.GetCrawler().SetSanitizeRules(&crawler.SanitizeRules{})
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{
+				SanitizeRules: &crawler.SanitizeRules{Scripts: true, EventAttrs: true},
+			})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64One))
+			f, err := e.GetCacher().Open(parsedURL)
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+
+			written, _ := ioutil.ReadAll(f)
+			Expect(string(written)).To(ContainSubstring(t.NewHTMLMarkup("<p>Text</p>")))
+		})
+	})
+
+	Describe("Login", func() {
+		const loginURL = "http://domain.com/engine/Login/login"
+
+		var (
+			logins     int
+			loginBody  string
+			loginMutex sync.Mutex
+		)
+
+		loginResponder := func(req *http.Request) (*http.Response, error) {
+			body, _ := ioutil.ReadAll(req.Body)
+
+			loginMutex.Lock()
+			logins++
+			loginBody = string(body)
+			session := logins
+			loginMutex.Unlock()
+
+			resp := httpmock.NewStringResponse(200, "")
+			resp.Header.Set("Set-Cookie", fmt.Sprintf("session=%d; Path=/", session))
+			return resp, nil
+		}
+
+		// sessionResponder serves the secret to the specified session only
+		sessionResponder := func(session string) httpmock.Responder {
+			return func(req *http.Request) (*http.Response, error) {
+				if cookie, err := req.Cookie("session"); err == nil && cookie.Value == session {
+					return httpmock.NewStringResponse(200, "secret"), nil
+				}
+
+				return httpmock.NewStringResponse(200, "Please sign in"), nil
+			}
+		}
+
+		getLogins := func() (int, string) {
+			loginMutex.Lock()
+			defer loginMutex.Unlock()
+
+			return logins, loginBody
+		}
+
+		newLogin := func() *Login {
+			loginParsedURL, _ := neturl.Parse(loginURL)
+			return &Login{
+				Steps: []LoginStep{{
+					URL:  loginParsedURL,
+					Form: map[string]string{"user": "me", "password": "$ENGINE_LOGIN_PASSWORD"},
+				}},
+				LoggedOut: regexp.MustCompile("Please sign in"),
+			}
+		}
+
+		readCache := func(e Engine, url *neturl.URL) string {
+			f, err := e.GetCacher().Open(url)
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+
+			written, _ := ioutil.ReadAll(f)
+			return string(written)
+		}
+
+		BeforeEach(func() {
+			logins = 0
+			loginBody = ""
+			os.Setenv("ENGINE_LOGIN_PASSWORD", "p@ss")
+			httpmock.RegisterResponder("POST", loginURL, loginResponder)
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("ENGINE_LOGIN_PASSWORD")
+		})
+
+		It("should log in before crawling", func() {
+			url := "http://domain.com/engine/Login/before"
+			parsedURL, _ := neturl.Parse(url)
+			httpmock.RegisterResponder("GET", url, sessionResponder("1"))
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{Login: newLogin()})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			count, body := getLogins()
+			Expect(count).To(Equal(1))
+			Expect(body).To(Equal("password=p%40ss&user=me"))
+
+			written := readCache(e, parsedURL)
+			Expect(written).To(ContainSubstring("secret"))
+			Expect(written).ToNot(ContainSubstring("session"))
+		})
+
+		It("should log in again when logged out", func() {
+			url := "http://domain.com/engine/Login/again"
+			parsedURL, _ := neturl.Parse(url)
+			httpmock.RegisterResponder("GET", url, sessionResponder("2"))
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{Login: newLogin()})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			count, _ := getLogins()
+			Expect(count).To(Equal(2))
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
+			Expect(readCache(e, parsedURL)).To(ContainSubstring("secret"))
+		})
+
+		It("should not cache logged out response", func() {
+			url := "http://domain.com/engine/Login/logged/out"
+			parsedURL, _ := neturl.Parse(url)
+			httpmock.RegisterResponder("GET", url, sessionResponder("never"))
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{Login: newLogin()})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
+			Expect(e.GetCacher().CheckCacheExists(parsedURL)).To(BeFalse())
+		})
+
+		It("should not log in without environment variable", func() {
+			url := "http://domain.com/engine/Login/without/env"
+			parsedURL, _ := neturl.Parse(url)
+			httpmock.RegisterResponder("GET", url, sessionResponder("1"))
+			os.Unsetenv("ENGINE_LOGIN_PASSWORD")
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{Login: newLogin()})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			count, _ := getLogins()
+			Expect(count).To(Equal(0))
+		})
+
+		It("should persist cookie jar", func() {
+			url := "http://domain.com/engine/Login/cookie/jar"
+			parsedURL, _ := neturl.Parse(url)
+			jarPath := rootPath + "/cookies.json"
+			httpmock.RegisterResponder("GET", url, sessionResponder("1"))
+
+			e1 := newEngine()
+			e1.MirrorWithOptions(parsedURL, -1, &MirrorOptions{Login: newLogin(), CookieJar: jarPath})
+			time.Sleep(sleepTime)
+			e1.Stop()
+
+			e2 := newEngine()
+			e2.GetCacher().SetPath(rootPath + "/2")
+			e2.MirrorWithOptions(parsedURL, -1, &MirrorOptions{CookieJar: jarPath})
+			defer e2.Stop()
+
+			time.Sleep(sleepTime)
+			count, _ := getLogins()
+			Expect(count).To(Equal(1))
+			Expect(readCache(e2, parsedURL)).To(ContainSubstring("secret"))
+		})
+	})
+
 	Describe("hostRewrites", func() {
 		It("should rewrite host", func() {
 			url0 := "http://domain.com/engine/download/rewrite/host/0"
@@ -375,6 +845,83 @@ var _ = Describe("Engine", func() {
 		})
 	})
 
+	Describe("RemoveHostRewrite", func() {
+		It("should remove", func() {
+			e := newEngine()
+			e.AddHostRewrite("domain1.com", "domain.com")
+			e.AddHostRewrite("domain2.com", "domain.com")
+			e.RemoveHostRewrite("domain1.com")
+			e.RemoveHostRewrite("domain3.com")
+
+			Expect(e.GetHostRewrites()).To(Equal(map[string]string{"domain2.com": "domain.com"}))
+		})
+	})
+
+	Describe("RemoveHostWhitelisted", func() {
+		It("should remove", func() {
+			e := newEngine()
+			e.AddHostWhitelisted("domain.com")
+			e.AddHostWhitelisted("domain1.com")
+			e.RemoveHostWhitelisted("domain.com")
+
+			Expect(len(e.GetHostsWhitelist())).To(Equal(1))
+		})
+
+		It("should allow all hosts after removing the last one", func() {
+			url0 := "http://domain.com/engine/RemoveHostWhitelisted/0"
+			url1 := "http://domain1.com/engine/RemoveHostWhitelisted/1"
+			html0 := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>", url1))
+			httpmock.RegisterResponder("GET", url0, t.NewHTMLResponder(html0))
+			httpmock.RegisterResponder("GET", url1, httpmock.NewStringResponder(200, ""))
+
+			e := newEngine()
+			e.AddHostWhitelisted("domain.com")
+			e.RemoveHostWhitelisted("domain.com")
+			mirrorURL(e, url0, -1)
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
+		})
+	})
+
+	Describe("RemoveMirror", func() {
+		It("should remove", func() {
+			url := "http://domain.com/engine/RemoveMirror/remove"
+			parsedURL, _ := neturl.Parse(url)
+			httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, ""))
+			depth := uint64Three
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{AutoDownloadDepth: &depth})
+			defer e.Stop()
+			Expect(e.GetCrawler().GetRootAutoDownloadDepth(parsedURL)).To(Equal(depth))
+
+			Expect(e.RemoveMirror(parsedURL)).ToNot(HaveOccurred())
+			Expect(e.GetCrawler().GetRootAutoDownloadDepth(parsedURL)).To(Equal(uint64One))
+		})
+
+		It("should close listener", func() {
+			e := newEngine()
+			e.Mirror(nil, 0)
+			defer e.Stop()
+
+			port, _ := e.GetServer().GetListeningPort("")
+			Expect(port).To(BeNumerically(">", 0))
+
+			Expect(e.RemoveMirror(nil)).ToNot(HaveOccurred())
+			_, err := e.GetServer().GetListeningPort("")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should handle mirror not found", func() {
+			parsedURL, _ := neturl.Parse("http://domain.com/engine/RemoveMirror/not/found")
+
+			e := newEngine()
+			Expect(e.RemoveMirror(parsedURL)).To(HaveOccurred())
+		})
+	})
+
 	Describe("hostsWhitelist", func() {
 		It("should download from whitelisted host", func() {
 			url0 := "http://domain.com/engine/download/whitelisted/0"
@@ -433,6 +980,354 @@ var _ = Describe("Engine", func() {
 		})
 	})
 
+	Describe("SetRole", func() {
+		It("should use all roles by default", func() {
+			e := newEngine()
+
+			Expect(e.GetRole()).To(Equal(RoleAll))
+		})
+
+		It("should serve only", func() {
+			urlRoot := "http://domain.com"
+			urlPath := "/engine/role/serve/only"
+			httpmock.RegisterResponder("GET", urlRoot+"/", httpmock.NewStringResponder(200, ""))
+			httpmock.RegisterResponder("GET", urlRoot+urlPath, httpmock.NewStringResponder(200, ""))
+
+			e := newEngine()
+			e.SetRole(RoleServeOnly)
+			mirrorURL(e, urlRoot+"/", 0)
+			defer e.Stop()
+
+			port, _ := e.GetServer().GetListeningPort("domain.com")
+			resp, _ := httpClient.Get(fmt.Sprintf("http://localhost:%d"+urlPath, port))
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			Expect(string(respBody)).To(Equal(ResponseBad))
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(BeZero())
+		})
+
+		It("should crawl only", func() {
+			url := "http://domain.com/engine/role/crawl/only"
+			httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, ""))
+
+			e := newEngine()
+			e.SetRole(RoleCrawlOnly)
+			mirrorURL(e, url, 0)
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64One))
+
+			_, err := e.GetServer().GetListeningPort("domain.com")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should proxy on demand", func() {
+			urlRoot := "http://domain.com"
+			urlPath := "/engine/role/on/demand/proxy"
+			httpmock.RegisterResponder("GET", urlRoot+"/", httpmock.NewStringResponder(200, ""))
+			httpmock.RegisterResponder("GET", urlRoot+urlPath, httpmock.NewStringResponder(200, "foo/bar"))
+
+			e := newEngine()
+			e.SetRole(RoleOnDemandProxy)
+			mirrorURL(e, urlRoot+"/", 0)
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(BeZero())
+
+			port, _ := e.GetServer().GetListeningPort("domain.com")
+			resp, _ := httpClient.Get(fmt.Sprintf("http://localhost:%d"+urlPath, port))
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			Expect(string(respBody)).To(Equal("foo/bar"))
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64One))
+		})
+	})
+
+	Describe("Schedules", func() {
+		It("should reject invalid spec", func() {
+			parsedURL, _ := neturl.Parse("http://domain.com/engine/schedules/invalid")
+
+			e := newEngine()
+			err := e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{
+				Schedules: []MirrorSchedule{{Spec: "foo"}},
+			})
+			Expect(err).To(HaveOccurred())
+
+			_, err = e.GetMirrorSchedules(parsedURL)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should report next run", func() {
+			url := "http://domain.com/engine/schedules/next"
+			parsedURL, _ := neturl.Parse(url)
+			httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, ""))
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{
+				Schedules: []MirrorSchedule{{Spec: "@hourly", Jitter: time.Minute}},
+			})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			statuses, err := e.GetMirrorSchedules(parsedURL)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(statuses)).To(Equal(1))
+			Expect(statuses[0].Schedule.Spec).To(Equal("@hourly"))
+			Expect(statuses[0].LastRun.IsZero()).To(BeTrue())
+			Expect(statuses[0].NextRun).To(BeTemporally(">", time.Now()))
+			Expect(statuses[0].NextRun).To(BeTemporally("<=", time.Now().Add(time.Hour+time.Minute)))
+		})
+
+		It("should refresh up to depth", func() {
+			url0 := "http://domain.com/engine/schedules/refresh/0"
+			url1 := "http://domain.com/engine/schedules/refresh/1"
+			url2 := "http://domain.com/engine/schedules/refresh/2"
+			parsedURL0, _ := neturl.Parse(url0)
+			html0 := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>", url1))
+			html1 := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>", url2))
+			httpmock.RegisterResponder("GET", url0, t.NewHTMLResponder(html0))
+			httpmock.RegisterResponder("GET", url1, t.NewHTMLResponder(html1))
+			httpmock.RegisterResponder("GET", url2, httpmock.NewStringResponder(200, ""))
+			depth := uint64Two
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL0, -1, &MirrorOptions{AutoDownloadDepth: &depth})
+			defer e.Stop()
+
+			Eventually(e.GetCrawler().GetDownloadedCount).Should(Equal(uint64Three))
+
+			refreshDepth := uint64One
+			Expect(e.RefreshMirror(parsedURL0, MirrorSchedule{Depth: &refreshDepth})).ToNot(HaveOccurred())
+
+			Eventually(e.GetCrawler().GetDownloadedCount).Should(Equal(uint64(5)))
+			// the link at depth 2 is not downloaded again
+			Consistently(e.GetCrawler().GetDownloadedCount, 10*sleepTime).Should(Equal(uint64(5)))
+		})
+
+		It("should refresh near expiry", func() {
+			urlRoot := "http://domain.com/engine/schedules/near/expiry/"
+			urlSoon := urlRoot + "soon"
+			urlLater := urlRoot + "later"
+			httpmock.RegisterResponder("GET", urlRoot, httpmock.NewStringResponder(200, ""))
+			httpmock.RegisterResponder("GET", urlSoon, httpmock.NewStringResponder(200, ""))
+			parsedURLRoot, _ := neturl.Parse(urlRoot)
+			parsedURLSoon, _ := neturl.Parse(urlSoon)
+			parsedURLLater, _ := neturl.Parse(urlLater)
+
+			e := newEngine()
+			e.GetCacher().Write(&cacher.Input{URL: parsedURLRoot, StatusCode: 200, TTL: time.Hour})
+			e.GetCacher().Write(&cacher.Input{URL: parsedURLSoon, StatusCode: 200, TTL: time.Minute})
+			e.GetCacher().Write(&cacher.Input{URL: parsedURLLater, StatusCode: 200, TTL: time.Hour})
+			e.MirrorWithOptions(parsedURLRoot, -1, nil)
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(BeZero())
+
+			e.RefreshMirror(parsedURLRoot, MirrorSchedule{NearExpiry: 10 * time.Minute})
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64One))
+		})
+	})
+
+	Describe("CrawlBudget", func() {
+		var mirrorWithBudget = func(e Engine, url string, links int, budget CrawlBudget) *neturl.URL {
+			markup := ""
+			for i := 0; i < links; i++ {
+				link := fmt.Sprintf("%s/%d", url, i)
+				markup += fmt.Sprintf("<a href=\"%s\">Link</a>", link)
+				httpmock.RegisterResponder("GET", link, httpmock.NewStringResponder(200, "link"))
+			}
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(t.NewHTMLMarkup(markup)))
+			parsedURL, _ := neturl.Parse(url)
+
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{Budget: budget})
+			return parsedURL
+		}
+
+		It("should stop queuing at max urls", func() {
+			e := newEngine()
+			parsedURL := mirrorWithBudget(e, "http://domain.com/engine/budget/urls", 5, CrawlBudget{MaxURLs: 2})
+			defer e.Stop()
+
+			time.Sleep(2 * sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Three))
+
+			status, err := e.GetMirrorBudget(parsedURL)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.URLs).To(Equal(uint64Two))
+			Expect(status.Exceeded).To(Equal(CrawlBudgetMaxURLs))
+		})
+
+		It("should stop queuing at max bytes", func() {
+			e := newEngine()
+			parsedURL := mirrorWithBudget(e, "http://domain.com/engine/budget/bytes", 2, CrawlBudget{MaxBytes: 10})
+			defer e.Stop()
+
+			time.Sleep(2 * sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64One))
+
+			status, _ := e.GetMirrorBudget(parsedURL)
+			Expect(status.URLs).To(BeZero())
+			Expect(status.Bytes).To(BeNumerically(">=", 10))
+			Expect(status.Exceeded).To(Equal(CrawlBudgetMaxBytes))
+		})
+
+		It("should stop queuing at max duration", func() {
+			e := newEngine()
+			parsedURL := mirrorWithBudget(e, "http://domain.com/engine/budget/duration", 2, CrawlBudget{MaxDuration: time.Nanosecond})
+			defer e.Stop()
+
+			time.Sleep(2 * sleepTime)
+			// the crawl is over before the root is downloaded
+			Expect(e.GetCrawler().GetDownloadedCount()).To(BeZero())
+
+			status, _ := e.GetMirrorBudget(parsedURL)
+			Expect(status.Exceeded).To(Equal(CrawlBudgetMaxDuration))
+		})
+
+		It("should not cache objects over max object bytes", func() {
+			url := "http://domain.com/engine/budget/object"
+			urlLarge := url + "/large"
+			html := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>", urlLarge))
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(html))
+			httpmock.RegisterResponder("GET", urlLarge, httpmock.NewStringResponder(200, strings.Repeat("a", 1024)))
+			parsedURL, _ := neturl.Parse(url)
+			parsedURLLarge, _ := neturl.Parse(urlLarge)
+
+			e := newEngine()
+			e.MirrorWithOptions(parsedURL, -1, &MirrorOptions{Budget: CrawlBudget{MaxObjectBytes: 512}})
+			defer e.Stop()
+
+			time.Sleep(2 * sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
+			Expect(e.GetCacher().CheckCacheExists(parsedURL)).To(BeTrue())
+			Expect(e.GetCacher().CheckCacheExists(parsedURLLarge)).To(BeFalse())
+
+			status, _ := e.GetMirrorBudget(parsedURL)
+			Expect(status.Skipped).To(Equal(uint64One))
+			Expect(status.Bytes).To(BeNumerically(">", 0))
+			Expect(status.Bytes).To(BeNumerically("<", 512))
+			Expect(status.Exceeded).To(BeEmpty())
+		})
+
+		It("should reset on refresh", func() {
+			e := newEngine()
+			parsedURL := mirrorWithBudget(e, "http://domain.com/engine/budget/refresh", 2, CrawlBudget{MaxURLs: 1})
+			defer e.Stop()
+
+			time.Sleep(2 * sleepTime)
+			before, _ := e.GetMirrorBudget(parsedURL)
+			Expect(before.Exceeded).To(Equal(CrawlBudgetMaxURLs))
+
+			Expect(e.RefreshMirror(parsedURL, MirrorSchedule{})).ToNot(HaveOccurred())
+
+			time.Sleep(2 * sleepTime)
+			after, _ := e.GetMirrorBudget(parsedURL)
+			Expect(after.Started).To(BeTemporally(">", before.Started))
+			Expect(after.URLs).To(Equal(uint64One))
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64(4)))
+		})
+
+		It("should refresh expired cache over budget", func() {
+			urlPath := "/engine/budget/expired"
+			url := "http://domain.com" + urlPath
+			markup := ""
+			for i := 0; i < 2; i++ {
+				link := fmt.Sprintf("%s/%d", url, i)
+				markup += fmt.Sprintf("<a href=\"%s\">Link</a>", link)
+				httpmock.RegisterResponder("GET", link, httpmock.NewStringResponder(200, "link"))
+			}
+			httpmock.RegisterResponder("GET", url, t.NewHTMLResponder(t.NewHTMLMarkup(markup)))
+			parsedURL, _ := neturl.Parse(url)
+
+			e := newEngine()
+			e.GetCacher().SetDefaultTTL(time.Millisecond)
+			e.MirrorWithOptions(parsedURL, 0, &MirrorOptions{Budget: CrawlBudget{MaxBytes: 10}})
+			defer e.Stop()
+
+			Eventually(e.GetCrawler().GetDownloadedCount).Should(Equal(uint64One))
+			before, _ := e.GetMirrorBudget(parsedURL)
+			Expect(before.Exceeded).To(Equal(CrawlBudgetMaxBytes))
+
+			port, _ := e.GetServer().GetListeningPort("domain.com")
+			resp, _ := httpClient.Get(fmt.Sprintf("http://localhost:%d"+urlPath, port))
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			// the expired root and its links are downloaded for the request, outside of the crawl budget
+			Eventually(e.GetCrawler().GetDownloadedCount).Should(Equal(uint64(4)))
+			after, _ := e.GetMirrorBudget(parsedURL)
+			Expect(after.URLs).To(BeZero())
+			Expect(after.Bytes).To(Equal(before.Bytes))
+		})
+
+		It("should handle mirror not found", func() {
+			parsedURL, _ := neturl.Parse("http://domain.com/engine/budget/not/found")
+
+			_, err := newEngine().GetMirrorBudget(parsedURL)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("SetCrawlRules", func() {
+		It("should use the first matching rule", func() {
+			url0 := "http://domain.com/engine/rules/0"
+			url1 := "http://domain.com/engine/rules/search/keep"
+			url2 := "http://domain.com/engine/rules/search?q=foo"
+			url3 := "http://domain.com/engine/rules/logout"
+			html0 := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>"+
+				"<a href=\"%s\">Link</a><a href=\"%s\">Link</a>", url1, url2, url3))
+			httpmock.RegisterResponder("GET", url0, t.NewHTMLResponder(html0))
+			httpmock.RegisterResponder("GET", url1, httpmock.NewStringResponder(200, ""))
+			httpmock.RegisterResponder("GET", url2, httpmock.NewStringResponder(200, ""))
+			httpmock.RegisterResponder("GET", url3, httpmock.NewStringResponder(200, ""))
+
+			rules := make([]CrawlRule, 0)
+			for _, value := range []string{
+				"include path=/engine/rules/search/keep",
+				"exclude path=/engine/rules/search*",
+				"exclude path=/**/logout",
+			} {
+				rule, _ := ParseCrawlRule(value)
+				rules = append(rules, rule)
+			}
+
+			e := newEngine()
+			e.SetCrawlRules(rules)
+			mirrorURL(e, url0, -1)
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawlRules()).To(Equal(rules))
+			Expect(e.GetCrawler().GetLinkFoundCount()).To(Equal(uint64Three))
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
+		})
+
+		It("should evaluate mirror rules first", func() {
+			url0 := "http://domain.com/engine/rules/mirror/0"
+			url1 := "http://domain.com/engine/rules/mirror/1"
+			parsedURL0, _ := neturl.Parse(url0)
+			html0 := t.NewHTMLMarkup(fmt.Sprintf("<a href=\"%s\">Link</a>", url1))
+			httpmock.RegisterResponder("GET", url0, t.NewHTMLResponder(html0))
+			httpmock.RegisterResponder("GET", url1, httpmock.NewStringResponder(200, ""))
+			exclude, _ := ParseCrawlRule("exclude")
+			include, _ := ParseCrawlRule("include path=/engine/rules/mirror/*")
+
+			e := newEngine()
+			e.SetCrawlRules([]CrawlRule{exclude})
+			e.MirrorWithOptions(parsedURL0, -1, &MirrorOptions{CrawlRules: []CrawlRule{include}})
+			defer e.Stop()
+
+			time.Sleep(sleepTime)
+			Expect(e.GetCrawler().GetDownloadedCount()).To(Equal(uint64Two))
+		})
+	})
+
 	Describe("SetBumpTTL", func() {
 
 		testSetBumpTTLDuration := time.Millisecond
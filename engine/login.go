@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/alphagov/spotlight-gel/crawler"
+)
+
+const (
+	// LoginRetryInterval minimum delay before a failed login is attempted again
+	LoginRetryInterval = time.Minute
+)
+
+// engineLogin keeps the login state of a mirror
+type engineLogin struct {
+	options *Login
+	client  *http.Client
+	logger  *logrus.Logger
+
+	mutex    sync.Mutex
+	loggedIn bool
+	failed   time.Time
+	retried  map[string]bool
+}
+
+func newEngineLogin(options *Login, client *http.Client, logger *logrus.Logger) *engineLogin {
+	return &engineLogin{
+		options: options,
+		client:  client,
+		logger:  logger,
+		retried: make(map[string]bool),
+	}
+}
+
+// ensure runs the login steps unless logged in already or failed recently,
+// the crawler waits for it before downloading from the mirror
+func (l *engineLogin) ensure(header http.Header, upstreams *crawler.Upstreams) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.loggedIn || time.Since(l.failed) < LoginRetryInterval {
+		return
+	}
+
+	for i, step := range l.options.Steps {
+		if err := l.runStep(step, header, upstreams); err != nil {
+			l.failed = time.Now()
+			l.logger.WithFields(logrus.Fields{
+				"step":  i,
+				"url":   step.URL,
+				"error": err,
+			}).Error("Login failed")
+			return
+		}
+	}
+
+	l.loggedIn = true
+	l.logger.WithField("steps", len(l.options.Steps)).Info("Logged in")
+}
+
+// retry marks the session as logged out and returns true if the url should be downloaded again,
+// each url is only retried once until it is downloaded while logged in
+func (l *engineLogin) retry(url *neturl.URL) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.loggedIn = false
+
+	key := url.String()
+	if l.retried[key] {
+		return false
+	}
+	l.retried[key] = true
+
+	return true
+}
+
+// downloaded forgets the retry of an url downloaded while logged in
+func (l *engineLogin) downloaded(url *neturl.URL) {
+	l.mutex.Lock()
+	delete(l.retried, url.String())
+	l.mutex.Unlock()
+}
+
+// isLoggedOut returns true if the response matches the logged out marker
+func (l *engineLogin) isLoggedOut(d *crawler.Downloaded) bool {
+	marker := l.options.LoggedOut
+	if marker == nil {
+		return false
+	}
+
+	if marker.MatchString(d.Body) {
+		return true
+	}
+
+	for _, link := range d.LinksDiscovered {
+		if link.Context == crawler.HTTP3xxLocation && marker.MatchString(link.URL.String()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *engineLogin) runStep(step LoginStep, header http.Header, upstreams *crawler.Upstreams) error {
+	method := strings.ToUpper(step.Method)
+	if len(method) == 0 {
+		method = "GET"
+		if step.Form != nil {
+			method = "POST"
+		}
+	}
+
+	form := make(neturl.Values)
+	for key, value := range step.Form {
+		expanded, err := expandEnvValue(value)
+		if err != nil {
+			return fmt.Errorf("form field %q: %v", key, err)
+		}
+		form.Set(key, expanded)
+	}
+
+	url := *step.URL
+	var body io.Reader
+	if method == "POST" {
+		body = strings.NewReader(form.Encode())
+	} else if len(form) > 0 {
+		query := url.Query()
+		for key, values := range form {
+			query[key] = values
+		}
+		url.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequest(method, url.String(), body)
+	if err != nil {
+		return err
+	}
+	for headerKey, headerValues := range header {
+		req.Header[headerKey] = headerValues
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	client := *l.client
+	if transport := upstreams.Apply(req); transport != nil {
+		client.Transport = transport
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,328 @@
+package engine
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strings"
+
+	"github.com/alphagov/spotlight-gel/cacher"
+	"github.com/alphagov/spotlight-gel/crawler"
+)
+
+// RewriteRule represents a regular expression replacement in response bodies,
+// rules are applied in order to the responses matching all conditions after parsing and before caching.
+type RewriteRule struct {
+	// ContentTypes are media types such as text/javascript, a trailing /* matches any subtype.
+	// Empty ContentTypes match any response.
+	ContentTypes []string
+	// URL holds the url conditions, Exclude is ignored
+	URL CrawlRule
+
+	Regexp *regexp.Regexp
+	// Replacement may refer to submatches with $1 or ${name}
+	Replacement string
+	// All replaces every match instead of the first one
+	All bool
+
+	value string
+}
+
+// HeaderRule represents a change of the response headers, applied like RewriteRule
+type HeaderRule struct {
+	Action       string
+	ContentTypes []string
+	URL          CrawlRule
+
+	Key   string
+	Value string
+
+	value string
+}
+
+const (
+	// HeaderRuleSet replaces the values of a header
+	HeaderRuleSet = "set"
+	// HeaderRuleAdd adds a value to a header
+	HeaderRuleAdd = "add"
+	// HeaderRuleRemove removes a header
+	HeaderRuleRemove = "remove"
+)
+
+// ParseRewriteRule returns the RewriteRule represented by a string such as
+// 'type=text/javascript,application/json path=/static/** s|https?://origin\.example\.com|https://www.example.com|g'.
+// Conditions are the same as ParseCrawlRule plus type (comma separated), the expression is
+// s followed by a delimiter, the pattern, the replacement and the flags g (all matches) and i (ignore case).
+func ParseRewriteRule(value string) (RewriteRule, error) {
+	rule := RewriteRule{value: strings.TrimSpace(value)}
+
+	conditions, expr := cutRuleConditions(value, isRewriteRuleExpr)
+	if len(expr) == 0 {
+		return rule, fmt.Errorf("rule %q must end with 's/pattern/replacement/flags'", value)
+	}
+
+	contentTypes, err := parseRuleConditions(&rule.URL, conditions)
+	if err != nil {
+		return rule, err
+	}
+	rule.ContentTypes = contentTypes
+
+	delimiter := expr[1]
+	parts := splitRewriteRuleExpr(expr[2:], delimiter)
+	if len(parts) != 3 {
+		return rule, fmt.Errorf("expression %q must be 's%cpattern%creplacement%cflags'", expr, delimiter, delimiter, delimiter)
+	}
+
+	pattern, flags := parts[0], strings.TrimSpace(parts[2])
+	for _, flag := range flags {
+		switch flag {
+		case 'g':
+			rule.All = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		default:
+			return rule, fmt.Errorf("expression %q has unknown flag %q", expr, flag)
+		}
+	}
+
+	rule.Regexp, err = regexp.Compile(pattern)
+	if err != nil {
+		return rule, err
+	}
+	rule.Replacement = parts[1]
+
+	return rule, nil
+}
+
+// Match returns true if all conditions are satisfied by the url and content type
+func (r RewriteRule) Match(url *neturl.URL, contentType string) bool {
+	return matchRuleContentType(r.ContentTypes, contentType) && r.URL.Match(url)
+}
+
+// Apply returns the body after replacement
+func (r RewriteRule) Apply(body string) string {
+	if r.All {
+		return r.Regexp.ReplaceAllString(body, r.Replacement)
+	}
+
+	loc := r.Regexp.FindStringSubmatchIndex(body)
+	if loc == nil {
+		return body
+	}
+
+	replaced := r.Regexp.ExpandString(nil, r.Replacement, body, loc)
+	return body[:loc[0]] + string(replaced) + body[loc[1]:]
+}
+
+func (r RewriteRule) String() string {
+	return r.value
+}
+
+// ParseHeaderRule returns the HeaderRule represented by a string such as
+// 'type=text/html set X-Robots-Tag: noindex', 'add Cache-Control: public' or 'host=*.example.com remove Set-Cookie'.
+// Conditions are the same as ParseRewriteRule.
+func ParseHeaderRule(value string) (HeaderRule, error) {
+	rule := HeaderRule{value: strings.TrimSpace(value)}
+
+	conditions, action := cutRuleConditions(value, isHeaderRuleAction)
+	if len(action) == 0 {
+		return rule, fmt.Errorf("rule %q must contain '%s', '%s' or '%s'", value, HeaderRuleSet, HeaderRuleAdd, HeaderRuleRemove)
+	}
+
+	contentTypes, err := parseRuleConditions(&rule.URL, conditions)
+	if err != nil {
+		return rule, err
+	}
+	rule.ContentTypes = contentTypes
+
+	actionParts := strings.SplitN(action, " ", 2)
+	rule.Action = actionParts[0]
+	if len(actionParts) < 2 {
+		return rule, fmt.Errorf("rule %q: %s requires a header", value, rule.Action)
+	}
+
+	headerParts := strings.SplitN(actionParts[1], ":", 2)
+	rule.Key = http.CanonicalHeaderKey(strings.TrimSpace(headerParts[0]))
+	if len(rule.Key) == 0 || strings.ContainsAny(rule.Key, " \t") {
+		return rule, fmt.Errorf("rule %q has invalid header %q", value, headerParts[0])
+	}
+
+	switch rule.Action {
+	case HeaderRuleRemove:
+		if len(headerParts) > 1 {
+			return rule, fmt.Errorf("rule %q: %s takes no value", value, rule.Action)
+		}
+	default:
+		if len(headerParts) < 2 {
+			return rule, fmt.Errorf("rule %q: %s requires 'Key: value'", value, rule.Action)
+		}
+		rule.Value = strings.TrimSpace(headerParts[1])
+	}
+
+	return rule, nil
+}
+
+// Match returns true if all conditions are satisfied by the url and content type
+func (r HeaderRule) Match(url *neturl.URL, contentType string) bool {
+	return matchRuleContentType(r.ContentTypes, contentType) && r.URL.Match(url)
+}
+
+// Apply changes the header
+func (r HeaderRule) Apply(header http.Header) {
+	switch r.Action {
+	case HeaderRuleSet:
+		header.Set(r.Key, r.Value)
+	case HeaderRuleAdd:
+		header.Add(r.Key, r.Value)
+	case HeaderRuleRemove:
+		header.Del(r.Key)
+	}
+}
+
+func (r HeaderRule) String() string {
+	return r.value
+}
+
+// ApplyRewriteRules applies the matching rules to a response, header is changed in place and the body is returned.
+// The content type is read before any header rule is applied.
+// Bodies kept encoded by the crawler are decoded before the first matching body rule, or left alone if they cannot be.
+func ApplyRewriteRules(url *neturl.URL, header http.Header, body string, bodyRules []RewriteRule, headerRules []HeaderRule) string {
+	contentType := header.Get("Content-Type")
+
+	decoded := len(header.Get(cacher.HeaderContentEncoding)) == 0
+	for _, rule := range bodyRules {
+		if !rule.Match(url, contentType) {
+			continue
+		}
+
+		if !decoded {
+			decodedBody, err := crawler.DecodeBody(header.Get(cacher.HeaderContentEncoding), body)
+			if err != nil {
+				break
+			}
+
+			body = decodedBody
+			header.Del(cacher.HeaderContentEncoding)
+			header.Del(cacher.HeaderVary)
+			decoded = true
+		}
+
+		body = rule.Apply(body)
+	}
+
+	for _, rule := range headerRules {
+		if rule.Match(url, contentType) {
+			rule.Apply(header)
+		}
+	}
+
+	return body
+}
+
+// cutRuleConditions returns the leading condition fields and the rest of the value, starting with the action
+func cutRuleConditions(value string, isAction func(string) bool) ([]string, string) {
+	conditions := make([]string, 0)
+
+	rest := strings.TrimSpace(value)
+	for len(rest) > 0 {
+		field := rest
+		if i := strings.IndexAny(rest, " \t"); i > -1 {
+			field = rest[:i]
+		}
+		if isAction(field) {
+			return conditions, rest
+		}
+
+		conditions = append(conditions, field)
+		rest = strings.TrimSpace(rest[len(field):])
+	}
+
+	return conditions, ""
+}
+
+// parseRuleConditions sets the url conditions and returns the content types
+func parseRuleConditions(url *CrawlRule, conditions []string) ([]string, error) {
+	var contentTypes []string
+
+	for _, field := range conditions {
+		key, v, err := splitCrawlRuleCondition(field)
+		if err != nil {
+			return nil, err
+		}
+
+		if key == "type" {
+			for _, contentType := range strings.Split(v, ",") {
+				contentTypes = append(contentTypes, strings.ToLower(contentType))
+			}
+			continue
+		}
+
+		ok, err := url.setCondition(key, v)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown condition %q", key)
+		}
+	}
+
+	return contentTypes, nil
+}
+
+func isRewriteRuleExpr(field string) bool {
+	if len(field) < 2 || field[0] != 's' {
+		return false
+	}
+
+	c := field[1]
+	return c > ' ' && c < 0x7f && c != '\\' && c != '=' && c != '-' && c != '_' &&
+		!(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9')
+}
+
+func isHeaderRuleAction(field string) bool {
+	return field == HeaderRuleSet || field == HeaderRuleAdd || field == HeaderRuleRemove
+}
+
+// splitRewriteRuleExpr splits an expression by unescaped delimiters, escaped delimiters become literal
+func splitRewriteRuleExpr(expr string, delimiter byte) []string {
+	parts := make([]string, 0, 3)
+
+	var part strings.Builder
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == '\\' && i+1 < len(expr) && expr[i+1] == delimiter:
+			part.WriteByte(delimiter)
+			i++
+		case c == delimiter:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+
+	return append(parts, part.String())
+}
+
+func matchRuleContentType(patterns []string, contentType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		if pattern == "*/*" || pattern == mediaType ||
+			(strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, pattern[:len(pattern)-1])) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,216 @@
+package engine_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	neturl "net/url"
+
+	. "github.com/alphagov/spotlight-gel/engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RewriteRule", func() {
+	apply := func(value string, body string) string {
+		rule, err := ParseRewriteRule(value)
+		Expect(err).ToNot(HaveOccurred())
+
+		return rule.Apply(body)
+	}
+
+	match := func(value string, url string, contentType string) bool {
+		rule, err := ParseRewriteRule(value)
+		Expect(err).ToNot(HaveOccurred())
+
+		parsedURL, _ := neturl.Parse(url)
+		return rule.Match(parsedURL, contentType)
+	}
+
+	Describe("ParseRewriteRule", func() {
+		It("should parse", func() {
+			rule, err := ParseRewriteRule(`type=text/javascript,application/JSON host=*.domain.com s|https?://origin\.com|https://www.domain.com/$1 x|g`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rule.ContentTypes).To(Equal([]string{"text/javascript", "application/json"}))
+			Expect(rule.URL.Host).To(Equal("*.domain.com"))
+			Expect(rule.Regexp.String()).To(Equal(`https?://origin\.com`))
+			Expect(rule.Replacement).To(Equal("https://www.domain.com/$1 x"))
+			Expect(rule.All).To(BeTrue())
+		})
+
+		It("should parse escaped delimiter", func() {
+			rule, err := ParseRewriteRule(`s/a\/b/c\/d/`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rule.Regexp.String()).To(Equal("a/b"))
+			Expect(rule.Replacement).To(Equal("c/d"))
+			Expect(rule.All).To(BeFalse())
+		})
+
+		It("should handle value in wrong format", func() {
+			for _, value := range []string{
+				"",
+				"path=/",
+				"path=/ s|a|b",
+				"s|a|b|c|",
+				"s|a|b|x",
+				"s|(|b|",
+				"foo=bar s|a|b|",
+				"path s|a|b|",
+			} {
+				_, err := ParseRewriteRule(value)
+				Expect(err).To(HaveOccurred(), value)
+			}
+		})
+
+		It("should return string", func() {
+			value := "type=text/css s#url\\(/#url(/static/#g"
+			rule, _ := ParseRewriteRule(value)
+			Expect(rule.String()).To(Equal(value))
+		})
+	})
+
+	Describe("Apply", func() {
+		It("should replace first match", func() {
+			Expect(apply("s/a(.)/<$1>/", "ab ac")).To(Equal("<b> ac"))
+		})
+
+		It("should replace all matches", func() {
+			Expect(apply("s/a(.)/<$1>/g", "ab ac")).To(Equal("<b> <c>"))
+		})
+
+		It("should ignore case", func() {
+			Expect(apply("s/origin/www/gi", "Origin ORIGIN")).To(Equal("www www"))
+		})
+
+		It("should keep body without match", func() {
+			Expect(apply("s/x/y/", "abc")).To(Equal("abc"))
+		})
+	})
+
+	Describe("Match", func() {
+		It("should match any response without conditions", func() {
+			Expect(match("s/a/b/", "http://domain.com/image.png", "image/png")).To(BeTrue())
+		})
+
+		It("should match content type", func() {
+			Expect(match("type=application/json s/a/b/", "http://domain.com/", "application/json; charset=utf-8")).To(BeTrue())
+			Expect(match("type=text/* s/a/b/", "http://domain.com/", "text/css")).To(BeTrue())
+			Expect(match("type=text/* s/a/b/", "http://domain.com/", "image/png")).To(BeFalse())
+			Expect(match("type=text/* s/a/b/", "http://domain.com/", "")).To(BeFalse())
+		})
+
+		It("should match url", func() {
+			Expect(match("path=/static/** s/a/b/", "http://domain.com/static/js/app.js", "")).To(BeTrue())
+			Expect(match("path=/static/** s/a/b/", "http://domain.com/app.js", "")).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("HeaderRule", func() {
+	apply := func(value string, header http.Header) http.Header {
+		rule, err := ParseHeaderRule(value)
+		Expect(err).ToNot(HaveOccurred())
+
+		rule.Apply(header)
+		return header
+	}
+
+	Describe("ParseHeaderRule", func() {
+		It("should parse", func() {
+			rule, err := ParseHeaderRule("type=text/html path=/set set x-robots-tag: noindex, nofollow")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rule.Action).To(Equal(HeaderRuleSet))
+			Expect(rule.ContentTypes).To(Equal([]string{"text/html"}))
+			Expect(rule.URL.Path).To(Equal("/set"))
+			Expect(rule.Key).To(Equal("X-Robots-Tag"))
+			Expect(rule.Value).To(Equal("noindex, nofollow"))
+		})
+
+		It("should parse remove", func() {
+			rule, err := ParseHeaderRule("remove Set-Cookie")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rule.Action).To(Equal(HeaderRuleRemove))
+			Expect(rule.Key).To(Equal("Set-Cookie"))
+		})
+
+		It("should handle value in wrong format", func() {
+			for _, value := range []string{
+				"",
+				"path=/",
+				"set",
+				"set Key",
+				"add : value",
+				"remove Key: value",
+				"foo=bar remove Key",
+			} {
+				_, err := ParseHeaderRule(value)
+				Expect(err).To(HaveOccurred(), value)
+			}
+		})
+
+		It("should return string", func() {
+			value := "host=domain.com add Vary: Accept"
+			rule, _ := ParseHeaderRule(value)
+			Expect(rule.String()).To(Equal(value))
+		})
+	})
+
+	Describe("Apply", func() {
+		It("should set", func() {
+			Expect(apply("set Key: value", http.Header{"Key": {"a", "b"}})).To(Equal(http.Header{"Key": {"value"}}))
+		})
+
+		It("should add", func() {
+			Expect(apply("add Key: value", http.Header{"Key": {"a"}})).To(Equal(http.Header{"Key": {"a", "value"}}))
+		})
+
+		It("should remove", func() {
+			Expect(apply("remove Key", http.Header{"Key": {"a"}, "Other": {"b"}})).To(Equal(http.Header{"Other": {"b"}}))
+		})
+	})
+})
+
+var _ = Describe("ApplyRewriteRules", func() {
+	It("should apply matching rules in order", func() {
+		parsedURL, _ := neturl.Parse("http://domain.com/ApplyRewriteRules")
+		bodyRule1, _ := ParseRewriteRule("type=application/json s/a/b/g")
+		bodyRule2, _ := ParseRewriteRule("s/b/c/")
+		bodyRule3, _ := ParseRewriteRule("type=text/html s/c/d/g")
+		headerRule1, _ := ParseHeaderRule("set Content-Type: text/html")
+		headerRule2, _ := ParseHeaderRule("type=application/json add Key: value")
+		header := http.Header{"Content-Type": {"application/json"}}
+
+		body := ApplyRewriteRules(parsedURL, header, "aa", []RewriteRule{bodyRule1, bodyRule2, bodyRule3},
+			[]HeaderRule{headerRule1, headerRule2})
+
+		Expect(body).To(Equal("cb"))
+		Expect(header).To(Equal(http.Header{"Content-Type": {"text/html"}, "Key": {"value"}}))
+	})
+
+	It("should decode encoded body", func() {
+		parsedURL, _ := neturl.Parse("http://domain.com/ApplyRewriteRules/encoded")
+		bodyRule, _ := ParseRewriteRule("s/a/b/g")
+		buffer := new(bytes.Buffer)
+		w := gzip.NewWriter(buffer)
+		w.Write([]byte("aa"))
+		w.Close()
+		header := http.Header{"Content-Encoding": {"gzip"}, "Vary": {"Accept-Encoding"}}
+
+		body := ApplyRewriteRules(parsedURL, header, buffer.String(), []RewriteRule{bodyRule}, nil)
+
+		Expect(body).To(Equal("bb"))
+		Expect(header).To(Equal(http.Header{}))
+	})
+
+	It("should not decode without matching rule", func() {
+		parsedURL, _ := neturl.Parse("http://domain.com/ApplyRewriteRules/encoded/unmatched")
+		bodyRule, _ := ParseRewriteRule("type=text/html s/a/b/g")
+		header := http.Header{"Content-Encoding": {"gzip"}}
+
+		body := ApplyRewriteRules(parsedURL, header, "encoded", []RewriteRule{bodyRule}, nil)
+
+		Expect(body).To(Equal("encoded"))
+		Expect(header).To(Equal(http.Header{"Content-Encoding": {"gzip"}}))
+	})
+})
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role represents what an Engine does with its mirrors, roles can be combined
+type Role uint8
+
+const (
+	// RoleServe listens on the mirror ports and serves from the cache
+	RoleServe Role = 1 << iota
+	// RoleCrawl downloads mirror roots and follows the discovered links
+	RoleCrawl
+	// RoleProxy downloads urls that are requested but not found in the cache
+	RoleProxy
+
+	// RoleServeOnly serves from the cache without contacting upstream
+	RoleServeOnly = RoleServe
+	// RoleCrawlOnly fills the cache without serving it
+	RoleCrawlOnly = RoleCrawl
+	// RoleOnDemandProxy serves from the cache and downloads cache misses without crawling
+	RoleOnDemandProxy = RoleServe | RoleProxy
+	// RoleAll serves, crawls and downloads cache misses
+	RoleAll = RoleServe | RoleCrawl | RoleProxy
+)
+
+var roleNames = []struct {
+	role Role
+	name string
+}{
+	{RoleServe, "serve"},
+	{RoleCrawl, "crawl"},
+	{RoleProxy, "proxy"},
+}
+
+var rolePresets = map[string]Role{
+	"serve-only":      RoleServeOnly,
+	"crawl-only":      RoleCrawlOnly,
+	"on-demand-proxy": RoleOnDemandProxy,
+	"all":             RoleAll,
+}
+
+// ParseRole returns the Role represented by a preset name
+// (serve-only, crawl-only, on-demand-proxy, all) or a comma separated list of serve, crawl, proxy
+func ParseRole(value string) (Role, error) {
+	if role, ok := rolePresets[value]; ok {
+		return role, nil
+	}
+
+	var role Role
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		found := false
+		for _, roleName := range roleNames {
+			if roleName.name == part {
+				role |= roleName.role
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return 0, fmt.Errorf("unknown role %q", part)
+		}
+	}
+
+	return role, nil
+}
+
+// Has returns true if all the specified roles are included
+func (r Role) Has(role Role) bool {
+	return r&role == role
+}
+
+// Set implements flag.Value
+func (r *Role) Set(value string) error {
+	role, err := ParseRole(value)
+	if err != nil {
+		return err
+	}
+
+	*r = role
+	return nil
+}
+
+func (r Role) String() string {
+	names := make([]string, 0, len(roleNames))
+	for _, roleName := range roleNames {
+		if r.Has(roleName.role) {
+			names = append(names, roleName.name)
+		}
+	}
+
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,48 @@
+package engine_test
+
+import (
+	. "github.com/alphagov/spotlight-gel/engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Role", func() {
+	Describe("ParseRole", func() {
+		It("should parse presets", func() {
+			for name, expected := range map[string]Role{
+				"serve-only":      RoleServeOnly,
+				"crawl-only":      RoleCrawlOnly,
+				"on-demand-proxy": RoleOnDemandProxy,
+				"all":             RoleAll,
+			} {
+				role, err := ParseRole(name)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(role).To(Equal(expected))
+			}
+		})
+
+		It("should parse list", func() {
+			role, err := ParseRole("serve, crawl")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal(RoleServe | RoleCrawl))
+		})
+
+		It("should handle unknown role", func() {
+			_, err := ParseRole("serve,foo")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("should check roles", func() {
+		Expect(RoleOnDemandProxy.Has(RoleServe)).To(BeTrue())
+		Expect(RoleOnDemandProxy.Has(RoleProxy)).To(BeTrue())
+		Expect(RoleOnDemandProxy.Has(RoleCrawl)).To(BeFalse())
+		Expect(RoleAll.Has(RoleOnDemandProxy)).To(BeTrue())
+	})
+
+	It("should return string", func() {
+		Expect(RoleAll.String()).To(Equal("serve,crawl,proxy"))
+		Expect(RoleOnDemandProxy.String()).To(Equal("serve,proxy"))
+	})
+})
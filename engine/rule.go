@@ -0,0 +1,199 @@
+package engine
+
+import (
+	"fmt"
+	neturl "net/url"
+	"regexp"
+	"strings"
+
+	"github.com/alphagov/spotlight-gel/crawler"
+)
+
+// CrawlRule represents a condition on discovered urls,
+// rules are evaluated in order and the first matching one decides whether the url is queued.
+// Empty conditions match any url.
+type CrawlRule struct {
+	Exclude bool
+
+	Scheme string
+	// Host may start with a wildcard such as *.example.com,
+	// a port restricts the match to urls with this port
+	Host string
+	// Path is a glob, * matches within a path segment and ** matches across segments
+	Path       string
+	PathRegexp *regexp.Regexp
+	// QueryKeys must all be present in the url query
+	QueryKeys []string
+}
+
+const (
+	crawlRuleInclude = "include"
+	crawlRuleExclude = "exclude"
+)
+
+// ParseCrawlRule returns the CrawlRule represented by a string such as
+// 'exclude host=*.example.com path=/search/** query=q'.
+// Supported keys are scheme, host, path, path-regexp and query (comma separated).
+func ParseCrawlRule(value string) (CrawlRule, error) {
+	rule := CrawlRule{}
+
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return rule, fmt.Errorf("empty rule")
+	}
+
+	switch fields[0] {
+	case crawlRuleInclude:
+	case crawlRuleExclude:
+		rule.Exclude = true
+	default:
+		return rule, fmt.Errorf("rule must start with '%s' or '%s'", crawlRuleInclude, crawlRuleExclude)
+	}
+
+	for _, field := range fields[1:] {
+		key, v, err := splitCrawlRuleCondition(field)
+		if err != nil {
+			return rule, err
+		}
+
+		ok, err := rule.setCondition(key, v)
+		if err != nil {
+			return rule, err
+		}
+		if !ok {
+			return rule, fmt.Errorf("unknown condition %q", key)
+		}
+	}
+
+	return rule, nil
+}
+
+// Match returns true if all conditions are satisfied by the url
+func (r CrawlRule) Match(url *neturl.URL) bool {
+	if len(r.Scheme) > 0 && r.Scheme != url.Scheme {
+		return false
+	}
+
+	if len(r.Host) > 0 && !crawler.MatchHost(r.Host, url) {
+		return false
+	}
+
+	path := url.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+	if len(r.Path) > 0 && !matchCrawlRuleGlob(r.Path, path) {
+		return false
+	}
+	if r.PathRegexp != nil && !r.PathRegexp.MatchString(path) {
+		return false
+	}
+
+	if len(r.QueryKeys) > 0 {
+		query := url.Query()
+		for _, key := range r.QueryKeys {
+			if _, ok := query[key]; !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (r CrawlRule) String() string {
+	parts := []string{crawlRuleInclude}
+	if r.Exclude {
+		parts[0] = crawlRuleExclude
+	}
+
+	if len(r.Scheme) > 0 {
+		parts = append(parts, "scheme="+r.Scheme)
+	}
+	if len(r.Host) > 0 {
+		parts = append(parts, "host="+r.Host)
+	}
+	if len(r.Path) > 0 {
+		parts = append(parts, "path="+r.Path)
+	}
+	if r.PathRegexp != nil {
+		parts = append(parts, "path-regexp="+r.PathRegexp.String())
+	}
+	if len(r.QueryKeys) > 0 {
+		parts = append(parts, "query="+strings.Join(r.QueryKeys, ","))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// setCondition returns false if the key is not an url condition
+func (r *CrawlRule) setCondition(key string, v string) (bool, error) {
+	switch key {
+	case "scheme":
+		r.Scheme = v
+	case "host":
+		r.Host = strings.ToLower(v)
+	case "path":
+		r.Path = v
+	case "path-regexp":
+		pathRegexp, err := regexp.Compile(v)
+		if err != nil {
+			return true, err
+		}
+		r.PathRegexp = pathRegexp
+	case "query":
+		r.QueryKeys = strings.Split(v, ",")
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func splitCrawlRuleCondition(field string) (string, string, error) {
+	parts := strings.SplitN(field, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("condition %q must be 'key=value'", field)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func matchCrawlRuleGlob(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch {
+		case strings.HasPrefix(pattern, "**"):
+			rest := pattern[2:]
+			for i := 0; i <= len(s); i++ {
+				if matchCrawlRuleGlob(rest, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case pattern[0] == '*':
+			rest := pattern[1:]
+			for i := 0; i <= len(s); i++ {
+				if matchCrawlRuleGlob(rest, s[i:]) {
+					return true
+				}
+				if i < len(s) && s[i] == '/' {
+					break
+				}
+			}
+			return false
+		case pattern[0] == '?':
+			if len(s) == 0 || s[0] == '/' {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
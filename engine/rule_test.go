@@ -0,0 +1,110 @@
+package engine_test
+
+import (
+	neturl "net/url"
+
+	. "github.com/alphagov/spotlight-gel/engine"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CrawlRule", func() {
+	match := func(value string, url string) bool {
+		rule, err := ParseCrawlRule(value)
+		Expect(err).ToNot(HaveOccurred())
+
+		parsedURL, _ := neturl.Parse(url)
+		return rule.Match(parsedURL)
+	}
+
+	Describe("ParseCrawlRule", func() {
+		It("should parse", func() {
+			rule, err := ParseCrawlRule("exclude scheme=https host=*.domain.com path=/search/** query=q,page")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rule.Exclude).To(BeTrue())
+			Expect(rule.Scheme).To(Equal("https"))
+			Expect(rule.Host).To(Equal("*.domain.com"))
+			Expect(rule.Path).To(Equal("/search/**"))
+			Expect(rule.QueryKeys).To(Equal([]string{"q", "page"}))
+		})
+
+		It("should parse include", func() {
+			rule, err := ParseCrawlRule("include path-regexp=^/calendar/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rule.Exclude).To(BeFalse())
+			Expect(rule.PathRegexp.String()).To(Equal("^/calendar/"))
+		})
+
+		It("should handle value in wrong format", func() {
+			for _, value := range []string{
+				"",
+				"foo path=/",
+				"exclude path",
+				"exclude foo=bar",
+				"exclude path-regexp=(",
+			} {
+				_, err := ParseCrawlRule(value)
+				Expect(err).To(HaveOccurred(), value)
+			}
+		})
+
+		It("should return string", func() {
+			value := "exclude host=domain.com path=/logout query=a,b"
+			rule, _ := ParseCrawlRule(value)
+			Expect(rule.String()).To(Equal(value))
+		})
+	})
+
+	Describe("Match", func() {
+		It("should match any url without conditions", func() {
+			Expect(match("exclude", "http://domain.com/")).To(BeTrue())
+		})
+
+		It("should match scheme", func() {
+			Expect(match("exclude scheme=https", "https://domain.com/")).To(BeTrue())
+			Expect(match("exclude scheme=https", "http://domain.com/")).To(BeFalse())
+		})
+
+		It("should match host", func() {
+			Expect(match("exclude host=domain.com", "http://DOMAIN.com/")).To(BeTrue())
+			Expect(match("exclude host=domain.com", "http://sub.domain.com/")).To(BeFalse())
+		})
+
+		It("should match host wildcard", func() {
+			Expect(match("exclude host=*.domain.com", "http://sub.domain.com/")).To(BeTrue())
+			Expect(match("exclude host=*.domain.com", "http://a.b.domain.com/")).To(BeTrue())
+			Expect(match("exclude host=*.domain.com", "http://domain.com/")).To(BeFalse())
+			Expect(match("exclude host=*.domain.com", "http://otherdomain.com/")).To(BeFalse())
+		})
+
+		It("should match host with port", func() {
+			Expect(match("exclude host=domain.com", "http://domain.com:8080/")).To(BeTrue())
+			Expect(match("exclude host=*.domain.com", "http://sub.domain.com:8080/")).To(BeTrue())
+			Expect(match("exclude host=domain.com:8080", "http://domain.com:8080/")).To(BeTrue())
+			Expect(match("exclude host=domain.com:8080", "http://domain.com/")).To(BeFalse())
+			Expect(match("exclude host=domain.com:8080", "http://domain.com:9090/")).To(BeFalse())
+		})
+
+		It("should match path glob", func() {
+			Expect(match("exclude path=/files/*", "http://domain.com/files/a.zip")).To(BeTrue())
+			Expect(match("exclude path=/files/*", "http://domain.com/files/a/b.zip")).To(BeFalse())
+			Expect(match("exclude path=/files/**", "http://domain.com/files/a/b.zip")).To(BeTrue())
+			Expect(match("exclude path=/**.pdf", "http://domain.com/a/b/c.pdf")).To(BeTrue())
+			Expect(match("exclude path=/page?", "http://domain.com/page1")).To(BeTrue())
+			Expect(match("exclude path=/page?", "http://domain.com/page10")).To(BeFalse())
+			Expect(match("exclude path=/", "http://domain.com")).To(BeTrue())
+		})
+
+		It("should match path regexp", func() {
+			Expect(match("exclude path-regexp=^/calendar/\\d{4}/", "http://domain.com/calendar/2017/11")).To(BeTrue())
+			Expect(match("exclude path-regexp=^/calendar/\\d{4}/", "http://domain.com/calendar/")).To(BeFalse())
+		})
+
+		It("should match query keys", func() {
+			Expect(match("exclude query=q", "http://domain.com/search?q=foo")).To(BeTrue())
+			Expect(match("exclude query=q,page", "http://domain.com/search?q=foo")).To(BeFalse())
+			Expect(match("exclude query=q,page", "http://domain.com/search?page=2&q=foo")).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,246 @@
+package engine
+
+import (
+	"errors"
+	"math/rand"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/alphagov/spotlight-gel/cacher"
+	"github.com/alphagov/spotlight-gel/crawler"
+	"github.com/robfig/cron/v3"
+)
+
+type engineSchedule struct {
+	options  MirrorSchedule
+	schedule cron.Schedule
+	stop     chan interface{}
+	stopOnce sync.Once
+
+	mutex   sync.Mutex
+	lastRun time.Time
+	nextRun time.Time
+}
+
+func newEngineSchedules(options []MirrorSchedule) ([]*engineSchedule, error) {
+	schedules := make([]*engineSchedule, 0, len(options))
+	for _, o := range options {
+		schedule, err := cron.ParseStandard(o.Spec)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, &engineSchedule{
+			options:  o,
+			schedule: schedule,
+			stop:     make(chan interface{}),
+		})
+	}
+
+	return schedules, nil
+}
+
+func (s *engineSchedule) getStatus() MirrorScheduleStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return MirrorScheduleStatus{
+		Schedule: s.options,
+		LastRun:  s.lastRun,
+		NextRun:  s.nextRun,
+	}
+}
+
+func (s *engineSchedule) doStop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (e *engine) GetMirrorSchedules(url *neturl.URL) ([]MirrorScheduleStatus, error) {
+	var root *neturl.URL
+	if url != nil {
+		root = buildMirrorRoot(url)
+	}
+
+	e.mutex.Lock()
+	m, ok := e.mirrors[buildMirrorKey(root)]
+	e.mutex.Unlock()
+
+	if !ok {
+		return nil, errors.New("mirror not found")
+	}
+
+	statuses := make([]MirrorScheduleStatus, len(m.schedules))
+	for i, s := range m.schedules {
+		statuses[i] = s.getStatus()
+	}
+
+	return statuses, nil
+}
+
+func (e *engine) RefreshMirror(url *neturl.URL, schedule MirrorSchedule) error {
+	if url == nil {
+		return errors.New("cross-host mirror cannot be refreshed")
+	}
+
+	m := e.getMirror(buildMirrorRoot(url))
+	if m == nil {
+		return errors.New("mirror not found")
+	}
+
+	e.refreshMirror(m, schedule)
+	return nil
+}
+
+func (e *engine) startSchedules(m *engineMirror) {
+	if m.root == nil || !e.GetRole().Has(RoleCrawl) {
+		return
+	}
+
+	for _, s := range m.schedules {
+		go e.runSchedule(m, s)
+	}
+}
+
+func (e *engine) runSchedule(m *engineMirror, s *engineSchedule) {
+	for {
+		next := s.schedule.Next(time.Now())
+		if s.options.Jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(s.options.Jitter))))
+		}
+
+		s.mutex.Lock()
+		s.nextRun = next
+		s.mutex.Unlock()
+
+		e.logger.WithFields(logrus.Fields{
+			"root": m.root,
+			"spec": s.options.Spec,
+			"next": next,
+		}).Info("Scheduled mirror refresh")
+
+		select {
+		case <-time.After(time.Until(next)):
+		case <-s.stop:
+			return
+		}
+
+		if e.stopped.IsSet() {
+			return
+		}
+
+		s.mutex.Lock()
+		previousRun := s.lastRun
+		s.lastRun = time.Now()
+		s.mutex.Unlock()
+
+		e.logger.WithFields(logrus.Fields{
+			"root":        m.root,
+			"spec":        s.options.Spec,
+			"previousRun": previousRun,
+		}).Info("Running mirror schedule")
+
+		e.refreshMirror(m, s.options)
+	}
+}
+
+func (e *engine) refreshMirror(m *engineMirror, schedule MirrorSchedule) {
+	m.budget.reset()
+
+	if schedule.NearExpiry > 0 {
+		e.refreshMirrorNearExpiry(m, schedule.NearExpiry)
+		return
+	}
+
+	depth := e.crawler.GetRootAutoDownloadDepth(m.root)
+	if schedule.Depth != nil && *schedule.Depth < depth {
+		depth = *schedule.Depth
+	}
+
+	m.refreshMutex.Lock()
+	m.refreshDepth = depth
+	m.refreshed = map[string]bool{m.root.String(): true}
+	m.refreshMutex.Unlock()
+
+	e.crawler.Enqueue(crawler.QueueItem{
+		URL:           m.root,
+		ForceDownload: true,
+		Root:          m.root,
+		Refresh:       true,
+	})
+
+	e.logger.WithFields(logrus.Fields{
+		"root":  m.root,
+		"depth": depth,
+	}).Info("Refreshing mirror")
+}
+
+func (e *engine) refreshMirrorNearExpiry(m *engineMirror, nearExpiry time.Duration) {
+	deadline := time.Now().Add(nearExpiry)
+	count := 0
+
+	err := e.cacher.Walk(func(entry *cacher.Entry) error {
+		if entry.URL == nil || entry.Expires == nil || entry.IsPlaceholder() {
+			return nil
+		}
+		if entry.Expires.After(deadline) || !isURLUnderRoot(entry.URL, m.root) {
+			return nil
+		}
+		if cacher.IsPostURL(entry.URL) {
+			// the request body is not cached, POST responses are refreshed when served
+			return nil
+		}
+		if !m.budget.queue(entry.URL) {
+			return nil
+		}
+
+		e.crawler.Enqueue(crawler.QueueItem{
+			URL:           entry.URL,
+			ForceDownload: true,
+			Root:          m.root,
+		})
+		count++
+
+		return nil
+	})
+
+	e.logger.WithFields(logrus.Fields{
+		"root":       m.root,
+		"nearExpiry": nearExpiry,
+		"enqueued":   count,
+		"error":      err,
+	}).Info("Refreshing mirror near expiry")
+}
+
+func (m *engineMirror) shouldRefresh(item crawler.QueueItem) bool {
+	m.refreshMutex.Lock()
+	defer m.refreshMutex.Unlock()
+
+	if m.refreshed == nil || item.Depth > m.refreshDepth {
+		return false
+	}
+
+	key := item.URL.String()
+	if m.refreshed[key] {
+		return false
+	}
+	m.refreshed[key] = true
+
+	return true
+}
+
+func (m *engineMirror) stopSchedules() {
+	for _, s := range m.schedules {
+		s.doStop()
+	}
+}
+
+func isURLUnderRoot(url *neturl.URL, root *neturl.URL) bool {
+	return url.Scheme == root.Scheme &&
+		url.Host == root.Host &&
+		strings.HasPrefix(url.Path, root.Path)
+}
@@ -1,7 +1,11 @@
 package engine
 
 import (
+	"fmt"
 	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
 
 	"github.com/alphagov/spotlight-gel/cacher"
 	"github.com/alphagov/spotlight-gel/crawler"
@@ -16,7 +20,7 @@ func BuildCacherInputFromCrawlerDownloaded(d *crawler.Downloaded) *cacher.Input
 	}
 
 	if d.Input != nil && d.Input.URL != nil {
-		i.URL = d.Input.URL
+		i.URL = BuildCacheURL(d.Input.URL, d.Input.Post)
 	}
 
 	i.Body = d.Body
@@ -30,3 +34,31 @@ func BuildCacherInputFromCrawlerDownloaded(d *crawler.Downloaded) *cacher.Input
 
 	return i
 }
+
+// BuildCacheURL returns the url used to cache the response of a request,
+// POST responses are cached with cacher.BuildPostURL
+func BuildCacheURL(url *neturl.URL, post *crawler.PostData) *neturl.URL {
+	if post == nil {
+		return url
+	}
+
+	return cacher.BuildPostURL(url, post.ContentType, post.Body)
+}
+
+// expandEnvValue replaces $NAME and ${NAME} with the environment variables, which must be set
+func expandEnvValue(value string) (string, error) {
+	var missing []string
+	expanded := os.Expand(value, func(name string) string {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+
+	return expanded, nil
+}
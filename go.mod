@@ -4,14 +4,18 @@ go 1.12
 
 require (
 	github.com/Sirupsen/logrus v1.0.3
+	github.com/andybalholm/brotli v1.0.4
+	github.com/andybalholm/cascadia v1.1.0
 	github.com/gorilla/css v1.0.0
 	github.com/hectane/go-nonblockingchan v0.1.0
+	github.com/klauspost/compress v1.11.13
 	github.com/namsral/flag v1.7.4-pre
 	github.com/onsi/ginkgo v1.4.0
 	github.com/onsi/gomega v1.2.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/tevino/abool v0.0.0-20170917061928-9b9efcf221b5
 	golang.org/x/crypto v0.0.0-20171113213409-9f005a07e0d3
-	golang.org/x/net v0.0.0-20171115151908-9dfe39835686
+	golang.org/x/net v0.0.0-20180218175443-cbe0f9307d01
 	golang.org/x/sys v0.0.0-20171121202757-82aafbf43bf8
 	golang.org/x/text v0.0.0-20171102192421-88f656faf3f3
 	gopkg.in/jarcoal/httpmock.v1 v1.0.0-20170412085702-cf52904a3cf0
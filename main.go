@@ -1,51 +1,43 @@
 package main
 
 import (
+	"fmt"
 	"os"
-	"os/signal"
-	"strconv"
-	"time"
-
-	"github.com/alphagov/spotlight-gel/cacher"
-	"github.com/alphagov/spotlight-gel/engine"
+	"strings"
 )
 
-func port() int64 {
-	p := os.Getenv("PORT")
-	if p == "0" {
-		p = "8080"
-	}
-	n, _ := strconv.Atoi(p)
-	return int64(n)
+type command struct {
+	name        string
+	description string
+	run         func(arg0 string, args []string) int
+}
+
+var commands = []command{
+	{"mirror", "run with the configured role (default)", runMirror},
+	{"serve", "serve from the cache only", runServe},
+	{"crawl", "warm up the cache and exit when the queue drains", runCrawl},
+	{"stats", "print cache statistics", runStats},
+	{"purge", "remove cached urls, usage: purge [-prefix] <url>", runPurge},
+	{"test-rules", "apply body and header rules to a sample, usage: test-rules <url> [file]", runTestRules},
 }
 
 func main() {
-	serverConfig, err := engine.ParseConfig(os.Args[0], os.Args[1:], os.Stderr)
-	if err != nil {
-		os.Exit(1)
+	name := commands[0].name
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
 	}
-	serverConfig.Crawler.NoProxy = true
-	serverConfig.Crawler.AutoDownloadDepth = 0
-	serverConfig.AutoEnqueueInterval = time.Duration(0)
-	serverConfig.Port = port()
-	server := engine.FromConfig(cacher.NewFs(), serverConfig)
 
-	downloaderConfig, err := engine.ParseConfig(os.Args[0], os.Args[1:], os.Stderr)
-	if err != nil {
-		os.Exit(1)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(cmd.run(fmt.Sprintf("%s %s", os.Args[0], cmd.name), args))
+		}
 	}
-	downloaderConfig.Crawler.NoProxy = false
-	downloader := engine.FromConfig(cacher.NewFs(), downloaderConfig)
-	serverConfig.Port = 7531
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	for sig := range c {
-		switch sig {
-		case os.Interrupt:
-			go server.Stop()     // FiXME: this effectively skpis drain, done because it waits for enqueue to finish
-			go downloader.Stop() // FIXME: above
-			os.Exit(0)
-		}
+	fmt.Fprintf(os.Stderr, "Unknown command %q, available commands:\n", name)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.description)
 	}
+	os.Exit(2)
 }
@@ -6,8 +6,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/alphagov/spotlight-gel/cacher"
@@ -31,6 +33,11 @@ type fakeNode struct {
 	bytes []byte
 }
 
+type fakeFileInfo struct {
+	node *fakeNode
+	size int64
+}
+
 type fakeFile struct {
 	fs    *fakeFs
 	node  *fakeNode
@@ -201,8 +208,84 @@ func (fs *fakeFs) OpenFile(name string, flag int, perm os.FileMode) (cacher.File
 	return f, nil
 }
 
-func (fs *fakeFs) RemoveAll(path string) error {
-	panic("Not implemented")
+func (fs *fakeFs) ReadDir(name string) ([]os.FileInfo, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	node, _ := fs.findNode(name)
+	if node == nil {
+		return nil, fmt.Errorf("%s does not exists", name)
+	}
+	if node.isFile() {
+		return nil, fmt.Errorf("%s is file", node.path)
+	}
+
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	names := make([]string, 0, len(node.nodes))
+	for childName := range node.nodes {
+		names = append(names, childName)
+	}
+	sort.Strings(names)
+
+	infos := make([]os.FileInfo, len(names))
+	for i, childName := range names {
+		child := node.nodes[childName]
+		child.mutex.Lock()
+		infos[i] = &fakeFileInfo{node: child, size: int64(len(child.bytes))}
+		child.mutex.Unlock()
+	}
+
+	return infos, nil
+}
+
+func (fs *fakeFs) RemoveAll(name string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	node, parent := fs.findNode(name)
+	if node == nil {
+		return nil
+	}
+	if parent == nil {
+		return fmt.Errorf("%s cannot be removed", node.path)
+	}
+
+	parent.mutex.Lock()
+	delete(parent.nodes, path.Base(node.path))
+	parent.mutex.Unlock()
+
+	fs.logger.WithField("name", name).Debug("RemoveAll: ok")
+
+	return nil
+}
+
+func (fs *fakeFs) findNode(name string) (*fakeNode, *fakeNode) {
+	if !path.IsAbs(name) {
+		name = path.Join(fs.wd, name)
+	}
+
+	var parent *fakeNode
+	node := fs.root
+	for _, part := range strings.Split(strings.Trim(path.Clean(name), "/"), "/") {
+		if len(part) == 0 {
+			continue
+		}
+		if node.isFile() {
+			return nil, nil
+		}
+
+		nextNode, ok := node.nodes[part]
+		if !ok {
+			return nil, nil
+		}
+
+		parent = node
+		node = nextNode
+	}
+
+	return node, parent
 }
 
 func (fn *fakeNode) isDir() bool {
@@ -259,6 +342,34 @@ func newFakeNode(parent *fakeNode, name string, perm os.FileMode, isDir bool) *f
 	return fn
 }
 
+func (fi *fakeFileInfo) Name() string {
+	return path.Base(fi.node.path)
+}
+
+func (fi *fakeFileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fakeFileInfo) Mode() os.FileMode {
+	if fi.node.isDir() {
+		return fi.node.perm | os.ModeDir
+	}
+
+	return fi.node.perm
+}
+
+func (fi *fakeFileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (fi *fakeFileInfo) IsDir() bool {
+	return fi.node.isDir()
+}
+
+func (fi *fakeFileInfo) Sys() interface{} {
+	return nil
+}
+
 func (ff *fakeFile) Read(p []byte) (int, error) {
 	ff.node.logger.Debug("File.Read...")
 	ff.node.mutex.Lock()
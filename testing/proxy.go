@@ -0,0 +1,269 @@
+package testing
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strconv"
+	"sync"
+)
+
+// Proxy is an in-process proxy that relays connections and records their targets
+type Proxy struct {
+	// URL is the proxy url, with the credentials if any
+	URL *neturl.URL
+
+	username string
+	password string
+
+	server   *httptest.Server
+	listener net.Listener
+
+	mutex   sync.Mutex
+	conns   []net.Conn
+	targets []string
+}
+
+// NewHTTPProxy returns a running HTTP proxy supporting absolute urls and CONNECT,
+// requests must have basic Proxy-Authorization if username is not empty
+func NewHTTPProxy(username, password string) *Proxy {
+	p := &Proxy{username: username, password: password}
+	p.server = httptest.NewServer(http.HandlerFunc(p.serveHTTP))
+	p.URL, _ = neturl.Parse(p.server.URL)
+	p.setUser()
+
+	return p
+}
+
+// NewSOCKS5Proxy returns a running SOCKS5 proxy supporting CONNECT,
+// clients must authenticate with username and password if username is not empty
+func NewSOCKS5Proxy(username, password string) *Proxy {
+	p := &Proxy{username: username, password: password}
+	p.listener, _ = net.Listen("tcp", "127.0.0.1:0")
+	p.URL = &neturl.URL{Scheme: "socks5", Host: p.listener.Addr().String()}
+	p.setUser()
+
+	go func() {
+		for {
+			conn, err := p.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go p.serveSOCKS5(p.track(conn))
+		}
+	}()
+
+	return p
+}
+
+// Targets returns the host:port of the relayed connections and requests
+func (p *Proxy) Targets() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]string(nil), p.targets...)
+}
+
+// Close stops the proxy and closes its connections
+func (p *Proxy) Close() {
+	if p.listener != nil {
+		p.listener.Close()
+	}
+
+	p.mutex.Lock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+	p.mutex.Unlock()
+
+	if p.server != nil {
+		p.server.Close()
+	}
+}
+
+func (p *Proxy) setUser() {
+	if len(p.username) > 0 {
+		p.URL.User = neturl.UserPassword(p.username, p.password)
+	}
+}
+
+func (p *Proxy) track(conn net.Conn) net.Conn {
+	p.mutex.Lock()
+	p.conns = append(p.conns, conn)
+	p.mutex.Unlock()
+
+	return conn
+}
+
+func (p *Proxy) addTarget(target string) {
+	p.mutex.Lock()
+	p.targets = append(p.targets, target)
+	p.mutex.Unlock()
+}
+
+func (p *Proxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(p.username) > 0 {
+		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(p.username+":"+p.password))
+		if r.Header.Get("Proxy-Authorization") != auth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+	}
+
+	if r.Method == http.MethodConnect {
+		p.addTarget(r.Host)
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		p.track(conn)
+		p.track(target)
+
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		pipe(conn, target)
+		return
+	}
+
+	p.addTarget(r.URL.Host)
+	req, _ := http.NewRequest(r.Method, r.URL.String(), r.Body)
+	for headerKey, headerValues := range r.Header {
+		if headerKey != "Proxy-Authorization" && headerKey != "Proxy-Connection" {
+			req.Header[headerKey] = headerValues
+		}
+	}
+
+	// a dedicated transport, http.DefaultTransport may be replaced by httpmock
+	resp, err := (&http.Transport{}).RoundTrip(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for headerKey, headerValues := range resp.Header {
+		w.Header()[headerKey] = headerValues
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// serveSOCKS5 handles a connection as in RFC 1928 with the authentication of RFC 1929
+func (p *Proxy) serveSOCKS5(conn net.Conn) {
+	r := bufio.NewReader(conn)
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil || header[0] != 5 {
+		conn.Close()
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		conn.Close()
+		return
+	}
+
+	method := byte(0x00)
+	if len(p.username) > 0 {
+		method = 0x02
+	}
+	supported := false
+	for _, m := range methods {
+		supported = supported || m == method
+	}
+	if !supported {
+		conn.Write([]byte{5, 0xff})
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{5, method})
+
+	if method == 0x02 {
+		version, _ := r.ReadByte()
+		username, password := readSOCKS5String(r), readSOCKS5String(r)
+		if version != 1 || username != p.username || password != p.password {
+			conn.Write([]byte{1, 1})
+			conn.Close()
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(r, request); err != nil || request[1] != 1 {
+		conn.Close()
+		return
+	}
+
+	var host string
+	switch request[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if request[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		io.ReadFull(r, ip)
+		host = ip.String()
+	case 3:
+		host = readSOCKS5String(r)
+	}
+	port := make([]byte, 2)
+	io.ReadFull(r, port)
+	address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	p.addTarget(address)
+
+	target, err := net.Dial("tcp", address)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Close()
+		return
+	}
+	p.track(target)
+
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(&bufferedConn{conn, r}, target)
+}
+
+// readSOCKS5String reads a string prefixed by its length byte
+func readSOCKS5String(r *bufio.Reader) string {
+	length, err := r.ReadByte()
+	if err != nil {
+		return ""
+	}
+	data := make([]byte, length)
+	io.ReadFull(r, data)
+
+	return string(data)
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func pipe(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+
+	io.Copy(b, a)
+	b.Close()
+}
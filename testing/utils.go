@@ -41,6 +41,33 @@ func NewHTMLResponder(html string) httpmock.Responder {
 	}
 }
 
+// NewJSONResponder returns a new responder with json content type
+func NewJSONResponder(json string) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(http.StatusOK, json)
+		resp.Header.Add(cacher.HeaderContentType, "application/json; charset=utf-8")
+		return resp, nil
+	}
+}
+
+// NewSVGResponder returns a new responder with svg content type
+func NewSVGResponder(svg string) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(http.StatusOK, svg)
+		resp.Header.Add(cacher.HeaderContentType, "image/svg+xml")
+		return resp, nil
+	}
+}
+
+// NewXMLResponder returns a new responder with the specified xml content type
+func NewXMLResponder(contentType string, xml string) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(http.StatusOK, xml)
+		resp.Header.Add(cacher.HeaderContentType, contentType)
+		return resp, nil
+	}
+}
+
 // NewRedirectResponder returns a new responder with Location header
 func NewRedirectResponder(status int, location string) httpmock.Responder {
 	return func(req *http.Request) (*http.Response, error) {
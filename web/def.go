@@ -7,6 +7,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/alphagov/spotlight-gel/cacher"
+	"github.com/alphagov/spotlight-gel/crawler"
 	"github.com/alphagov/spotlight-gel/web/internal"
 )
 
@@ -16,6 +17,8 @@ type Server interface {
 
 	GetCacher() cacher.Cacher
 	SetOnServerIssue(func(*ServerIssue))
+	SetCachePost(bool)
+	GetCachePost() bool
 
 	ListenAndServe(*url.URL, int) (io.Closer, error)
 	GetListeningPort(string) (int, error)
@@ -28,8 +31,13 @@ type ServerIssue struct {
 	URL  *url.URL
 	Type serverIssueType
 	Info internal.ServeInfo
+	// Post is the body of a POST request, its response is cached with cacher.BuildPostURL(URL, ...)
+	Post *crawler.PostData
 }
 
+// PostBodyMaxSize is the maximum size of the cached POST request bodies
+const PostBodyMaxSize = 1 << 20
+
 const (
 	// MethodNotAllowed server issue type when user request is made with restricted method
 	MethodNotAllowed serverIssueType = 1 + iota
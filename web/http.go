@@ -25,6 +25,11 @@ func ServeDownloaded(downloaded *crawler.Downloaded, info internal.ServeInfo) {
 	headerKeys := downloaded.GetHeaderKeys()
 	for _, headerKey := range headerKeys {
 		for _, headerValue := range downloaded.GetHeaderValues(headerKey) {
+			if headerKey == cacher.HeaderContentEncoding {
+				info.SetContentEncoding(headerValue)
+				continue
+			}
+
 			info.AddHeader(headerKey, headerValue)
 		}
 	}
@@ -113,6 +118,9 @@ func serveHTTPAddHeader(line string, info internal.ServeInfo) bool {
 
 		info.SetContentLength(contentLength)
 		return false
+	case cacher.HeaderContentEncoding:
+		info.SetContentEncoding(headerValue)
+		return false
 	case cacher.CustomHeaderCrossHostRef:
 		info.OnCrossHostRef()
 		if info.HasError() {
@@ -3,6 +3,7 @@ package web_test
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -36,6 +37,15 @@ var _ = Describe("HTTP", func() {
 		return si, w
 	}
 
+	gzipBody := func(body string) string {
+		buffer := new(bytes.Buffer)
+		w := gzip.NewWriter(buffer)
+		w.Write([]byte(body))
+		w.Close()
+
+		return buffer.String()
+	}
+
 	Describe("ServeDownloaded", func() {
 		It("should write status code, header and content", func() {
 			contentType := "text/plain"
@@ -56,6 +66,19 @@ var _ = Describe("HTTP", func() {
 			Expect(string(wBody)).To(Equal(downloaded.Body))
 		})
 
+		It("should decode body", func() {
+			downloaded := &crawler.Downloaded{
+				StatusCode: http.StatusOK,
+				Body:       gzipBody("foo/bar"),
+			}
+			downloaded.AddHeader(cacher.HeaderContentEncoding, "gzip")
+			si, w := newServeInfo()
+			ServeDownloaded(downloaded, si)
+
+			Expect(w.Header().Get(cacher.HeaderContentEncoding)).To(Equal(""))
+			Expect(w.Body.String()).To(Equal("foo/bar"))
+		})
+
 		It("should write Location header", func() {
 			location := "http://domain.com/http/ServeDownloaded/write/location/header"
 			downloaded := &crawler.Downloaded{
@@ -93,6 +116,37 @@ var _ = Describe("HTTP", func() {
 			Expect(string(wBody)).To(Equal(string(content)))
 		})
 
+		It("should write encoded content", func() {
+			content := gzipBody("foo/bar")
+			input := newReader("HTTP 200\n" +
+				cacher.HeaderContentEncoding + ": gzip\n" +
+				cacher.HeaderContentLength + ": " + fmt.Sprintf("%d", len(content)) + "\n" +
+				"\n" +
+				content)
+			si, w := newServeInfo()
+			si.SetAcceptEncoding("gzip")
+			ServeHTTPCache(input, si)
+
+			Expect(si.HasError()).To(BeFalse())
+			Expect(w.Header().Get(cacher.HeaderContentEncoding)).To(Equal("gzip"))
+			Expect(w.Body.String()).To(Equal(content))
+		})
+
+		It("should decode content", func() {
+			content := gzipBody("foo/bar")
+			input := newReader("HTTP 200\n" +
+				cacher.HeaderContentEncoding + ": gzip\n" +
+				cacher.HeaderContentLength + ": " + fmt.Sprintf("%d", len(content)) + "\n" +
+				"\n" +
+				content)
+			si, w := newServeInfo()
+			ServeHTTPCache(input, si)
+
+			Expect(si.HasError()).To(BeFalse())
+			Expect(w.Header().Get(cacher.HeaderContentEncoding)).To(Equal(""))
+			Expect(w.Body.String()).To(Equal("foo/bar"))
+		})
+
 		It("should not write (no status code)", func() {
 			input := newReader("")
 			si, w := newServeInfo()
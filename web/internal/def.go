@@ -23,6 +23,8 @@ type ServeInfo interface {
 	SetStatusCode(int)
 	SetExpires(time.Time)
 	SetContentLength(int64)
+	SetAcceptEncoding(string)
+	SetContentEncoding(string)
 	AddHeader(string, string)
 	WriteBody([]byte)
 	CopyBody(source io.Reader)
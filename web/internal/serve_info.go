@@ -5,7 +5,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/alphagov/spotlight-gel/cacher"
+	"github.com/alphagov/spotlight-gel/crawler"
 )
 
 type serveInfo struct {
@@ -14,6 +19,10 @@ type serveInfo struct {
 	contentWritten int64
 	expires        *time.Time
 
+	acceptEncoding string
+	// decodeEncoding is the content encoding not accepted by the user, the body is decoded while served
+	decodeEncoding string
+
 	errorType             errorType
 	error                 error
 	crossHost             bool
@@ -118,11 +127,36 @@ func (si *serveInfo) SetContentLength(value int64) {
 	si.responseHeader.Set("Content-Length", fmt.Sprintf("%d", value))
 }
 
+func (si *serveInfo) SetAcceptEncoding(value string) {
+	si.acceptEncoding = value
+}
+
+// SetContentEncoding adds the Content-Encoding header if the user accepts it,
+// the body is decoded otherwise
+func (si *serveInfo) SetContentEncoding(value string) {
+	if acceptsEncoding(si.acceptEncoding, value) {
+		si.AddHeader(cacher.HeaderContentEncoding, value)
+		return
+	}
+
+	si.decodeEncoding = value
+}
+
 func (si *serveInfo) AddHeader(key string, value string) {
 	si.responseHeader.Add(key, value)
 }
 
 func (si *serveInfo) WriteBody(bytes []byte) {
+	if bytes != nil && len(si.decodeEncoding) > 0 {
+		decoded, err := crawler.DecodeBody(si.decodeEncoding, string(bytes))
+		if err != nil {
+			si.errorType = ErrorWriteBody
+			si.error = err
+			return
+		}
+		bytes = []byte(decoded)
+	}
+
 	if bytes != nil {
 		si.SetContentLength(int64(len(bytes)))
 		si.writeHeader()
@@ -142,6 +176,11 @@ func (si *serveInfo) CopyBody(source io.Reader) {
 		return
 	}
 
+	if len(si.decodeEncoding) > 0 {
+		si.copyDecodedBody(source)
+		return
+	}
+
 	si.writeHeader()
 
 	written, err := io.CopyN(si.responseWriter, source, si.contentLength)
@@ -153,6 +192,28 @@ func (si *serveInfo) CopyBody(source io.Reader) {
 	}
 }
 
+func (si *serveInfo) copyDecodedBody(source io.Reader) {
+	decoder, err := crawler.NewDecoder(si.decodeEncoding, io.LimitReader(source, si.contentLength))
+	if err != nil {
+		si.errorType = ErrorCopyBody
+		si.error = err
+		return
+	}
+	defer decoder.Close()
+
+	// the decoded length is unknown until the body is written
+	si.responseHeader.Del(cacher.HeaderContentLength)
+	si.writeHeader()
+
+	written, err := io.Copy(si.responseWriter, decoder)
+	si.contentWritten = written
+
+	if err != nil {
+		si.errorType = ErrorCopyBody
+		si.error = err
+	}
+}
+
 func (si *serveInfo) Flush() ServeInfo {
 	si.writeHeader()
 
@@ -176,3 +237,42 @@ func (si *serveInfo) writeHeader() {
 		si.responseWriter.WriteHeader(si.statusCode)
 	}
 }
+
+// acceptsEncoding returns true if all the content encodings are accepted,
+// users without Accept-Encoding header only get the identity encoding
+func acceptsEncoding(acceptEncoding string, contentEncoding string) bool {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		accepted[coding] = true
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					accepted[coding] = false
+				}
+			}
+		}
+	}
+
+	for _, coding := range strings.Split(contentEncoding, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "identity" {
+			continue
+		}
+
+		if value, ok := accepted[coding]; ok {
+			if !value {
+				return false
+			}
+			continue
+		}
+		if !accepted["*"] {
+			return false
+		}
+	}
+
+	return true
+}
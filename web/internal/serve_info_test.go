@@ -2,6 +2,7 @@ package internal_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"net/http"
@@ -219,4 +220,69 @@ var _ = Describe("ServeInfo", func() {
 		})
 	})
 
+	Describe("ContentEncoding", func() {
+		gzipBody := func(body string) []byte {
+			buffer := new(bytes.Buffer)
+			w := gzip.NewWriter(buffer)
+			w.Write([]byte(body))
+			w.Close()
+
+			return buffer.Bytes()
+		}
+
+		It("should keep accepted encoding", func() {
+			encoded := gzipBody("body")
+
+			si, w := newServeInfo()
+			si.SetAcceptEncoding("gzip, deflate, br")
+			si.SetContentEncoding("gzip")
+			si.SetContentLength(int64(len(encoded)))
+			si.CopyBody(bytes.NewReader(encoded))
+
+			Expect(w.Header().Get("Content-Encoding")).To(Equal("gzip"))
+			Expect(w.Body.Bytes()).To(Equal(encoded))
+		})
+
+		It("should decode without accept encoding", func() {
+			encoded := gzipBody("body")
+
+			si, w := newServeInfo()
+			si.SetContentEncoding("gzip")
+			si.SetContentLength(int64(len(encoded)))
+			si.CopyBody(bytes.NewReader(encoded))
+
+			Expect(si.HasError()).To(BeFalse())
+			Expect(w.Header().Get("Content-Encoding")).To(Equal(""))
+			Expect(w.Header().Get("Content-Length")).To(Equal(""))
+			Expect(w.Body.String()).To(Equal("body"))
+		})
+
+		It("should decode refused encoding", func() {
+			si, w := newServeInfo()
+			si.SetAcceptEncoding("br, gzip;q=0")
+			si.SetContentEncoding("gzip")
+			si.WriteBody(gzipBody("body"))
+
+			Expect(w.Header().Get("Content-Encoding")).To(Equal(""))
+			Expect(w.Header().Get("Content-Length")).To(Equal("4"))
+			Expect(w.Body.String()).To(Equal("body"))
+		})
+
+		It("should accept any encoding", func() {
+			si, w := newServeInfo()
+			si.SetAcceptEncoding("*")
+			si.SetContentEncoding("zstd")
+			si.Flush()
+
+			Expect(w.Header().Get("Content-Encoding")).To(Equal("zstd"))
+		})
+
+		It("should handle invalid body", func() {
+			si, _ := newServeInfo()
+			si.SetContentEncoding("gzip")
+			si.WriteBody([]byte("body"))
+
+			Expect(si.HasError()).To(BeTrue())
+		})
+	})
 })
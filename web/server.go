@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
@@ -14,7 +15,9 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/alphagov/spotlight-gel/cacher"
+	"github.com/alphagov/spotlight-gel/crawler"
 	"github.com/alphagov/spotlight-gel/web/internal"
+	"github.com/tevino/abool"
 )
 
 type server struct {
@@ -22,6 +25,7 @@ type server struct {
 	logger *logrus.Logger
 
 	onServerIssue *func(*ServerIssue)
+	cachePost     *abool.AtomicBool
 
 	mutex     sync.Mutex
 	listeners map[string]net.Listener
@@ -55,6 +59,7 @@ func (s *server) init(httpCacher cacher.Cacher, logger *logrus.Logger) {
 	s.logger = logger
 
 	s.listeners = make(map[string]net.Listener)
+	s.cachePost = abool.New()
 }
 
 func (s *server) GetCacher() cacher.Cacher {
@@ -65,6 +70,16 @@ func (s *server) SetOnServerIssue(f func(*ServerIssue)) {
 	s.onServerIssue = &f
 }
 
+func (s *server) SetCachePost(value bool) {
+	s.cachePost.SetTo(value)
+
+	s.logger.WithField("value", value).Info("Updated server cache post")
+}
+
+func (s *server) GetCachePost() bool {
+	return s.cachePost.IsSet()
+}
+
 func (s *server) ListenAndServe(root *url.URL, port int) (io.Closer, error) {
 	if port < 0 {
 		return nil, errors.New("invalid port")
@@ -190,6 +205,7 @@ func (s *server) setupListener(listener net.Listener, host string, root *url.URL
 
 func (s *server) serveWithRoot(scheme string, host string, w http.ResponseWriter, req *http.Request) internal.ServeInfo {
 	si := internal.NewServeInfo(false, w)
+	si.SetAcceptEncoding(req.Header.Get(cacher.HeaderAcceptEncoding))
 
 	targetURL, _ := url.Parse(req.URL.String())
 	targetURL.Scheme = scheme
@@ -200,6 +216,7 @@ func (s *server) serveWithRoot(scheme string, host string, w http.ResponseWriter
 
 func (s *server) serveCrossHost(w http.ResponseWriter, req *http.Request) internal.ServeInfo {
 	si := internal.NewServeInfo(true, w)
+	si.SetAcceptEncoding(req.Header.Get(cacher.HeaderAcceptEncoding))
 	targetURL, _ := url.Parse(req.URL.String())
 
 	matches := regexpCrossHostPath.FindStringSubmatch(targetURL.Path)
@@ -230,25 +247,37 @@ func (s *server) serveURL(url *url.URL, si internal.ServeInfo, req *http.Request
 	if len(url.Scheme) == 0 {
 		url.Scheme = cacher.SchemeDefault
 	}
+	s.cacher.GetCanonicalRules().Canonicalize(url)
+	cacher.RemovePostQuery(url)
 
+	cacheURL := url
+	var post *crawler.PostData
 	if len(req.Method) > 0 && req.Method != "GET" {
-		return s.serveServerIssue(&ServerIssue{
-			Type: MethodNotAllowed,
-			URL:  url,
-			Info: si.OnMethodNotAllowed(),
-		})
+		if req.Method == "POST" && s.GetCachePost() {
+			post = readPostData(req)
+		}
+		if post == nil {
+			return s.serveServerIssue(&ServerIssue{
+				Type: MethodNotAllowed,
+				URL:  url,
+				Info: si.OnMethodNotAllowed(),
+			})
+		}
+
+		cacheURL = cacher.BuildPostURL(url, post.ContentType, post.Body)
 	}
 
-	if url.Path == "/robots.txt" {
+	if post == nil && url.Path == "/robots.txt" {
 		return s.serveRobotsTxt(si)
 	}
 
-	cache, err := s.cacher.Open(url)
+	cache, err := s.cacher.Open(cacheURL)
 	if err != nil {
 		return s.serveServerIssue(&ServerIssue{
 			Type: CacheNotFound,
 			URL:  url,
 			Info: si.OnCacheNotFound(err),
+			Post: post,
 		})
 	}
 	defer cache.Close()
@@ -259,6 +288,7 @@ func (s *server) serveURL(url *url.URL, si internal.ServeInfo, req *http.Request
 			Type: CacheError,
 			URL:  url,
 			Info: si,
+			Post: post,
 		})
 	}
 	if si.GetStatusCode() == 0 {
@@ -266,10 +296,11 @@ func (s *server) serveURL(url *url.URL, si internal.ServeInfo, req *http.Request
 			Type: CacheNotFound,
 			URL:  url,
 			Info: si,
+			Post: post,
 		})
 	}
 
-	loggerContext := s.logger.WithField("url", url)
+	loggerContext := s.logger.WithField("url", cacheURL)
 	siExpires := si.GetExpires()
 	if siExpires != nil && siExpires.Before(time.Now()) {
 		loggerContext = loggerContext.WithField("expired", siExpires)
@@ -277,6 +308,7 @@ func (s *server) serveURL(url *url.URL, si internal.ServeInfo, req *http.Request
 			Type: CacheExpired,
 			URL:  url,
 			Info: si,
+			Post: post,
 		})
 	}
 
@@ -284,6 +316,21 @@ func (s *server) serveURL(url *url.URL, si internal.ServeInfo, req *http.Request
 	return si.Flush()
 }
 
+// readPostData returns nil if the body cannot be read or is too large
+func readPostData(req *http.Request) *crawler.PostData {
+	post := &crawler.PostData{ContentType: req.Header.Get(cacher.HeaderContentType)}
+
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, PostBodyMaxSize+1))
+		if err != nil || len(body) > PostBodyMaxSize {
+			return nil
+		}
+		post.Body = body
+	}
+
+	return post
+}
+
 func (s *server) serveRobotsTxt(si internal.ServeInfo) internal.ServeInfo {
 	si.SetStatusCode(http.StatusOK)
 	si.WriteBody([]byte("User-agent: *\nDisallow: /\n"))
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"path"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/alphagov/spotlight-gel/cacher"
@@ -173,6 +174,23 @@ var _ = Describe("Server", func() {
 			Expect(w.Code).To(Equal(http.StatusNotImplemented))
 		})
 
+		It("should canonicalize url", func() {
+			root, _ := url.Parse("http://domain.com")
+			s := newServer()
+			c.SetCanonicalRules(&cacher.CanonicalRules{SortQuery: true, DropQueryKeys: []string{"utm_*"}})
+			issues := make(chan *ServerIssue, 1)
+			s.SetOnServerIssue(func(issue *ServerIssue) {
+				issues <- issue
+			})
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("", "/Serve/canonical?b=2&utm_source=x&a=1", nil)
+			s.Serve(root, w, req)
+
+			issue := <-issues
+			Expect(issue.Type).To(Equal(CacheNotFound))
+			Expect(issue.URL.String()).To(Equal("http://domain.com/Serve/canonical?a=1&b=2"))
+		})
+
 		It("should default http scheme", func() {
 			root, _ := url.Parse("//domain.com")
 			s := newServer()
@@ -183,6 +201,84 @@ var _ = Describe("Server", func() {
 			Expect(w.Code).To(Equal(http.StatusNotFound))
 		})
 
+		Context("post", func() {
+			const formType = "application/x-www-form-urlencoded"
+
+			newPostRequest := func(target string, body string) *http.Request {
+				req := httptest.NewRequest("POST", target, strings.NewReader(body))
+				req.Header.Set(cacher.HeaderContentType, formType)
+
+				return req
+			}
+
+			It("should serve cached response", func() {
+				root, _ := url.Parse("http://domain.com")
+				postURL, _ := url.Parse("http://domain.com/Serve/post")
+				c = cacher.NewHTTPCacher(fs, t.Logger())
+				c.SetPath(rootPath)
+				c.Write(&cacher.Input{
+					StatusCode: http.StatusOK,
+					URL:        cacher.BuildPostURL(postURL, formType, []byte("a=1&b=2")),
+					Body:       "results",
+				})
+
+				s := NewServer(c, t.Logger())
+				s.SetCachePost(true)
+				w := httptest.NewRecorder()
+				s.Serve(root, w, newPostRequest("/Serve/post", "b=2&a=1"))
+
+				Expect(w.Code).To(Equal(http.StatusOK))
+				Expect(w.Body.String()).To(Equal("results"))
+			})
+
+			It("should trigger cache not found with post data", func() {
+				root, _ := url.Parse("http://domain.com")
+				s := newServer()
+				s.SetCachePost(true)
+				issues := make(chan *ServerIssue, 1)
+				s.SetOnServerIssue(func(issue *ServerIssue) {
+					issues <- issue
+				})
+
+				s.Serve(root, httptest.NewRecorder(), newPostRequest("/Serve/post/not/found", "q=a"))
+
+				issue := <-issues
+				Expect(issue.Type).To(Equal(CacheNotFound))
+				Expect(issue.URL.String()).To(Equal("http://domain.com/Serve/post/not/found"))
+				Expect(issue.Post.ContentType).To(Equal(formType))
+				Expect(string(issue.Post.Body)).To(Equal("q=a"))
+			})
+
+			It("should not allow post by default", func() {
+				root, _ := url.Parse("http://domain.com")
+				s := newServer()
+				w := httptest.NewRecorder()
+				s.Serve(root, w, newPostRequest("/Serve/post/disabled", "q=a"))
+
+				Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+			})
+
+			It("should not allow large body", func() {
+				root, _ := url.Parse("http://domain.com")
+				s := newServer()
+				s.SetCachePost(true)
+				w := httptest.NewRecorder()
+				s.Serve(root, w, newPostRequest("/Serve/post/large", strings.Repeat("a", PostBodyMaxSize+1)))
+
+				Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+			})
+
+			It("should not allow other methods", func() {
+				root, _ := url.Parse("http://domain.com")
+				s := newServer()
+				s.SetCachePost(true)
+				w := httptest.NewRecorder()
+				s.Serve(root, w, httptest.NewRequest("PUT", "/Serve/post/put", nil))
+
+				Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+			})
+		})
+
 		Context("cross-host", func() {
 			It("should response", func() {
 				s := newServer()